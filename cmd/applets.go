@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+)
+
+// Applet is a program gtclock runs when called by its name, or as
+// "gtclock <name>".
+type Applet struct {
+	Name    string
+	Summary string
+	Run     func(args []string) int
+
+	// flags defines the flags of the applet, for shell completion
+	flags func(fs *flag.FlagSet)
+	// args is what the arguments after the flags are
+	args argKind
+}
+
+// applets lists every applet, in the order help prints them.
+var applets = []Applet{
+	{
+		Name: "gtclockd", Summary: "TAICLOCK time server", Run: GTClockDRun,
+		flags: gtclockdFlags,
+	},
+	{
+		Name: "gtclockc", Summary: "TAICLOCK client", Run: GTClockCRun,
+		flags: func(fs *flag.FlagSet) { clientFlags(fs) }, args: argServers,
+	},
+	{
+		Name: "gsntpclockc", Summary: "SNTP client", Run: GSNTPClockCRun,
+		flags: func(fs *flag.FlagSet) { ntpFlags(fs, clientFlags(fs)) }, args: argServers,
+	},
+	{
+		Name: "gsntpclockd", Summary: "NTP time server", Run: GSNTPClockDRun,
+		flags: func(fs *flag.FlagSet) { gsntpclockdFlags(fs) },
+	},
+	{
+		Name: "gntpq", Summary: "NTP control query client", Run: GNTPQRun,
+		flags: func(fs *flag.FlagSet) { gntpqFlags(fs) }, args: argServers,
+	},
+	{
+		Name: "gtclockcheck", Summary: "check the offset of time servers, as a monitoring plugin", Run: GTClockCheckRun,
+		flags: func(fs *flag.FlagSet) { gtclockcheckFlags(fs) }, args: argServers,
+	},
+	{
+		Name: "gtailocal", Summary: "convert TAI64N labels to readable times", Run: GTAILocalRun,
+		flags: func(fs *flag.FlagSet) { localFlags(fs) }, args: argFiles,
+	},
+	{
+		Name: "gtai64n", Summary: "prefix lines with TAI64N labels", Run: GTAI64NRun,
+		flags: func(fs *flag.FlagSet) { stampFlags(fs) },
+	},
+	{
+		Name: "gtaiconv", Summary: "convert times to TAI64N labels and back", Run: GTAIConvRun,
+		flags: func(fs *flag.FlagSet) { convFlags(fs) },
+	},
+	{
+		Name: "gtaifilter", Summary: "select TAI64N stamped lines by time", Run: GTAIFilterRun,
+		flags: func(fs *flag.FlagSet) { filterFlags(fs) }, args: argFiles,
+	},
+}
+
+// subcommands are the commands of gtclock other than applets, help and
+// version.
+var subcommands = map[string]func(args []string) int{
+	"install":    installRun,
+	"completion": completionRun,
+}
+
+// Applets returns the applets gtclock provides.
+func Applets() []Applet {
+	return applets
+}
+
+// Lookup returns the program to run for name, which is an applet name or
+// gtclock itself, as appletName normalizes it.
+func Lookup(name string) (func(args []string) int, bool) {
+	name = appletName(name)
+	if name == "gtclock" {
+		return MainDispatcher, true
+	}
+	a, ok := lookupApplet(name)
+	return a.Run, ok
+}
+
+// lookupApplet finds an applet by name.
+func lookupApplet(name string) (Applet, bool) {
+	for _, a := range applets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Applet{}, false
+}
+
+// printHelp writes the gtclock usage and the list of applets.
+func printHelp(w io.Writer) {
+	_, _ = fmt.Fprintln(w, "usage: gtclock <applet> [arguments]")
+	_, _ = fmt.Fprintln(w, "       gtclock help [applet]")
+	_, _ = fmt.Fprintln(w, "       gtclock version")
+	_, _ = fmt.Fprintln(w, "       gtclock install [-s] [-l] [-r] [-f] <dir>")
+	_, _ = fmt.Fprintln(w, "       gtclock completion bash|zsh|fish")
+	_, _ = fmt.Fprintln(w)
+	_, _ = fmt.Fprintln(w, "Applets, also run when gtclock is called by their name:")
+	for _, a := range applets {
+		_, _ = fmt.Fprintf(w, "  %-12s %s\n", a.Name, a.Summary)
+	}
+}
+
+// helpRun prints the gtclock help, or the usage of the applet named by
+// args.
+func helpRun(args []string) int {
+	if len(args) == 0 {
+		printHelp(os.Stdout)
+		return 0
+	}
+	if run, ok := subcommands[args[0]]; ok {
+		return run([]string{"-h"})
+	}
+	a, ok := lookupApplet(args[0])
+	if !ok {
+		_, _ = fmt.Fprintf(os.Stderr, "Unknown command: %s\n", args[0])
+		return 1
+	}
+	return a.Run([]string{"-h"})
+}
+
+// versionInfo describes the build of the running binary.
+func versionInfo() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "gtclock (unknown version)\n"
+	}
+	s := fmt.Sprintf("gtclock %s\n", info.Main.Version)
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value
+		}
+	}
+	if revision != "" {
+		if modified == "true" {
+			revision += " (modified)"
+		}
+		s += fmt.Sprintf("revision: %s\n", revision)
+	}
+	return s + fmt.Sprintf("go: %s\n", info.GoVersion)
+}
+
+// setUsage makes fs print synopsis, which follows the applet name, above
+// its flags.
+func setUsage(fs *flag.FlagSet, synopsis string) {
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "usage: %s %s\n", fs.Name(), synopsis)
+		fs.PrintDefaults()
+	}
+}
+
+// parseFailed returns the exit code for a failed fs.Parse: success when
+// only the usage was asked for.
+func parseFailed(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	_, _ = fmt.Println(err)
+	return 111
+}
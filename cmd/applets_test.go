@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// silence sends standard output and error to /dev/null for the rest of
+// the test.
+func silence(t *testing.T) {
+	t.Helper()
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = null, null
+	t.Cleanup(func() {
+		os.Stdout, os.Stderr = stdout, stderr
+		_ = null.Close()
+	})
+}
+
+func TestAppletsUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for _, a := range Applets() {
+		if seen[a.Name] {
+			t.Errorf("applet %s listed twice", a.Name)
+		}
+		seen[a.Name] = true
+		if a.Summary == "" || a.Run == nil {
+			t.Errorf("applet %s lacks a summary or a run function", a.Name)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"gtclock", true},
+		{"gtclockd", true},
+		{"gsntpclockc", true},
+		{"gtaifilter", true},
+		{"gntpclock", false},
+		{"help", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn, ok := Lookup(tt.name)
+			if ok != tt.ok || (ok && fn == nil) {
+				t.Errorf("Lookup(%q) = %v, want %v", tt.name, ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestPrintHelpListsApplets(t *testing.T) {
+	var buf bytes.Buffer
+	printHelp(&buf)
+	for _, a := range Applets() {
+		if !strings.Contains(buf.String(), a.Name) {
+			t.Errorf("help does not list %s:\n%s", a.Name, buf.String())
+		}
+	}
+}
+
+func TestVersionInfo(t *testing.T) {
+	s := versionInfo()
+	if !strings.HasPrefix(s, "gtclock ") {
+		t.Errorf("versionInfo() = %q, want it to start with gtclock", s)
+	}
+	if !strings.Contains(s, runtime.Version()) {
+		t.Errorf("versionInfo() = %q, want it to report %s", s, runtime.Version())
+	}
+}
+
+func TestMainDispatcherExitCodes(t *testing.T) {
+	silence(t)
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"no arguments", nil, 1},
+		{"help", []string{"help"}, 0},
+		{"help flag", []string{"-h"}, 0},
+		{"help for applet", []string{"help", "gtaiconv"}, 0},
+		{"help for unknown applet", []string{"help", "nope"}, 1},
+		{"version", []string{"version"}, 0},
+		{"unknown applet", []string{"nope"}, 1},
+		{"applet exit code", []string{"gtaiconv", "not a time"}, 111},
+		{"applet success", []string{"gtaiconv", "-d", "@4000000000000000"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MainDispatcher(tt.args); got != tt.want {
+				t.Errorf("MainDispatcher(%q) = %d, want %d", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppletUsage(t *testing.T) {
+	silence(t)
+	for _, a := range Applets() {
+		t.Run(a.Name, func(t *testing.T) {
+			help, want := 0, 111
+			if a.Name == "gtclockcheck" {
+				// Monitoring plugins report help and usage errors as UNKNOWN
+				help, want = checkUnknown, checkUnknown
+			}
+			if got := a.Run([]string{"-h"}); got != help {
+				t.Errorf("%s -h = %d, want %d", a.Name, got, help)
+			}
+			if got := a.Run([]string{"-no-such-flag"}); got != want {
+				t.Errorf("%s -no-such-flag = %d, want %d", a.Name, got, want)
+			}
+		})
+	}
+}
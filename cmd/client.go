@@ -0,0 +1,316 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// clientSample is one measurement of a server.
+type clientSample struct {
+	server serverConfig
+	offset time.Duration
+	delay  time.Duration
+	// dispersion is the estimated error of the clock of the source
+	dispersion time.Duration
+	stratum    int
+	leap       int
+	unsynced   bool
+}
+
+// usable reports whether the offset of the sample may steer the clock.
+func (s clientSample) usable() bool {
+	return !s.unsynced && s.stratum < stratumUnsynchronized && !s.server.has(optNoSelect)
+}
+
+// measureFunc measures the offset of the local clock to a server.
+type measureFunc func(s serverConfig) (clientSample, error)
+
+// clientOptions holds the flags shared by gtclockc and gsntpclockc.
+type clientOptions struct {
+	config    string
+	step      time.Duration
+	poll      time.Duration
+	output    string
+	daemon    bool
+	saveClock bool
+	listen    string
+	iface     string
+	anySource bool
+	stats     string
+}
+
+// clientFlags defines the client flags on fs.
+func clientFlags(fs *flag.FlagSet) *clientOptions {
+	o := &clientOptions{}
+	fs.StringVar(&o.config, "config", clientConfigPath, "configuration file or directory")
+	fs.DurationVar(&o.step, "step", defaultStep, "step the clock for offsets from this up, slew it below; 0 always steps")
+	fs.DurationVar(&o.poll, "poll", defaultPoll, "interval between measurements with -D")
+	fs.StringVar(&o.output, "o", "text", "output: text or json")
+	fs.BoolVar(&o.daemon, "D", false, "keep measuring every poll interval")
+	fs.BoolVar(&o.saveClock, "saveclock", false, "adjust the clock, like the saveclock argument")
+	fs.StringVar(&o.stats, "stats", "", "append every sample to this file, with TAI64N labels")
+	return o
+}
+
+// argsParser parses the server and saveclock arguments of a client.
+type argsParser func(args []string) (net.IP, bool, error)
+
+// loadConfig reads the configuration, which may only be missing at its
+// default path, and lets the flags and arguments override it. The servers
+// are those speaking proto, or the one given as argument.
+func (o *clientOptions) loadConfig(fs *flag.FlagSet, proto string, parse argsParser) (clientConfig, error) {
+	cfg, err := loadClientConfig(o.config)
+	if errors.Is(err, os.ErrNotExist) && !isFlagSet(fs, "config") {
+		cfg, err = defaultClientConfig(), nil
+	}
+	if err != nil {
+		return clientConfig{}, err
+	}
+	cfg.servers = cfg.serversFor(proto)
+	if fs.NArg() > 0 {
+		ip, save, err := parse(fs.Args())
+		if err != nil {
+			return clientConfig{}, err
+		}
+		cfg.servers = []serverConfig{{host: ip.String(), proto: proto, weight: 1}}
+		o.saveClock = o.saveClock || save
+	}
+	if len(cfg.servers) == 0 && o.listen == "" {
+		return clientConfig{}, fmt.Errorf("no %s servers: give one or list them in %s", proto, o.config)
+	}
+	return cfg, o.override(fs, &cfg)
+}
+
+// override applies the flags given on the command line to cfg.
+func (o *clientOptions) override(fs *flag.FlagSet, cfg *clientConfig) error {
+	if isFlagSet(fs, "step") {
+		cfg.step = o.step
+	}
+	if isFlagSet(fs, "poll") {
+		cfg.poll = o.poll
+	}
+	if isFlagSet(fs, "stats") {
+		cfg.stats = o.stats
+	}
+	if err := errors.Join(checkStep(cfg.step), checkPoll(cfg.poll)); err != nil {
+		return err
+	}
+	if !isFlagSet(fs, "o") {
+		return nil
+	}
+	var err error
+	cfg.output, err = parseClientOutput(o.output)
+	return err
+}
+
+// selectSamples returns the usable samples, only the preferred ones when
+// any of those answered.
+func selectSamples(samples []clientSample) []clientSample {
+	var usable, preferred []clientSample
+	for _, s := range samples {
+		if !s.usable() {
+			continue
+		}
+		usable = append(usable, s)
+		if s.server.has(optPrefer) {
+			preferred = append(preferred, s)
+		}
+	}
+	if len(preferred) > 0 {
+		return preferred
+	}
+	return usable
+}
+
+// combineSamples returns the weighted mean offset of the selected samples.
+func combineSamples(samples []clientSample) (time.Duration, bool) {
+	var sum, weights float64
+	for _, s := range selectSamples(samples) {
+		sum += float64(s.offset) * s.server.weight
+		weights += s.server.weight
+	}
+	if weights == 0 {
+		return 0, false
+	}
+	return time.Duration(sum / weights), true
+}
+
+// disciplineStep is the step threshold of the servers disciplining the
+// clock to their reference clocks and peers, which slew small offsets.
+const disciplineStep = 128 * time.Millisecond
+
+// adjustClock steps the clock for offsets of step and above, and slews it
+// for smaller ones. A zero step always steps.
+func adjustClock(offset, step time.Duration) (string, error) {
+	if step > 0 && offset.Abs() < step {
+		return "slew", slewSystemClock(offset)
+	}
+	return "step", setSystemClock(offset)
+}
+
+// clientReport is the output of a client.
+type clientReport struct {
+	Server       string  `json:"server,omitempty"`
+	Offset       float64 `json:"offset"`
+	Delay        float64 `json:"delay,omitempty"`
+	Dispersion   float64 `json:"dispersion,omitempty"`
+	Stratum      int     `json:"stratum,omitempty"`
+	Leap         int     `json:"leap,omitempty"`
+	Synchronized *bool   `json:"synchronized,omitempty"`
+	Action       string  `json:"action,omitempty"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// clientRun measures the configured servers and adjusts the clock.
+type clientRun struct {
+	cfg       clientConfig
+	measure   measureFunc
+	saveClock bool
+	out       io.Writer
+	enc       *json.Encoder
+}
+
+// newClientRun returns a run writing its reports to out.
+func newClientRun(cfg clientConfig, measure measureFunc, saveClock bool, out io.Writer) *clientRun {
+	return &clientRun{cfg: cfg, measure: measure, saveClock: saveClock, out: out, enc: json.NewEncoder(out)}
+}
+
+// reportSample writes the result of measuring a server.
+func (r *clientRun) reportSample(s clientSample) {
+	if r.cfg.output == "json" {
+		synced := !s.unsynced
+		_ = r.enc.Encode(clientReport{
+			Server: s.server.host, Offset: s.offset.Seconds(), Delay: s.delay.Seconds(),
+			Dispersion: s.dispersion.Seconds(), Stratum: s.stratum, Leap: s.leap, Synchronized: &synced,
+		})
+		return
+	}
+	_, _ = fmt.Fprintf(r.out, "server: %s offset: %v delay: %v stratum: %d leap: %d\n",
+		s.server.host, s.offset, s.delay, s.stratum, s.leap)
+	if s.unsynced {
+		_, _ = fmt.Fprintln(r.out, "server clock is not synchronized")
+	}
+}
+
+// reportError writes why a server, or the whole run when server is empty,
+// failed.
+func (r *clientRun) reportError(server string, err error) {
+	if r.cfg.output == "json" {
+		_ = r.enc.Encode(clientReport{Server: server, Error: err.Error()})
+		return
+	}
+	if server != "" {
+		_, _ = fmt.Fprintf(r.out, "server: %s ", server)
+	}
+	_, _ = fmt.Fprintln(r.out, err)
+}
+
+// reportAction writes the combined offset and what was done about it.
+func (r *clientRun) reportAction(offset time.Duration, action string) {
+	if r.cfg.output == "json" {
+		_ = r.enc.Encode(clientReport{Offset: offset.Seconds(), Action: action})
+		return
+	}
+	_, _ = fmt.Fprintf(r.out, "offset: %v action: %s\n", offset, action)
+}
+
+// measureAll measures every server, reporting each result.
+func (r *clientRun) measureAll() []clientSample {
+	var samples []clientSample
+	for _, s := range r.cfg.servers {
+		sample, err := r.measure(s)
+		if err != nil {
+			r.reportError(s.host, err)
+			continue
+		}
+		if sample.server.host == "" {
+			sample.server = s
+		}
+		r.reportSample(sample)
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+// once measures the servers, adjusts the clock when asked to and records
+// the samples, and returns the exit code.
+func (r *clientRun) once() int {
+	samples := r.measureAll()
+	action, code := r.act(samples)
+	r.writeStats(samples, action)
+	return code
+}
+
+// act adjusts the clock by the combined offset of samples when asked to,
+// and returns the action taken and the exit code.
+func (r *clientRun) act(samples []clientSample) (string, int) {
+	offset, ok := combineSamples(samples)
+	if !ok {
+		r.reportError("", errors.New("no usable server"))
+		return "none", 111
+	}
+	action := "none"
+	if r.saveClock {
+		var err error
+		if action, err = adjustClock(offset, r.cfg.step); err != nil {
+			r.reportError("", err)
+			return "failed", 111
+		}
+	}
+	r.reportAction(offset, action)
+	return action, 0
+}
+
+// run measures once, or every poll interval for ever when daemon is set.
+func (r *clientRun) run(daemon bool) int {
+	for {
+		code := r.once()
+		if !daemon {
+			return code
+		}
+		time.Sleep(r.cfg.poll)
+	}
+}
+
+// clientProtocol is what sets gtclockc and gsntpclockc apart.
+type clientProtocol struct {
+	proto   string
+	parse   argsParser
+	measure measureFunc
+}
+
+// runClient is the body of gtclockc and gsntpclockc, once their flags
+// are defined on fs.
+func runClient(fs *flag.FlagSet, opts *clientOptions, p clientProtocol, args []string) int {
+	setUsage(fs, "[flags] [<server_ip> [saveclock]]")
+	if err := fs.Parse(args); err != nil {
+		return parseFailed(err)
+	}
+	cfg, err := opts.loadConfig(fs, p.proto, p.parse)
+	if err != nil {
+		_, _ = fmt.Println(err)
+		return 111
+	}
+	refclocks, err := openClientRefclocks(cfg.servers)
+	if err != nil {
+		_, _ = fmt.Println(err)
+		return 111
+	}
+	defer refclocks.close()
+	run := newClientRun(cfg, refclocks.measure(p.measure, cfg.poll), opts.saveClock, os.Stdout)
+	closer, err := opts.listenRun(run)
+	if err != nil {
+		_, _ = fmt.Println(err)
+		return 111
+	}
+	if closer != nil {
+		defer func() { _ = closer.Close() }()
+	}
+	return run.run(opts.daemon)
+}
@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCombineSamples(t *testing.T) {
+	srv := func(weight float64, options ...string) serverConfig {
+		return serverConfig{host: "h", weight: weight, options: options}
+	}
+	tests := []struct {
+		name    string
+		samples []clientSample
+		want    time.Duration
+		wantOK  bool
+	}{
+		{"none", nil, 0, false},
+		{"one", []clientSample{{server: srv(1), offset: time.Second}}, time.Second, true},
+		{"weighted", []clientSample{
+			{server: srv(1), offset: 0},
+			{server: srv(3), offset: 4 * time.Millisecond},
+		}, 3 * time.Millisecond, true},
+		{"preferred only", []clientSample{
+			{server: srv(1), offset: time.Second},
+			{server: srv(1, optPrefer), offset: time.Millisecond},
+		}, time.Millisecond, true},
+		{"unusable skipped", []clientSample{
+			{server: srv(1), offset: time.Hour, unsynced: true},
+			{server: srv(1), offset: time.Hour, stratum: stratumUnsynchronized},
+			{server: srv(1, optNoSelect, optPrefer), offset: time.Hour},
+			{server: srv(1), offset: time.Millisecond},
+		}, time.Millisecond, true},
+		{"nothing usable", []clientSample{{server: srv(1), unsynced: true}}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := combineSamples(tt.samples)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("combineSamples() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// clientFlagSet parses args with the client flags.
+func clientFlagSet(t *testing.T, args ...string) (*flag.FlagSet, *clientOptions) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts := clientFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return fs, opts
+}
+
+func TestClientLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.conf")
+	writeFile(t, path, "server 192.0.2.1\nserver 192.0.2.2 proto=ntp\nrefclock shm:2\nstep 1s\npoll 10s\noutput json\n")
+
+	fs, opts := clientFlagSet(t, "-config", path, "-step", "2s")
+	cfg, err := opts.loadConfig(fs, protoTAI, parseGTClockArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.servers) != 2 || cfg.servers[0].host != "192.0.2.1" || cfg.servers[1].proto != protoRefclock {
+		t.Errorf("servers = %+v, want the tai one and the reference clock", cfg.servers)
+	}
+	if cfg.step != 2*time.Second || cfg.poll != 10*time.Second || cfg.output != "json" {
+		t.Errorf("flags did not override the file as expected: %+v", cfg)
+	}
+
+	// An argument replaces the servers of the file
+	fs, opts = clientFlagSet(t, "-config", path, "-o", "text", "198.51.100.1", "saveclock")
+	cfg, err = opts.loadConfig(fs, protoNTP, parseNTPArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.servers) != 1 || cfg.servers[0].host != "198.51.100.1" || cfg.servers[0].proto != protoNTP {
+		t.Errorf("servers = %+v, want the argument", cfg.servers)
+	}
+	if !opts.saveClock || cfg.output != "text" {
+		t.Errorf("saveclock = %v, output = %s", opts.saveClock, cfg.output)
+	}
+
+	// Without a configuration the clock is always stepped
+	fs, opts = clientFlagSet(t, "-config", t.TempDir(), "198.51.100.1", "saveclock")
+	cfg, err = opts.loadConfig(fs, protoTAI, parseGTClockArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.step != 0 {
+		t.Errorf("step = %v without a configuration, want 0", cfg.step)
+	}
+}
+
+func TestClientLoadConfigErrors(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.conf")
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"explicit missing file", []string{"-config", missing, "192.0.2.1"}},
+		{"no servers", []string{"-config", t.TempDir()}},
+		{"bad argument", []string{"-config", t.TempDir(), "not-an-ip"}},
+		{"bad output", []string{"-config", t.TempDir(), "-o", "xml", "192.0.2.1"}},
+		{"zero poll", []string{"-config", t.TempDir(), "-poll", "0s", "192.0.2.1"}},
+		{"negative step", []string{"-config", t.TempDir(), "-step", "-1s", "192.0.2.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, opts := clientFlagSet(t, tt.args...)
+			if _, err := opts.loadConfig(fs, protoTAI, parseGTClockArgs); err == nil {
+				t.Errorf("loadConfig(%q) succeeded", tt.args)
+			}
+		})
+	}
+}
+
+func TestClientRunOnce(t *testing.T) {
+	cfg := defaultClientConfig()
+	cfg.output = "json"
+	cfg.servers = []serverConfig{
+		{host: "good", weight: 1},
+		{host: "down", weight: 1},
+	}
+	measure := func(s serverConfig) (clientSample, error) {
+		if s.host == "down" {
+			return clientSample{}, errors.New("timeout")
+		}
+		return clientSample{offset: 2 * time.Millisecond, delay: time.Millisecond, stratum: 1}, nil
+	}
+	var out bytes.Buffer
+	if got := newClientRun(cfg, measure, false, &out).once(); got != 0 {
+		t.Fatalf("once() = %d, want 0", got)
+	}
+
+	var reports []clientReport
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var r clientReport
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		reports = append(reports, r)
+	}
+	if len(reports) != 3 {
+		t.Fatalf("got %d reports, want 3: %+v", len(reports), reports)
+	}
+	if reports[0].Server != "good" || reports[0].Offset != 0.002 || reports[0].Synchronized == nil || !*reports[0].Synchronized {
+		t.Errorf("sample report = %+v", reports[0])
+	}
+	if reports[1].Server != "down" || reports[1].Error != "timeout" {
+		t.Errorf("error report = %+v", reports[1])
+	}
+	if reports[2].Action != "none" || reports[2].Offset != 0.002 {
+		t.Errorf("action report = %+v", reports[2])
+	}
+}
+
+func TestClientRunNoUsableServer(t *testing.T) {
+	cfg := defaultClientConfig()
+	cfg.servers = []serverConfig{{host: "unsynced", weight: 1}}
+	measure := func(serverConfig) (clientSample, error) {
+		return clientSample{unsynced: true}, nil
+	}
+	var out bytes.Buffer
+	if got := newClientRun(cfg, measure, true, &out).once(); got != 111 {
+		t.Errorf("once() = %d, want 111", got)
+	}
+	if !strings.Contains(out.String(), "not synchronized") || !strings.Contains(out.String(), "no usable server") {
+		t.Errorf("output = %q", out.String())
+	}
+}
+
+func TestMeasureTAI(t *testing.T) {
+	conn := startTAINServer(t, sendResponse)
+	addr := conn.RemoteAddr().(*net.UDPAddr)
+	_ = conn.Close()
+
+	s := serverConfig{host: addr.IP.String(), proto: protoTAI, port: strconv.Itoa(addr.Port)}
+	sample, err := measureTAI(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sample.offset.Abs() > 10*time.Millisecond {
+		t.Errorf("offset = %v, want about 0", sample.offset)
+	}
+}
@@ -0,0 +1,354 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karasz/gtclock/refclock"
+)
+
+// Protocols a configured server can speak.
+const (
+	protoTAI = "tai"
+	protoNTP = "ntp"
+	// protoRefclock marks the reference clocks of refclock lines, whose
+	// host is the specification of the clock
+	protoRefclock = "refclock"
+)
+
+// clientConfigPath is where the clients look for their configuration.
+const clientConfigPath = "/etc/gtclock/client.conf"
+
+// Client defaults, used when neither the configuration nor the flags set a
+// value.
+const (
+	// defaultStep always steps, so a saveclock run has set the clock when
+	// it exits
+	defaultStep = 0
+	defaultPoll = 64 * time.Second
+)
+
+// Server options.
+const (
+	// optPrefer restricts the offset to the preferred servers that answer
+	optPrefer = "prefer"
+	// optNoSelect reports the server without using its offset
+	optNoSelect = "noselect"
+)
+
+// serverConfig is a server the clients query.
+type serverConfig struct {
+	host    string
+	proto   string
+	port    string
+	weight  float64
+	options []string
+}
+
+// clientConfig is the configuration shared by gtclockc and gsntpclockc.
+type clientConfig struct {
+	servers []serverConfig
+	step    time.Duration
+	poll    time.Duration
+	output  string
+	// stats is the file samples are appended to, none when empty
+	stats string
+}
+
+// defaultClientConfig returns the configuration without any file.
+func defaultClientConfig() clientConfig {
+	return clientConfig{step: defaultStep, poll: defaultPoll, output: "text"}
+}
+
+// has reports whether the server has an option.
+func (s serverConfig) has(option string) bool {
+	for _, o := range s.options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// address returns host:port, with the default port of the protocol.
+func (s serverConfig) address() string {
+	port := s.port
+	if port == "" {
+		port = "4014"
+		if s.proto == protoNTP {
+			port = "123"
+		}
+	}
+	return net.JoinHostPort(s.host, port)
+}
+
+// serversFor returns the servers speaking proto, and the reference clocks.
+func (c clientConfig) serversFor(proto string) []serverConfig {
+	var servers []serverConfig
+	for _, s := range c.servers {
+		if s.proto == proto || s.proto == protoRefclock {
+			servers = append(servers, s)
+		}
+	}
+	return servers
+}
+
+// loadClientConfig reads a configuration file, or a directory holding one
+// value per file like the one of gtclockd.
+func loadClientConfig(path string) (clientConfig, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return clientConfig{}, err
+	}
+	if info.IsDir() {
+		return loadConfigDir(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return clientConfig{}, err
+	}
+	defer func() { _ = f.Close() }()
+	return parseClientConfig(f)
+}
+
+// parseClientConfig parses a configuration file. Every line is a server,
+//
+//	server <host> [proto=tai|ntp] [port=N] [weight=W] [prefer] [noselect]
+//
+// a reference clock, measured like a server,
+//
+//	refclock <driver:device[,option=value...]> [weight=W] [prefer] [noselect]
+//
+// or a global setting: step <duration>, poll <duration>, output text|json
+// or stats <file>. # starts a comment.
+func parseClientConfig(r io.Reader) (clientConfig, error) {
+	c := defaultClientConfig()
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if err := c.parseLine(fields); err != nil {
+			return clientConfig{}, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return c, sc.Err()
+}
+
+// parseLine parses the fields of one line.
+func (c *clientConfig) parseLine(fields []string) error {
+	switch fields[0] {
+	case "server":
+		s, err := parseServerLine(fields[1:])
+		c.servers = append(c.servers, s)
+		return err
+	case "refclock":
+		s, err := parseRefclockLine(fields[1:])
+		c.servers = append(c.servers, s)
+		return err
+	}
+	if len(fields) != 2 {
+		return fmt.Errorf("%s wants one value", fields[0])
+	}
+	return c.setGlobal(fields[0], fields[1])
+}
+
+// parseServerLine parses the host and settings of a server line.
+func parseServerLine(fields []string) (serverConfig, error) {
+	if len(fields) == 0 {
+		return serverConfig{}, errors.New("server wants a host")
+	}
+	s := serverConfig{host: fields[0], proto: protoTAI, weight: 1}
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			s.options = append(s.options, field)
+		} else if err := s.set(key, value); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// errNoAuth is returned for a server key: neither protocol authenticates.
+var errNoAuth = errors.New("authentication not supported, remove the key")
+
+// errClientPPS is returned for a PPS reference clock in the client
+// configuration: the clients have no other clock to number its seconds.
+var errClientPPS = errors.New("the clients cannot use a pps reference clock, give it to a server")
+
+// parseRefclockLine parses the specification and settings of a refclock
+// line.
+func parseRefclockLine(fields []string) (serverConfig, error) {
+	if len(fields) == 0 {
+		return serverConfig{}, errors.New("refclock wants a driver:device specification")
+	}
+	s, err := parseServerLine(fields)
+	if err != nil {
+		return s, err
+	}
+	if s.proto != protoTAI || s.port != "" {
+		return s, errors.New("refclock only takes weight and options")
+	}
+	s.proto = protoRefclock
+	name, _, _, err := refclock.ParseSpec(s.host)
+	if err == nil && name == "pps" {
+		err = errClientPPS
+	}
+	return s, err
+}
+
+// setGlobal sets a global setting.
+func (c *clientConfig) setGlobal(key, value string) error {
+	var err error
+	switch key {
+	case "step":
+		if c.step, err = time.ParseDuration(value); err == nil {
+			err = checkStep(c.step)
+		}
+	case "poll":
+		if c.poll, err = time.ParseDuration(value); err == nil {
+			err = checkPoll(c.poll)
+		}
+	case "output":
+		c.output, err = parseClientOutput(value)
+	case "stats":
+		c.stats = value
+	default:
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	return err
+}
+
+// checkStep checks a step threshold.
+func checkStep(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("invalid step %v, want 0 or more", d)
+	}
+	return nil
+}
+
+// checkPoll checks a poll interval, which must not be 0 lest -D spins.
+func checkPoll(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("invalid poll %v, want more than 0", d)
+	}
+	return nil
+}
+
+// parseClientOutput checks an output format of the clients.
+func parseClientOutput(s string) (string, error) {
+	if s != "text" && s != "json" {
+		return "", fmt.Errorf("unknown output %q, want text or json", s)
+	}
+	return s, nil
+}
+
+// set sets a key=value server setting.
+func (s *serverConfig) set(key, value string) error {
+	switch key {
+	case "proto":
+		if value != protoTAI && value != protoNTP {
+			return fmt.Errorf("unknown protocol %q, want tai or ntp", value)
+		}
+		s.proto = value
+	case "port":
+		if p, err := strconv.Atoi(value); err != nil || p < 1 || p > 65535 {
+			return fmt.Errorf("invalid port %q", value)
+		}
+		s.port = value
+	case "key":
+		// Accepting a key would let the exchanges pass for authenticated
+		return errNoAuth
+	case "weight":
+		w, err := strconv.ParseFloat(value, 64)
+		if err != nil || w <= 0 {
+			return fmt.Errorf("invalid weight %q", value)
+		}
+		s.weight = w
+	default:
+		return fmt.Errorf("unknown server setting %q", key)
+	}
+	return nil
+}
+
+// readConfigValue reads the value of a one value file. ok is false when
+// the file does not exist.
+func readConfigValue(path string) (value string, ok bool, err error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimSpace(string(b)), true, nil
+}
+
+// loadConfigDir reads a configuration directory: the files step, poll,
+// output and stats hold the global settings, and every directory in servers is a
+// server, named after its host, whose files proto, port and weight
+// hold its settings and options its options. A key file is refused.
+func loadConfigDir(dir string) (clientConfig, error) {
+	c := defaultClientConfig()
+	for _, key := range []string{"step", "poll", "output", "stats"} {
+		value, ok, err := readConfigValue(filepath.Join(dir, key))
+		if err == nil && ok {
+			err = c.setGlobal(key, value)
+		}
+		if err != nil {
+			return clientConfig{}, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	var err error
+	c.servers, err = loadServerDirs(filepath.Join(dir, "servers"))
+	return c, err
+}
+
+// loadServerDirs reads the servers of a configuration directory.
+func loadServerDirs(dir string) ([]serverConfig, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	var servers []serverConfig
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		s, err := loadServerDir(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		servers = append(servers, s)
+	}
+	return servers, err
+}
+
+// loadServerDir reads the settings of one server of a configuration
+// directory.
+func loadServerDir(dir string) (serverConfig, error) {
+	s := serverConfig{host: filepath.Base(dir), proto: protoTAI, weight: 1}
+	for _, key := range []string{"proto", "port", "key", "weight"} {
+		value, ok, err := readConfigValue(filepath.Join(dir, key))
+		if err == nil && ok {
+			err = s.set(key, value)
+		}
+		if err != nil {
+			return serverConfig{}, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	options, _, err := readConfigValue(filepath.Join(dir, "options"))
+	s.options = strings.Fields(options)
+	return s, err
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseClientConfig(t *testing.T) {
+	conf := `# lab servers
+server 192.0.2.1 prefer
+server ntp.example.com proto=ntp port=1123 weight=2.5 noselect # backup
+refclock shm:0,precision=-20,trim=-2ms weight=4 prefer
+step 1s
+poll 5m
+output json
+`
+	c, err := parseClientConfig(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := clientConfig{
+		servers: []serverConfig{
+			{host: "192.0.2.1", proto: protoTAI, weight: 1, options: []string{optPrefer}},
+			{host: "ntp.example.com", proto: protoNTP, port: "1123", weight: 2.5, options: []string{optNoSelect}},
+			{host: "shm:0,precision=-20,trim=-2ms", proto: protoRefclock, weight: 4, options: []string{optPrefer}},
+		},
+		step:   time.Second,
+		poll:   5 * time.Minute,
+		output: "json",
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("parseClientConfig() = %+v, want %+v", c, want)
+	}
+}
+
+func TestParseClientConfigDefaults(t *testing.T) {
+	c, err := parseClientConfig(strings.NewReader("\n# nothing\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c, defaultClientConfig()) {
+		t.Errorf("empty configuration = %+v, want the defaults", c)
+	}
+}
+
+func TestParseClientConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		conf string
+		want string
+	}{
+		{"unknown setting", "drift 1\n", "line 1: unknown setting"},
+		{"missing value", "step\n", "line 1: step wants one value"},
+		{"bad duration", "\nstep fast\n", "line 2"},
+		{"bad output", "output xml\n", "unknown output"},
+		{"zero poll", "poll 0s\n", "invalid poll"},
+		{"negative poll", "poll -1m\n", "invalid poll"},
+		{"negative step", "step -1s\n", "invalid step"},
+		{"missing host", "server\n", "server wants a host"},
+		{"bad protocol", "server h proto=ptp\n", "unknown protocol"},
+		{"bad port", "server h port=70000\n", "invalid port"},
+		{"bad weight", "server h weight=0\n", "invalid weight"},
+		{"unknown server setting", "server h poll=1\n", "unknown server setting"},
+		{"server key", "server h key=k1\n", errNoAuth.Error()},
+		{"missing refclock", "refclock\n", "refclock wants a driver"},
+		{"unknown driver", "refclock gps:0\n", "unknown reference clock driver"},
+		{"refclock port", "refclock shm:0 port=123\n", "refclock only takes weight"},
+		{"client pps", "refclock pps:/dev/pps0\n", errClientPPS.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseClientConfig(strings.NewReader(tt.conf))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseClientConfig(%q) error = %v, want %q", tt.conf, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerAddress(t *testing.T) {
+	tests := []struct {
+		s    serverConfig
+		want string
+	}{
+		{serverConfig{host: "192.0.2.1", proto: protoTAI}, "192.0.2.1:4014"},
+		{serverConfig{host: "192.0.2.1", proto: protoNTP}, "192.0.2.1:123"},
+		{serverConfig{host: "2001:db8::1", proto: protoNTP}, "[2001:db8::1]:123"},
+		{serverConfig{host: "time.example.com", proto: protoTAI, port: "4015"}, "time.example.com:4015"},
+	}
+	for _, tt := range tests {
+		if got := tt.s.address(); got != tt.want {
+			t.Errorf("%+v.address() = %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "step"), "500ms\n")
+	writeFile(t, filepath.Join(dir, "output"), "json\n")
+	for _, host := range []string{"192.0.2.1", "192.0.2.2"} {
+		if err := os.MkdirAll(filepath.Join(dir, "servers", host), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(dir, "servers", "192.0.2.2", "proto"), "ntp\n")
+	writeFile(t, filepath.Join(dir, "servers", "192.0.2.2", "weight"), "3\n")
+	writeFile(t, filepath.Join(dir, "servers", "192.0.2.2", "options"), "prefer noselect\n")
+
+	c, err := loadClientConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := clientConfig{
+		servers: []serverConfig{
+			{host: "192.0.2.1", proto: protoTAI, weight: 1, options: []string{}},
+			{host: "192.0.2.2", proto: protoNTP, weight: 3, options: []string{optPrefer, optNoSelect}},
+		},
+		step:   500 * time.Millisecond,
+		poll:   defaultPoll,
+		output: "json",
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("loadClientConfig(dir) = %+v, want %+v", c, want)
+	}
+
+	writeFile(t, filepath.Join(dir, "servers", "192.0.2.1", "port"), "0\n")
+	if _, err := loadClientConfig(dir); err == nil || !strings.Contains(err.Error(), "192.0.2.1: port") {
+		t.Errorf("bad port file error = %v", err)
+	}
+}
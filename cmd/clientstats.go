@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/karasz/glibtai"
+)
+
+// Client Statistics:
+//
+// With -stats, or a stats line in the configuration, the clients append a
+// line per sample to a file, labelled like the lines of multilog so that
+// gtailocal and the tools reading daemontools logs read it:
+//
+//   @4000000068f3a1c52b1d4c20 server=192.0.2.1 offset=0.000123456 delay=0.000789012 dispersion=0.000012000 action=slew
+//
+// offset, delay and dispersion are in seconds and action is what the run
+// the sample belongs to did to the clock: none, slew, step or failed.
+
+// appendStatsLine appends the statistics line of a sample.
+func appendStatsLine(dst []byte, now glibtai.TAIN, s clientSample, action string) []byte {
+	dst = appendTAINStamp(dst, now)
+	dst = append(dst, " server="...)
+	dst = append(dst, s.server.host...)
+	for _, v := range []struct {
+		name  string
+		value float64
+	}{
+		{" offset=", s.offset.Seconds()},
+		{" delay=", s.delay.Seconds()},
+		{" dispersion=", s.dispersion.Seconds()},
+	} {
+		dst = append(dst, v.name...)
+		dst = strconv.AppendFloat(dst, v.value, 'f', 9, 64)
+	}
+	dst = append(dst, " action="...)
+	dst = append(dst, action...)
+	return append(dst, '\n')
+}
+
+// writeStats appends the samples of a run to the statistics file, when
+// there is one. The file is opened for every run, so it can be rotated.
+func (r *clientRun) writeStats(samples []clientSample, action string) {
+	if r.cfg.stats == "" || len(samples) == 0 {
+		return
+	}
+	now := glibtai.TAINNow()
+	var buf []byte
+	for _, s := range samples {
+		buf = appendStatsLine(buf, now, s, action)
+	}
+	f, err := os.OpenFile(r.cfg.stats, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err == nil {
+		_, err = f.Write(buf)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		r.reportError("", fmt.Errorf("stats: %w", err))
+	}
+}
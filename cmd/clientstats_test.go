@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karasz/glibtai"
+)
+
+func TestAppendStatsLine(t *testing.T) {
+	now := glibtai.TAINfromTime(time.Date(2025, 10, 18, 12, 0, 0, 0, time.UTC))
+	s := clientSample{
+		server: serverConfig{host: "192.0.2.1"},
+		offset: -1234567 * time.Nanosecond, delay: 789 * time.Microsecond, dispersion: 12 * time.Microsecond,
+	}
+	got := string(appendStatsLine(nil, now, s, "slew"))
+	label := string(appendTAINStamp(nil, now))
+	want := label + " server=192.0.2.1 offset=-0.001234567 delay=0.000789000 dispersion=0.000012000 action=slew\n"
+	if got != want {
+		t.Errorf("appendStatsLine() =\n%q, want\n%q", got, want)
+	}
+	if converted := processline(got); strings.Contains(converted, label) || !strings.Contains(converted, "2025-10-18 12:00:00") {
+		t.Errorf("gtailocal converted the line to %q", converted)
+	}
+}
+
+func TestClientRunStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats")
+	cfg := defaultClientConfig()
+	cfg.stats = path
+	cfg.servers = []serverConfig{{host: "good", weight: 1}, {host: "bad", weight: 1}}
+	measure := func(s serverConfig) (clientSample, error) {
+		if s.host == "bad" {
+			return clientSample{}, os.ErrDeadlineExceeded
+		}
+		return clientSample{offset: time.Millisecond, delay: 2 * time.Millisecond, stratum: 2}, nil
+	}
+	var out bytes.Buffer
+	run := newClientRun(cfg, measure, false, &out)
+	for range 2 {
+		if code := run.once(); code != 0 {
+			t.Fatalf("once() = %d: %s", code, out.String())
+		}
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("stats file %q, want a line per run", b)
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "@4") || !strings.HasSuffix(line, " server=good offset=0.001000000 delay=0.002000000 dispersion=0.000000000 action=none") {
+			t.Errorf("stats line %q", line)
+		}
+	}
+
+	// A run without a usable server still records its samples
+	cfg.servers = []serverConfig{{host: "good", weight: 1, options: []string{optNoSelect}}}
+	cfg.stats = filepath.Join(t.TempDir(), "missing", "stats")
+	out.Reset()
+	run = newClientRun(cfg, measure, false, &out)
+	if code := run.once(); code != 111 || !strings.Contains(out.String(), "stats: ") {
+		t.Errorf("once() = %d, output %q", code, out.String())
+	}
+}
+
+func TestClientStatsConfig(t *testing.T) {
+	c, err := parseClientConfig(strings.NewReader("stats /var/log/gtclock/stats\n"))
+	if err != nil || c.stats != "/var/log/gtclock/stats" {
+		t.Errorf("stats = %q, %v", c.stats, err)
+	}
+
+	fs, opts := clientFlagSet(t, "-stats", "/tmp/stats", "192.0.2.1")
+	cfg, err := opts.loadConfig(fs, protoTAI, parseGTClockArgs)
+	if err != nil || cfg.stats != "/tmp/stats" {
+		t.Errorf("stats = %q, %v", cfg.stats, err)
+	}
+}
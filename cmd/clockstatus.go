@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// statusRefresh is how often the kernel clock state is sampled.
+const statusRefresh = time.Second
+
+// kernelMaxError is the maximum error past which the clock is taken as
+// unsynchronized, the 16s NTP deems too dispersed to use.
+const kernelMaxError = 16 * time.Second
+
+var errKernelClockUnsupported = errors.New("kernel clock status is not available on this platform")
+
+// kernelClock is the synchronization state reported by the kernel.
+type kernelClock struct {
+	unsynced bool
+	leap     byte
+	// maxError bounds the error of the clock, and estError estimates it
+	maxError time.Duration
+	estError time.Duration
+}
+
+// unsyncPolicy decides what a server does while its clock is unsynchronized.
+type unsyncPolicy int
+
+const (
+	// unsyncMark keeps answering and flags the clock where the protocol allows
+	unsyncMark unsyncPolicy = iota
+	// unsyncRefuse stops answering
+	unsyncRefuse
+	// unsyncIgnore does not check the kernel state at all
+	unsyncIgnore
+)
+
+// parseUnsyncPolicy parses the value of the -unsync flag.
+func parseUnsyncPolicy(s string) (unsyncPolicy, error) {
+	switch s {
+	case "mark":
+		return unsyncMark, nil
+	case "refuse":
+		return unsyncRefuse, nil
+	case "ignore":
+		return unsyncIgnore, nil
+	default:
+		return unsyncMark, fmt.Errorf("invalid unsync policy %q, use mark, refuse or ignore", s)
+	}
+}
+
+// statusMonitor caches the clock status of a server, refreshing it from the
+// kernel at most once per statusRefresh.
+type statusMonitor struct {
+	policy    unsyncPolicy
+	threshold time.Duration
+	read      func() (kernelClock, error)
+	status    atomic.Pointer[clockStatus]
+	checked   atomic.Int64
+	// refclocks, when set, take over from the kernel state while one of
+	// them has fresh samples
+	refclocks *refclockSet
+}
+
+// newStatusMonitor creates a monitor marking the clock unsynchronized when the
+// kernel says so, its maximum error exceeds kernelMaxError or its estimated
+// error exceeds threshold (0 disables).
+func newStatusMonitor(policy unsyncPolicy, threshold time.Duration) *statusMonitor {
+	m := &statusMonitor{policy: policy, threshold: threshold, read: readKernelClock}
+	m.refresh()
+	return m
+}
+
+// deriveStatus turns the kernel state into the status served to clients.
+func (m *statusMonitor) deriveStatus(k kernelClock, err error) clockStatus {
+	st := clockStatus{
+		leap:      leapNone,
+		stratum:   hostClockStratum,
+		precision: clockPrecision(),
+	}
+	if err != nil || m.policy == unsyncIgnore {
+		return st
+	}
+
+	st.leap = k.leap
+	// The error served is the bound, as root dispersion is
+	st.estError = k.maxError
+	if k.unsynced || k.maxError > kernelMaxError || (m.threshold > 0 && k.estError > m.threshold) {
+		st.unsynced = true
+		st.leap = leapUnknown
+		st.stratum = stratumUnsynchronized
+	}
+	return st
+}
+
+// refresh samples the kernel clock state.
+func (m *statusMonitor) refresh() {
+	k, err := m.read()
+	st := m.deriveStatus(k, err)
+	m.status.Store(&st)
+	m.checked.Store(time.Now().UnixNano())
+}
+
+// current returns the cached status, refreshing it when stale, or that of
+// the reference clock in use.
+func (m *statusMonitor) current() clockStatus {
+	if time.Since(time.Unix(0, m.checked.Load())) > statusRefresh {
+		m.refresh()
+	}
+	return m.refclocks.status(*m.status.Load())
+}
+
+// serving reports whether requests should be answered right now.
+func (m *statusMonitor) serving() bool {
+	return m.policy != unsyncRefuse || !m.current().unsynced
+}
+
+// String describes the current status for log messages.
+func (m *statusMonitor) String() string {
+	st := m.current()
+	if st.unsynced {
+		return fmt.Sprintf("unsynchronized (maximum error %v)", st.estError)
+	}
+	return fmt.Sprintf("synchronized (maximum error %v)", st.estError)
+}
@@ -0,0 +1,32 @@
+//go:build linux
+
+package cmd
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// readKernelClock queries the kernel time discipline with a read-only
+// adjtimex call.
+func readKernelClock() (kernelClock, error) {
+	var tx unix.Timex
+	state, err := unix.Adjtimex(&tx)
+	if err != nil {
+		return kernelClock{}, err
+	}
+
+	k := kernelClock{
+		unsynced: tx.Status&unix.STA_UNSYNC != 0 || state == unix.TIME_ERROR,
+		maxError: time.Duration(tx.Maxerror) * time.Microsecond,
+		estError: time.Duration(tx.Esterror) * time.Microsecond,
+	}
+	switch state {
+	case unix.TIME_INS:
+		k.leap = leapInsert
+	case unix.TIME_DEL:
+		k.leap = leapDelete
+	}
+	return k, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package cmd
+
+// readKernelClock is not supported outside Linux, the clock is then assumed
+// to be synchronized.
+func readKernelClock() (kernelClock, error) {
+	return kernelClock{}, errKernelClockUnsupported
+}
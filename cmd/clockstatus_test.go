@@ -0,0 +1,105 @@
+package cmd
+
+//revive:disable:cognitive-complexity
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeStatusMonitor returns a monitor reading k instead of the kernel
+func fakeStatusMonitor(policy unsyncPolicy, threshold time.Duration, k kernelClock, err error) *statusMonitor {
+	m := &statusMonitor{policy: policy, threshold: threshold}
+	m.read = func() (kernelClock, error) { return k, err }
+	m.refresh()
+	return m
+}
+
+func TestParseUnsyncPolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    unsyncPolicy
+		wantErr bool
+	}{
+		{"mark", unsyncMark, false},
+		{"refuse", unsyncRefuse, false},
+		{"ignore", unsyncIgnore, false},
+		{"drop", unsyncMark, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseUnsyncPolicy(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseUnsyncPolicy(%q) = %v, %v, want %v, err %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestStatusMonitorDerive(t *testing.T) {
+	synced := kernelClock{estError: 200 * time.Microsecond, maxError: time.Millisecond}
+	tests := []struct {
+		name         string
+		policy       unsyncPolicy
+		threshold    time.Duration
+		kernel       kernelClock
+		err          error
+		wantUnsynced bool
+		wantLeap     byte
+		wantServing  bool
+	}{
+		{"synchronized", unsyncMark, 0, synced, nil, false, leapNone, true},
+		{"kernel unsynchronized", unsyncMark, 0, kernelClock{unsynced: true}, nil, true, leapUnknown, true},
+		{"refuse while unsynchronized", unsyncRefuse, 0, kernelClock{unsynced: true}, nil, true, leapUnknown, false},
+		{"error above threshold", unsyncRefuse, 100 * time.Microsecond, synced, nil, true, leapUnknown, false},
+		{"error below threshold", unsyncRefuse, time.Millisecond, synced, nil, false, leapNone, true},
+		{"maximum error too large", unsyncMark, 0, kernelClock{maxError: 20 * time.Second}, nil, true, leapUnknown, true},
+		{"leap second pending", unsyncMark, 0, kernelClock{leap: leapInsert}, nil, false, leapInsert, true},
+		{"ignore policy", unsyncIgnore, 0, kernelClock{unsynced: true}, nil, false, leapNone, true},
+		{"unsupported platform", unsyncRefuse, 0, kernelClock{}, errKernelClockUnsupported, false, leapNone, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := fakeStatusMonitor(tt.policy, tt.threshold, tt.kernel, tt.err)
+			st := m.current()
+			if st.unsynced != tt.wantUnsynced {
+				t.Errorf("unsynced = %v, want %v", st.unsynced, tt.wantUnsynced)
+			}
+			if st.leap != tt.wantLeap {
+				t.Errorf("leap = %d, want %d", st.leap, tt.wantLeap)
+			}
+			if tt.err == nil && tt.policy != unsyncIgnore && st.estError != tt.kernel.maxError {
+				t.Errorf("estError = %v, want the maximum error %v", st.estError, tt.kernel.maxError)
+			}
+			if tt.wantUnsynced && st.stratum != stratumUnsynchronized {
+				t.Errorf("stratum = %d, want %d", st.stratum, stratumUnsynchronized)
+			}
+			if got := m.serving(); got != tt.wantServing {
+				t.Errorf("serving() = %v, want %v", got, tt.wantServing)
+			}
+		})
+	}
+}
+
+func TestStatusMonitorCaches(t *testing.T) {
+	calls := 0
+	m := &statusMonitor{policy: unsyncMark}
+	m.read = func() (kernelClock, error) {
+		calls++
+		return kernelClock{}, errors.New("no kernel")
+	}
+	m.refresh()
+	for i := 0; i < 10; i++ {
+		_ = m.current()
+	}
+	if calls != 1 {
+		t.Errorf("kernel read %d times, want 1", calls)
+	}
+
+	// Pretend the last check is old
+	m.checked.Store(time.Now().Add(-2 * statusRefresh).UnixNano())
+	_ = m.current()
+	if calls != 2 {
+		t.Errorf("kernel read %d times after expiry, want 2", calls)
+	}
+}
@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/karasz/gtclock/refclock"
+)
+
+// argKind is what the shell completes for an argument or a flag value.
+type argKind string
+
+// Argument kinds.
+const (
+	argNone    argKind = ""
+	argFiles   argKind = "files"
+	argDirs    argKind = "dirs"
+	argServers argKind = "servers"
+	argWords   argKind = "words"
+)
+
+// valueCompletion is what the value of a flag, or the arguments of a
+// command, complete to.
+type valueCompletion struct {
+	kind  argKind
+	words []string
+}
+
+// flagValues are the flag values that complete to more than free text,
+// keyed by command and flag, or by flag alone for every command.
+var flagValues = map[string]valueCompletion{
+	"gtclockd -d":         {kind: argDirs},
+	"gtclockd -unsync":    {kind: argWords, words: []string{"mark", "refuse", "ignore"}},
+	"gsntpclockd -d":      {kind: argDirs},
+	"gsntpclockd -unsync": {kind: argWords, words: []string{"mark", "refuse", "ignore"}},
+	"gsntpclockd -peer":   {kind: argServers},
+	"gsntpclockd -broadcast": {kind: argWords, words: []string{
+		listenBroadcast, ntpMulticastIPv4, ntpMulticastIPv6,
+	}},
+	"gntpq -c":            {kind: argWords, words: []string{"rv", "rs", "peers", "readvar", "readstat"}},
+	"gtclockcheck -proto": {kind: argWords, words: []string{protoTAI, protoNTP}},
+	"gtailocal -o":        {kind: argWords, words: []string{"text", "json", "logfmt"}},
+	"gtailocal -format": {kind: argWords, words: []string{
+		formatDefault, formatRFC3339, formatRFC3339Nano, formatISO8601, formatUnix,
+	}},
+	"gtclockc -o":    {kind: argWords, words: []string{"text", "json"}},
+	"gsntpclockc -o": {kind: argWords, words: []string{"text", "json"}},
+	"gsntpclockc -listen": {kind: argWords, words: []string{
+		listenBroadcast, ntpMulticastIPv4, ntpMulticastIPv6,
+	}},
+	"-config": {kind: argFiles},
+	"-stats":  {kind: argFiles},
+	"-tz":     {kind: argWords, words: []string{"UTC", "Local"}},
+	// The drivers, the device is for the user to add
+	"-refclock": {kind: argWords, words: refclockDrivers()},
+}
+
+// refclockDrivers returns the reference clock drivers as specification
+// prefixes.
+func refclockDrivers() []string {
+	var words []string
+	for _, name := range refclock.Drivers() {
+		words = append(words, name+":")
+	}
+	return words
+}
+
+// completionShells are the shells completion scripts are written for.
+var completionShells = map[string]func(w io.Writer, cmds []completionCommand){
+	"bash": writeBashCompletion,
+	"zsh":  writeZshCompletion,
+	"fish": writeFishCompletion,
+}
+
+// completionFlag is a flag as the completion scripts see it.
+type completionFlag struct {
+	name  string
+	usage string
+	bool  bool
+	value valueCompletion
+}
+
+// completionCommand is an applet or a gtclock subcommand as the completion
+// scripts see it.
+type completionCommand struct {
+	name    string
+	summary string
+	applet  bool
+	flags   []completionFlag
+	args    valueCompletion
+}
+
+// isBoolFlag reports whether f takes no value.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// completionFlags lists the flags that define adds to a flag set.
+func completionFlags(name string, define func(fs *flag.FlagSet)) []completionFlag {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	if define != nil {
+		define(fs)
+	}
+	var flags []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := flagValues[name+" -"+f.Name]
+		if !ok {
+			value = flagValues["-"+f.Name]
+		}
+		flags = append(flags, completionFlag{
+			name: f.Name, usage: f.Usage, bool: isBoolFlag(f), value: value,
+		})
+	})
+	return flags
+}
+
+// completionCommands lists the applets followed by the gtclock
+// subcommands.
+func completionCommands() []completionCommand {
+	var cmds []completionCommand
+	for _, a := range applets {
+		cmds = append(cmds, completionCommand{
+			name: a.Name, summary: a.Summary, applet: true,
+			flags: completionFlags(a.Name, a.flags), args: valueCompletion{kind: a.args},
+		})
+	}
+	shells := make([]string, 0, len(completionShells))
+	for shell := range completionShells {
+		shells = append(shells, shell)
+	}
+	sort.Strings(shells)
+	return append(cmds,
+		completionCommand{
+			name: "help", summary: "print the usage of gtclock or of an applet",
+			args: valueCompletion{kind: argWords, words: append(appletNames(), "install", "completion")},
+		},
+		completionCommand{name: "version", summary: "print the version of gtclock"},
+		completionCommand{
+			name: "install", summary: "create the links of the applets",
+			flags: completionFlags("install", func(fs *flag.FlagSet) { installFlags(fs) }),
+			args:  valueCompletion{kind: argDirs},
+		},
+		completionCommand{
+			name: "completion", summary: "print a shell completion script",
+			flags: completionFlags("completion", func(fs *flag.FlagSet) { completionFlagSet(fs) }),
+			args:  valueCompletion{kind: argWords, words: shells},
+		},
+	)
+}
+
+// configServers returns the hosts of the servers of a client
+// configuration.
+func configServers(path string) ([]string, error) {
+	cfg, err := loadClientConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	servers := make([]string, len(cfg.servers))
+	for i, s := range cfg.servers {
+		servers[i] = s.host
+	}
+	return servers, nil
+}
+
+// completionOptions holds the gtclock completion flags.
+type completionOptions struct {
+	servers bool
+	config  string
+}
+
+// completionFlagSet defines the gtclock completion flags on fs.
+func completionFlagSet(fs *flag.FlagSet) *completionOptions {
+	o := &completionOptions{}
+	fs.BoolVar(&o.servers, "servers", false, "print the servers of the client configuration, for the scripts")
+	fs.StringVar(&o.config, "config", clientConfigPath, "client configuration file or directory")
+	return o
+}
+
+// completionRun prints the completion script for a shell.
+func completionRun(args []string) int {
+	fs := flag.NewFlagSet("gtclock completion", flag.ContinueOnError)
+	opts := completionFlagSet(fs)
+
+	setUsage(fs, "bash|zsh|fish")
+	if err := fs.Parse(args); err != nil {
+		return parseFailed(err)
+	}
+	if opts.servers {
+		servers, err := configServers(opts.config)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			_, _ = fmt.Println(err)
+			return 111
+		}
+		for _, s := range servers {
+			_, _ = fmt.Println(s)
+		}
+		return 0
+	}
+
+	write, ok := completionShells[fs.Arg(0)]
+	if fs.NArg() != 1 || !ok {
+		fs.Usage()
+		return 111
+	}
+	write(os.Stdout, completionCommands())
+	return 0
+}
+
+// flagNames lists the flags of a command, as typed, with -h.
+func flagNames(flags []completionFlag) string {
+	names := make([]string, 0, len(flags)+1)
+	for _, f := range flags {
+		names = append(names, "-"+f.name)
+	}
+	return strings.Join(append(names, "-h"), " ")
+}
+
+// commandNames lists the names of cmds, the applets only when applets is
+// set.
+func commandNames(cmds []completionCommand, applets bool) string {
+	var names []string
+	for _, c := range cmds {
+		if c.applet || !applets {
+			names = append(names, c.name)
+		}
+	}
+	return strings.Join(names, " ")
+}
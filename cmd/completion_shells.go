@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// serversCommand prints the servers known to the client configuration.
+const serversCommand = "gtclock completion -servers 2>/dev/null"
+
+// bashHeader starts the bash script. It finds the command being completed,
+// an applet called by its name or after gtclock.
+const bashHeader = `# bash completion for gtclock, generated by "gtclock completion bash"
+
+_gtclock() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+	local cmd=${COMP_WORDS[0]##*/}
+	COMPREPLY=()
+	if [[ $cmd == gtclock ]]; then
+		if ((COMP_CWORD == 1)); then
+			COMPREPLY=($(compgen -W "%s" -- "$cur"))
+			return
+		fi
+		cmd=${COMP_WORDS[1]}
+	fi
+	case $cmd in
+`
+
+// bashValues returns the bash code completing v.
+func bashValues(v valueCompletion) string {
+	switch v.kind {
+	case argFiles:
+		return `compopt -o filenames 2>/dev/null; COMPREPLY=($(compgen -f -- "$cur"))`
+	case argDirs:
+		return `compopt -o filenames 2>/dev/null; COMPREPLY=($(compgen -d -- "$cur"))`
+	case argServers:
+		return `COMPREPLY=($(compgen -W "$(` + serversCommand + `)" -- "$cur"))`
+	case argWords:
+		return `COMPREPLY=($(compgen -W "` + strings.Join(v.words, " ") + `" -- "$cur"))`
+	default:
+		return ":"
+	}
+}
+
+// writeBashCommand writes the case of one command.
+func writeBashCommand(w io.Writer, c completionCommand) {
+	_, _ = fmt.Fprintf(w, "\t%s)\n\t\tcase $prev in\n", c.name)
+	for _, f := range c.flags {
+		if !f.bool {
+			_, _ = fmt.Fprintf(w, "\t\t-%s) %s; return ;;\n", f.name, bashValues(f.value))
+		}
+	}
+	_, _ = fmt.Fprintf(w, "\t\tesac\n")
+	_, _ = fmt.Fprintf(w, "\t\tif [[ $cur == -* ]]; then\n")
+	_, _ = fmt.Fprintf(w, "\t\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", flagNames(c.flags))
+	_, _ = fmt.Fprintf(w, "\t\t\treturn\n\t\tfi\n")
+	_, _ = fmt.Fprintf(w, "\t\t%s\n\t\t;;\n", bashValues(c.args))
+}
+
+// writeBashCompletion writes the bash completion script.
+func writeBashCompletion(w io.Writer, cmds []completionCommand) {
+	_, _ = fmt.Fprintf(w, bashHeader, commandNames(cmds, false))
+	for _, c := range cmds {
+		writeBashCommand(w, c)
+	}
+	_, _ = fmt.Fprintf(w, "\tesac\n}\n\ncomplete -F _gtclock gtclock %s\n", commandNames(cmds, true))
+}
+
+// zshHeader starts the zsh script, which can be put on $fpath as _gtclock
+// or sourced.
+const zshHeader = `#compdef gtclock %s
+# zsh completion for gtclock, generated by "gtclock completion zsh"
+
+_gtclock_servers() {
+	local -a servers
+	servers=(${(f)"$(` + serversCommand + `)"})
+	compadd -a servers
+}
+`
+
+// zshTrailer dispatches to the function of the command being completed.
+const zshTrailer = `
+_gtclock() {
+	local cmd=${service:t}
+	if [[ $cmd == gtclock ]]; then
+		if ((CURRENT == 2)); then
+			_describe -t commands command _gtclock_commands
+			return
+		fi
+		cmd=$words[2]
+		shift words
+		((CURRENT--))
+	fi
+	(($+functions[_gtclock_$cmd])) && _gtclock_$cmd
+}
+
+if [[ $funcstack[1] == _gtclock ]]; then
+	_gtclock "$@"
+else
+	compdef _gtclock gtclock %s
+fi
+`
+
+// zshQuote quotes s for zsh.
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// zshDescription escapes the characters _arguments gives a meaning to.
+func zshDescription(s string) string {
+	return strings.NewReplacer("[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+}
+
+// zshAction returns the _arguments action completing v.
+func zshAction(v valueCompletion) string {
+	switch v.kind {
+	case argFiles:
+		return "_files"
+	case argDirs:
+		return "_files -/"
+	case argServers:
+		return "_gtclock_servers"
+	case argWords:
+		return "(" + strings.Join(v.words, " ") + ")"
+	default:
+		return " "
+	}
+}
+
+// zshSpecs returns the _arguments specifications of a command.
+func zshSpecs(c completionCommand) []string {
+	var specs []string
+	for _, f := range c.flags {
+		spec := "-" + f.name + "[" + zshDescription(f.usage) + "]"
+		if !f.bool {
+			spec += ":value:" + zshAction(f.value)
+		}
+		specs = append(specs, zshQuote(spec))
+	}
+	if c.args.kind != argNone {
+		specs = append(specs, zshQuote("*:argument:"+zshAction(c.args)))
+	}
+	return specs
+}
+
+// writeZshCompletion writes the zsh completion script.
+func writeZshCompletion(w io.Writer, cmds []completionCommand) {
+	_, _ = fmt.Fprintf(w, zshHeader, commandNames(cmds, true))
+	_, _ = fmt.Fprintf(w, "\n_gtclock_commands=(\n")
+	for _, c := range cmds {
+		_, _ = fmt.Fprintf(w, "\t%s\n", zshQuote(c.name+":"+c.summary))
+	}
+	_, _ = fmt.Fprintf(w, ")\n")
+	for _, c := range cmds {
+		specs := append([]string{"_arguments -s"}, zshSpecs(c)...)
+		_, _ = fmt.Fprintf(w, "\n_gtclock_%s() {\n\t%s\n}\n", c.name, strings.Join(specs, " \\\n\t\t"))
+	}
+	_, _ = fmt.Fprintf(w, zshTrailer, commandNames(cmds, true))
+}
+
+// fishHeader starts the fish script with the functions telling which
+// command is being completed.
+const fishHeader = `# fish completion for gtclock, generated by "gtclock completion fish"
+
+function __gtclock_command
+	set -l tokens (commandline -opc)
+	set -l cmd (string replace -r '.*/' '' -- $tokens[1])
+	if test "$cmd" = gtclock
+		set cmd $tokens[2]
+	end
+	echo $cmd
+end
+
+function __gtclock_needs_command
+	test (count (commandline -opc)) -eq 1
+end
+`
+
+// fishQuote quotes s for fish.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// fishValues returns the complete options completing v.
+func fishValues(v valueCompletion) string {
+	switch v.kind {
+	case argFiles:
+		return "-F"
+	case argDirs:
+		return "-f -a '(__fish_complete_directories (commandline -ct))'"
+	case argServers:
+		return "-f -a " + fishQuote("("+serversCommand+")")
+	case argWords:
+		return "-f -a " + fishQuote(strings.Join(v.words, " "))
+	default:
+		return "-f"
+	}
+}
+
+// writeFishCommand writes the completions of one command, run by its name
+// when it is an applet, or after gtclock.
+func writeFishCommand(w io.Writer, c completionCommand) {
+	targets := []string{"gtclock"}
+	if c.applet {
+		targets = append(targets, c.name)
+	}
+	cond := fishQuote("test (__gtclock_command) = " + c.name)
+	for _, t := range targets {
+		for _, f := range c.flags {
+			value := ""
+			if !f.bool {
+				value = " -r " + fishValues(f.value)
+			}
+			_, _ = fmt.Fprintf(w, "complete -c %s -n %s -o %s -d %s%s\n", t, cond, f.name, fishQuote(f.usage), value)
+		}
+		_, _ = fmt.Fprintf(w, "complete -c %s -n %s %s\n", t, cond, fishValues(c.args))
+	}
+}
+
+// writeFishCompletion writes the fish completion script.
+func writeFishCompletion(w io.Writer, cmds []completionCommand) {
+	_, _ = fmt.Fprint(w, fishHeader)
+	_, _ = fmt.Fprintln(w)
+	for _, c := range cmds {
+		_, _ = fmt.Fprintf(w, "complete -c gtclock -f -n __gtclock_needs_command -a %s -d %s\n", c.name, fishQuote(c.summary))
+	}
+	for _, c := range cmds {
+		_, _ = fmt.Fprintf(w, "\n# %s\n", c.name)
+		writeFishCommand(w, c)
+	}
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompletionCommands(t *testing.T) {
+	cmds := completionCommands()
+	byName := map[string]completionCommand{}
+	for _, c := range cmds {
+		byName[c.name] = c
+	}
+	for _, a := range Applets() {
+		if c, ok := byName[a.Name]; !ok || !c.applet {
+			t.Errorf("applet %s missing from the completions", a.Name)
+		}
+	}
+
+	d := byName["gtclockd"]
+	if len(d.flags) == 0 || d.flags[0].name != "d" || d.flags[0].value.kind != argDirs {
+		t.Errorf("gtclockd -d does not complete directories: %+v", d.flags)
+	}
+	for _, f := range byName["gtai64n"].flags {
+		if !f.bool {
+			t.Errorf("gtai64n -%s is not a boolean flag", f.name)
+		}
+	}
+	if byName["gtclockc"].args.kind != argServers || byName["gsntpclockc"].args.kind != argServers {
+		t.Error("client arguments do not complete server names")
+	}
+	for _, f := range byName["gtaiconv"].flags {
+		if f.name == "tz" && f.value.kind != argWords {
+			t.Error("-tz does not complete zone names")
+		}
+	}
+}
+
+func TestCompletionScripts(t *testing.T) {
+	for shell, write := range completionShells {
+		t.Run(shell, func(t *testing.T) {
+			var buf bytes.Buffer
+			write(&buf, completionCommands())
+			script := buf.String()
+			for _, want := range []string{
+				"gtclockd", "gtclockc", "gsntpclockc", "gtailocal", "gtai64n", "gtaiconv", "gtaifilter",
+				"esterror", "rfc3339nano", "logfmt", "gtclock completion -servers", "shm:",
+			} {
+				if !strings.Contains(script, want) {
+					t.Errorf("%s script lacks %q", shell, want)
+				}
+			}
+			checkSyntax(t, shell, script)
+		})
+	}
+}
+
+// checkSyntax has the shell parse script, when it is installed.
+func checkSyntax(t *testing.T, shell, script string) {
+	t.Helper()
+	path, err := exec.LookPath(shell)
+	if err != nil {
+		t.Skipf("%s is not installed", shell)
+	}
+	file := filepath.Join(t.TempDir(), "completion")
+	writeFile(t, file, script)
+	if out, err := exec.Command(path, "-n", file).CombinedOutput(); err != nil {
+		t.Errorf("%s -n: %v\n%s", shell, err, out)
+	}
+}
+
+func TestShellQuoting(t *testing.T) {
+	if got := zshQuote("it's"); got != `'it'\''s'` {
+		t.Errorf("zshQuote = %s", got)
+	}
+	if got := zshDescription("a: [b]"); got != `a\: \[b\]` {
+		t.Errorf("zshDescription = %s", got)
+	}
+	if got := fishQuote(`it's \`); got != `'it\'s \\'` {
+		t.Errorf("fishQuote = %s", got)
+	}
+}
+
+func TestConfigServers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.conf")
+	writeFile(t, path, "# servers\nserver ntp1.example.com proto=ntp\n\n  server 192.0.2.1\nstep 128ms\n")
+	got, err := configServers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"ntp1.example.com", "192.0.2.1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("configServers() = %q, want %q", got, want)
+	}
+}
+
+func TestCompletionRun(t *testing.T) {
+	silence(t)
+	missing := filepath.Join(t.TempDir(), "missing.conf")
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"bash", []string{"bash"}, 0},
+		{"zsh", []string{"zsh"}, 0},
+		{"fish", []string{"fish"}, 0},
+		{"unknown shell", []string{"tcsh"}, 111},
+		{"no shell", nil, 111},
+		{"servers without config", []string{"-servers", "-config", missing}, 0},
+		{"help", []string{"-h"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := completionRun(tt.args); got != tt.want {
+				t.Errorf("completionRun(%q) = %d, want %d", tt.args, got, tt.want)
+			}
+		})
+	}
+}
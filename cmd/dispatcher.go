@@ -7,26 +7,26 @@ import (
 
 // MainDispatcher is called if run as "gtclock <subcommand>"
 func MainDispatcher(args []string) int {
-	ret := 0
 	if len(args) == 0 {
-		_, _ = fmt.Println("Available applets: gtailocal,gtclockd,gtclockc,gntpclock")
-		ret = 1
-		return ret
+		printHelp(os.Stderr)
+		return 1
 	}
 
 	switch args[0] {
-	case "gtailocal":
-		ret = GTAILocalRun(args[1:])
-	case "gtclockd":
-		ret = GTClockDRun(args[1:])
-	case "gtclockc":
-		ret = GTClockCRun(args[1:])
-	case "gsntpclockc":
-		ret = GSNTPClockCRun(args[1:])
+	case "help", "-h", "-help", "--help":
+		return helpRun(args[1:])
+	case "version", "-version", "--version":
+		_, _ = fmt.Print(versionInfo())
+		return 0
+	}
+	if run, ok := subcommands[args[0]]; ok {
+		return run(args[1:])
+	}
 
-	default:
+	a, ok := lookupApplet(args[0])
+	if !ok {
 		_, _ = fmt.Fprintf(os.Stderr, "Unknown command: %s\n", args[0])
-		ret = 1
+		return 1
 	}
-	return ret
+	return a.Run(args[1:])
 }
@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gntpqOptions holds the gntpq flags.
+type gntpqOptions struct {
+	command string
+	assoc   uint
+	timeout time.Duration
+}
+
+// gntpqFlags defines the gntpq flags on fs.
+func gntpqFlags(fs *flag.FlagSet) *gntpqOptions {
+	o := &gntpqOptions{}
+	fs.StringVar(&o.command, "c", "rv", "query: rv (readvar), rs (readstat) or peers")
+	fs.UintVar(&o.assoc, "a", 0, "association ID for rv and rs, 0 for the system")
+	fs.DurationVar(&o.timeout, "timeout", 5*time.Second, "time to wait for the answer")
+	return o
+}
+
+// queryCommands are the queries of gntpq, by name.
+var queryCommands = map[string]func(q *ntpQuery, assoc uint16) error{
+	"rv":       (*ntpQuery).readVar,
+	"readvar":  (*ntpQuery).readVar,
+	"rs":       (*ntpQuery).readStat,
+	"readstat": (*ntpQuery).readStat,
+	"peers":    (*ntpQuery).peers,
+}
+
+// ctlAssembler puts the fragments of a control response back together.
+type ctlAssembler struct {
+	frags map[uint16][]byte
+	end   int
+}
+
+// add adds a received datagram, returning the whole response once every
+// fragment of the response to req arrived. Datagrams that are not part of
+// it are ignored.
+func (a *ctlAssembler) add(req ctlPacket, b []byte) (ctlPacket, bool, error) {
+	p, err := unpackControl(b)
+	if err != nil || p.Sequence != req.Sequence || p.Op&ctlResponse == 0 {
+		return ctlPacket{}, false, nil
+	}
+	if p.Op&ctlError != 0 {
+		return ctlPacket{}, false, ctlErr(p.Status >> 8)
+	}
+	a.frags[p.Offset] = append([]byte(nil), p.data...)
+	if p.Op&ctlMore == 0 {
+		a.end = int(p.Offset) + len(p.data)
+	}
+	var data []byte
+	for len(data) < a.end {
+		frag, ok := a.frags[uint16(len(data))]
+		if !ok || len(frag) == 0 {
+			return ctlPacket{}, false, nil
+		}
+		data = append(data, frag...)
+	}
+	p.data = data
+	return p, a.end >= 0, nil
+}
+
+// controlQuery sends a control message to the server at address and
+// returns its response.
+func controlQuery(address string, req ctlPacket, timeout time.Duration) (ctlPacket, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return ctlPacket{}, err
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	req.LiVnMode = byte(controlMessage) | ntpVersion<<3
+	req.Sequence = uint16(rand.N(math.MaxUint16 + 1))
+	if _, err := conn.Write(req.pack()); err != nil {
+		return ctlPacket{}, err
+	}
+
+	a := &ctlAssembler{frags: map[uint16][]byte{}, end: -1}
+	buf := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return ctlPacket{}, err
+		}
+		resp, done, err := a.add(req, buf[:n])
+		if err != nil {
+			return ctlPacket{}, err
+		}
+		if done {
+			return resp, nil
+		}
+	}
+}
+
+// parseVars parses READVAR data.
+func parseVars(data string) map[string]string {
+	vars := map[string]string{}
+	for _, pair := range strings.Split(data, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		vars[name] = value
+	}
+	return vars
+}
+
+// ntpQuery runs the control queries of gntpq against a server.
+type ntpQuery struct {
+	address string
+	timeout time.Duration
+	out     io.Writer
+}
+
+// query sends a request with opcode op about association assoc.
+func (q *ntpQuery) query(op byte, assoc uint16) (ctlPacket, error) {
+	return controlQuery(q.address, ctlPacket{ctlHeader: ctlHeader{Op: op, AssocID: assoc}}, q.timeout)
+}
+
+// readVar prints the variables of the system or of an association.
+func (q *ntpQuery) readVar(assoc uint16) error {
+	resp, err := q.query(ctlOpReadVar, assoc)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(q.out, "associd=%d status=%04x %s\n", assoc, resp.Status, resp.data)
+	return nil
+}
+
+// readStat prints the status of the system and of its associations, or of
+// an association.
+func (q *ntpQuery) readStat(assoc uint16) error {
+	resp, err := q.query(ctlOpReadStat, assoc)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(q.out, "associd=%d status=%04x\n", assoc, resp.Status)
+	for _, st := range peerStatuses(resp.data) {
+		_, _ = fmt.Fprintf(q.out, "associd=%d status=%04x\n", st[0], st[1])
+	}
+	return nil
+}
+
+// peerStatuses decodes the association IDs and peer status words of
+// READSTAT data.
+func peerStatuses(data []byte) [][2]uint16 {
+	var statuses [][2]uint16
+	for i := 0; i+4 <= len(data); i += 4 {
+		statuses = append(statuses, [2]uint16{
+			binary.BigEndian.Uint16(data[i:]),
+			binary.BigEndian.Uint16(data[i+2:]),
+		})
+	}
+	return statuses
+}
+
+// peerMark is the tally code ntpq shows in front of a peer.
+func peerMark(status uint16) byte {
+	switch status >> 8 & 0x07 {
+	case ctlSelSysPeer:
+		return '*'
+	case ctlSelCandidate:
+		return '+'
+	default:
+		return ' '
+	}
+}
+
+// peerLine formats the peers line of an association.
+func peerLine(status uint16, vars map[string]string) string {
+	reach, _ := strconv.ParseUint(vars["reach"], 0, 8)
+	poll, _ := strconv.Atoi(vars["hpoll"])
+	return fmt.Sprintf("%c%-20s %-15s %2s %5o %5d %9s %9s",
+		peerMark(status), vars["srcadr"], vars["refid"], vars["stratum"],
+		reach, 1<<poll, vars["delay"], vars["offset"])
+}
+
+// peers prints a line for every association, as ntpq -p does.
+func (q *ntpQuery) peers(uint16) error {
+	resp, err := q.query(ctlOpReadStat, 0)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(q.out, " %-20s %-15s %2s %5s %5s %9s %9s\n",
+		"remote", "refid", "st", "reach", "poll", "delay", "offset")
+	for _, st := range peerStatuses(resp.data) {
+		vars, err := q.query(ctlOpReadVar, st[0])
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintln(q.out, peerLine(st[1], parseVars(string(vars.data))))
+	}
+	return nil
+}
+
+// errUnknownQuery is returned for a -c value gntpq does not know.
+var errUnknownQuery = errors.New("unknown query, use rv, rs or peers")
+
+// GNTPQRun queries an NTP server with control messages, as ntpq does.
+func GNTPQRun(args []string) int {
+	fs := flag.NewFlagSet("gntpq", flag.ContinueOnError)
+	opts := gntpqFlags(fs)
+
+	setUsage(fs, "[-c rv|rs|peers] [-a assoc] [server[:port]]")
+	if err := fs.Parse(args); err != nil {
+		return parseFailed(err)
+	}
+	if fs.NArg() > 1 || opts.assoc > math.MaxUint16 {
+		fs.Usage()
+		return 111
+	}
+	server := "127.0.0.1"
+	if fs.NArg() == 1 {
+		server = fs.Arg(0)
+	}
+
+	run, ok := queryCommands[opts.command]
+	if !ok {
+		_, _ = fmt.Println(errUnknownQuery)
+		return 111
+	}
+	q := &ntpQuery{address: peerAddress(server), timeout: opts.timeout, out: os.Stdout}
+	if err := run(q, uint16(opts.assoc)); err != nil {
+		_, _ = fmt.Println(err)
+		return 111
+	}
+	return 0
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karasz/gtclock/gtudpd"
+)
+
+func TestCtlAssembler(t *testing.T) {
+	req := ctlPacket{ctlHeader: ctlHeader{Sequence: 4}}
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	frags := controlFragments(req, 0, data, ctlOpReadVar)
+	for i := range frags {
+		frags[i].LiVnMode = byte(controlMessage) | ntpVersion<<3
+	}
+	a := &ctlAssembler{frags: map[uint16][]byte{}, end: -1}
+
+	// Out of order, with a stray response to another request
+	other := frags[0]
+	other.Sequence = 5
+	for i, b := range [][]byte{frags[2].pack(), other.pack(), frags[0].pack(), frags[1].pack()} {
+		resp, done, err := a.add(req, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done != (i == 3) {
+			t.Fatalf("datagram %d: done = %v", i, done)
+		}
+		if done && !bytes.Equal(resp.data, data) {
+			t.Errorf("reassembled %d bytes, want %d", len(resp.data), len(data))
+		}
+	}
+
+	failed := frags[0]
+	failed.Op |= ctlError
+	failed.Status = uint16(ctlErrBadAssoc) << 8
+	if _, _, err := a.add(req, failed.pack()); !errors.Is(err, ctlErrBadAssoc) {
+		t.Errorf("error response = %v", err)
+	}
+}
+
+func TestParseVars(t *testing.T) {
+	vars := parseVars(`version="gtclock v1.0", stratum=2, filtdelay=0.100 0.200`)
+	if vars["version"] != "gtclock v1.0" || vars["stratum"] != "2" || vars["filtdelay"] != "0.100 0.200" {
+		t.Errorf("parseVars() = %q", vars)
+	}
+}
+
+func TestPeerLine(t *testing.T) {
+	vars := map[string]string{
+		"srcadr": "192.0.2.1", "refid": "LOCL", "stratum": "2",
+		"reach": "0xff", "hpoll": "6", "delay": "0.250", "offset": "-1.000",
+	}
+	got := peerLine(uint16(ctlPeerConfigured|ctlPeerReachable|ctlSelSysPeer)<<8, vars)
+	want := "*192.0.2.1            LOCL             2   377    64     0.250    -1.000"
+	if got != want {
+		t.Errorf("peerLine() =\n%q, want\n%q", got, want)
+	}
+	if mark := peerMark(uint16(ctlPeerConfigured|ctlSelCandidate) << 8); mark != '+' {
+		t.Errorf("candidate mark = %c", mark)
+	}
+}
+
+// startPeeringServer runs a server falling back on a synchronized peer and
+// returns its address.
+func startPeeringServer(t *testing.T) string {
+	t.Helper()
+	upstream := testNTPServer(unsyncMark, kernelClock{})
+	lost := testNTPServer(unsyncMark, kernelClock{unsynced: true})
+	lost.peers = newPeerSet([]string{startNTPServer(t, upstream)})
+	lost.peers.poll(lost.peers.assocs[0])
+	return startNTPServer(t, lost)
+}
+
+func TestNTPQuery(t *testing.T) {
+	address := startPeeringServer(t)
+	tests := []struct {
+		command string
+		assoc   uint16
+		want    []string
+	}{
+		{"rv", 0, []string{"associd=0 status=0600", "stratum=3", "refid=127.0.0.1", "peer=1"}},
+		{"rv", 1, []string{"associd=1 status=9600", "srcadr=127.0.0.1", "reach=0x01"}},
+		{"rs", 0, []string{"associd=0 status=0600\nassocid=1 status=9600\n"}},
+		{"peers", 0, []string{"remote", "*127.0.0.1", "LOCL"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			var out bytes.Buffer
+			q := &ntpQuery{address: address, timeout: 2 * time.Second, out: &out}
+			if err := queryCommands[tt.command](q, tt.assoc); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output %q lacks %q", out.String(), want)
+				}
+			}
+		})
+	}
+
+	q := &ntpQuery{address: address, timeout: 2 * time.Second, out: &bytes.Buffer{}}
+	if err := q.readVar(7); !errors.Is(err, ctlErrBadAssoc) {
+		t.Errorf("readVar(7) = %v", err)
+	}
+}
+
+func TestNTPQueryRestricted(t *testing.T) {
+	s := testNTPServer(unsyncMark, kernelClock{})
+	s.config = &gtudpd.Config{DefaultPort: "127.0.0.1:0", MaxRequestSize: ntpMaxRequest, ConfigDir: t.TempDir()}
+	q := &ntpQuery{address: startNTPServer(t, s), timeout: 200 * time.Millisecond, out: &bytes.Buffer{}}
+	if err := q.readVar(0); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("query of a client not allowed = %v, want a timeout", err)
+	}
+}
+
+func TestGNTPQRunErrors(t *testing.T) {
+	silence(t)
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"help", []string{"-h"}, 0},
+		{"unknown query", []string{"-c", "mrulist"}, 111},
+		{"two servers", []string{"192.0.2.1", "192.0.2.2"}, 111},
+		{"association too large", []string{"-a", "70000"}, 111},
+		{"unreachable", []string{"-timeout", "1ms", "127.0.0.1:9"}, 111},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GNTPQRun(tt.args); got != tt.want {
+				t.Errorf("GNTPQRun(%q) = %d, want %d", tt.args, got, tt.want)
+			}
+		})
+	}
+}
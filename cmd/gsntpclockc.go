@@ -4,6 +4,7 @@ package cmd
 import (
 	"encoding/binary"
 	"errors"
+	"flag"
 	"fmt"
 	"net"
 	"os"
@@ -77,44 +78,64 @@ func (m *msg) setMode(md mode) {
 	m.LiVnMode = (m.LiVnMode & 0xf8) | byte(md)
 }
 
-// GetTime returns the "receive time" from the remote NTP server
-// specified as host.  NTP client mode is used.
-func getTime(host string) (msg, ntpTime, error) {
-	raddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, "123"))
+// ntpReply is the answer of an NTP server, with the addresses it was
+// exchanged between.
+type ntpReply struct {
+	msg
+	dest   ntpTime
+	local  net.IP
+	remote net.IP
+}
+
+// errBogusReply is returned for an answer that is not to the request sent.
+var errBogusReply = errors.New("reply does not match the request")
+
+// ntpExchange sends a packet of mode md to the NTP server at address and
+// returns its answer.
+func ntpExchange(address string, md mode) (ntpReply, error) {
+	raddr, err := net.ResolveUDPAddr("udp", address)
 	if err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
-		return msg{}, 0, err
+		return ntpReply{}, err
 	}
 
 	con, err := net.DialUDP("udp", nil, raddr)
 	if err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
-		return msg{}, 0, err
+		return ntpReply{}, err
 	}
 
 	defer func() { _ = con.Close() }()
 	_ = con.SetDeadline(time.Now().Add(5 * time.Second))
 
-	m := new(msg)
-	m.setMode(client)
-	m.setVersion(4)
-	m.TransmitTime = encode(time.Now())
+	req := msg{TransmitTime: encode(time.Now())}
+	req.setMode(md)
+	req.setVersion(4)
+	if err := binary.Write(con, binary.BigEndian, &req); err != nil {
+		return ntpReply{}, err
+	}
 
-	err = binary.Write(con, binary.BigEndian, m)
-	if err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
-		return msg{}, 0, err
+	r := ntpReply{remote: raddr.IP}
+	if err := binary.Read(con, binary.BigEndian, &r.msg); err != nil {
+		return ntpReply{}, err
+	}
+	r.dest = encode(time.Now())
+	if r.OriginateTime != req.TransmitTime {
+		return ntpReply{}, errBogusReply
 	}
+	if laddr, ok := con.LocalAddr().(*net.UDPAddr); ok {
+		r.local = laddr.IP
+	}
+	return r, nil
+}
 
-	err = binary.Read(con, binary.BigEndian, m)
+// GetTime returns the "receive time" from the remote NTP server at
+// address.  NTP client mode is used.
+func getTime(address string) (msg, ntpTime, error) {
+	r, err := ntpExchange(address, client)
 	if err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
 		return msg{}, 0, err
 	}
-
-	dest := encode(time.Now())
-
-	return *m, dest, nil
+	return r.msg, r.dest, nil
 }
 
 // getParams returns two time.Durations the time offset and rtt time
@@ -131,7 +152,7 @@ func getParams(m msg, dest ntpTime) (offset time.Duration, rtt time.Duration) {
 // parseNTPArgs parses command line arguments for NTP client.
 func parseNTPArgs(args []string) (servIP net.IP, saveClock bool, err error) {
 	if len(args) == 0 {
-		return nil, false, errors.New("usage: gntpclock <server_ip> [saveclock]")
+		return nil, false, errors.New("usage: gsntpclockc <server_ip> [saveclock]")
 	}
 
 	switch len(args) {
@@ -147,33 +168,31 @@ func parseNTPArgs(args []string) (servIP net.IP, saveClock bool, err error) {
 		}
 		saveClock = args[1] == "saveclock"
 	default:
-		return nil, false, errors.New("usage: gntpclock <server_ip> [saveclock]")
+		return nil, false, errors.New("usage: gsntpclockc <server_ip> [saveclock]")
 	}
 	return servIP, saveClock, nil
 }
 
-// GSNTPClockCRun implements SNTP client functionality for time synchronization.
-func GSNTPClockCRun(args []string) int {
-	servIP, saveClock, err := parseNTPArgs(args)
-	if err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
-		return 111
-	}
-
-	m, dst, err := getTime(servIP.String())
+// measureNTP measures an NTP server with a client mode exchange.
+func measureNTP(s serverConfig) (clientSample, error) {
+	m, dst, err := getTime(s.address())
 	if err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
-		return 111
-	}
-
-	offset, _ := getParams(m, dst)
-
-	if saveClock {
-		if err := setSystemClock(offset); err != nil {
-			_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
-			return 111
-		}
+		return clientSample{}, err
 	}
+	offset, rtt := getParams(m, dst)
+	leap := m.LiVnMode >> 6
+	return clientSample{
+		offset: offset, delay: rtt, dispersion: fromNTPShort(m.RootDispersion),
+		stratum: int(m.Stratum), leap: int(leap),
+		// Stratum 0 is a kiss-o'-death answer
+		unsynced: leap == leapUnknown || m.Stratum == 0,
+	}, nil
+}
 
-	return 0
+// GSNTPClockCRun implements SNTP client functionality for time synchronization.
+func GSNTPClockCRun(args []string) int {
+	fs := flag.NewFlagSet("gsntpclockc", flag.ContinueOnError)
+	opts := clientFlags(fs)
+	ntpFlags(fs, opts)
+	return runClient(fs, opts, clientProtocol{protoNTP, parseNTPArgs, measureNTP}, args)
 }
@@ -0,0 +1,281 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/karasz/gtclock/gtudpd"
+)
+
+const (
+	defaultNTPPort = ":123"
+	ntpVersion     = 4
+	// ntpMaxRequest leaves room for a MAC after the 48 byte header
+	ntpMaxRequest = 128
+	// broadcastAll is the address -broadcast broadcast sends to
+	broadcastAll = "255.255.255.255"
+)
+
+// refidLocal is the reference ID advertised for the host clock, which is
+// assumed to be synchronized by some other means.
+var refidLocal = binary.BigEndian.Uint32([]byte("LOCL"))
+
+// ntpServerOptions holds the gsntpclockd flags.
+type ntpServerOptions struct {
+	configDir string
+	estError  time.Duration
+	unsync    string
+	broadcast string
+	interval  time.Duration
+	ttl       int
+	iface     string
+	peers     []string
+	refclocks []string
+}
+
+// gsntpclockdFlags defines the gsntpclockd flags on fs.
+func gsntpclockdFlags(fs *flag.FlagSet) *ntpServerOptions {
+	o := &ntpServerOptions{}
+	fs.StringVar(&o.configDir, "d", "", "config directory path")
+	fs.DurationVar(&o.estError, "esterror", 0, "treat the clock as unsynchronized above this estimated error")
+	fs.StringVar(&o.unsync, "unsync", "mark", "when unsynchronized: mark, refuse or ignore")
+	fs.StringVar(&o.broadcast, "broadcast", "",
+		"also send broadcasts: broadcast, a broadcast address or a multicast group like 224.0.1.1 or ff05::101, optionally with :port")
+	fs.DurationVar(&o.interval, "interval", gtudpd.DefaultBroadcastInterval, "time between two broadcasts")
+	fs.IntVar(&o.ttl, "ttl", gtudpd.DefaultBroadcastTTL, "TTL, or hop limit, of the broadcasts")
+	fs.StringVar(&o.iface, "iface", "", "interface multicasts leave from")
+	fs.Func("peer", "peer with this server, host[:port], in symmetric mode (repeatable)", func(s string) error {
+		o.peers = append(o.peers, peerAddress(s))
+		return nil
+	})
+	refclockFlag(fs, &o.refclocks)
+	return o
+}
+
+// broadcastGroup returns the address a -broadcast value sends to.
+func broadcastGroup(s string) (string, error) {
+	group, port, err := splitListen(s)
+	if err != nil {
+		return "", err
+	}
+	if group == listenBroadcast {
+		group = broadcastAll
+	}
+	return net.JoinHostPort(group, strconv.Itoa(port)), nil
+}
+
+// ntpShort converts d to the NTP short format, 16.16 seconds, saturating
+// at the field size.
+func ntpShort(d time.Duration) uint32 {
+	s := d.Seconds() * (1 << 16)
+	switch {
+	case s < 0:
+		return 0
+	case s > math.MaxUint32:
+		return math.MaxUint32
+	default:
+		return uint32(s)
+	}
+}
+
+// fromNTPShort decodes a duration in the 16.16 format.
+func fromNTPShort(u uint32) time.Duration {
+	return time.Duration(uint64(u) * uint64(time.Second) >> 16)
+}
+
+// packNTP encodes m.
+func packNTP(m msg) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, &m)
+	return buf.Bytes()
+}
+
+// ntpServer answers NTP client requests and symmetric active packets, and
+// sends broadcasts, with the status of the reference clock in use or of the
+// host clock or, while it is unsynchronized, of the peer it falls back on.
+type ntpServer struct {
+	clock  *statusMonitor
+	peers  *peerSet
+	config *gtudpd.Config
+	poll   int8
+}
+
+// status returns the status of the clock served.
+func (s *ntpServer) status() clockStatus {
+	return s.peers.fallback(s.clock.current())
+}
+
+// serving reports whether requests should be answered right now.
+func (s *ntpServer) serving() bool {
+	return s.clock.policy != unsyncRefuse || !s.status().unsynced
+}
+
+// servedRefid returns the reference ID served with st.
+func servedRefid(st clockStatus) uint32 {
+	if st.refid == 0 {
+		return refidLocal
+	}
+	return st.refid
+}
+
+// refTime encodes the reference time of a status, 0 when it is unknown.
+func refTime(t time.Time) ntpTime {
+	if t.IsZero() {
+		return 0
+	}
+	return encode(t)
+}
+
+// header returns a packet of mode md carrying the clock status.
+func (s *ntpServer) header(md mode, version byte) msg {
+	st := s.status()
+	m := msg{
+		LiVnMode:       st.leap << 6,
+		Stratum:        st.stratum,
+		Precision:      byte(st.precision),
+		RootDispersion: ntpShort(st.estError),
+		ReferenceID:    servedRefid(st),
+		ReferenceTime:  refTime(st.reftime),
+	}
+	m.setVersion(version)
+	m.setMode(md)
+	return m
+}
+
+// respond answers a client mode request, a symmetric active one or a
+// control message.
+func (s *ntpServer) respond(conn gtudpd.ResponseWriter, n int, remoteaddr *net.UDPAddr, buf []byte, received time.Time) {
+	if mode(buf[0]&0x07) == controlMessage {
+		s.control(conn, remoteaddr, buf[:n])
+		return
+	}
+	var req msg
+	if binary.Read(bytes.NewReader(buf[:n]), binary.BigEndian, &req) != nil {
+		return
+	}
+	md := server
+	if mode(req.LiVnMode&0x07) == symmetricActive {
+		md = symmetricPassive
+	}
+	m := s.header(md, req.LiVnMode>>3&0x07)
+	m.Poll = req.Poll
+	m.OriginateTime = req.TransmitTime
+	m.ReceiveTime = encode(received)
+	// Take the transmit time last, right before the write
+	m.TransmitTime = encode(time.Now())
+	_, _ = conn.WriteToUDP(packNTP(m), remoteaddr)
+}
+
+// validate accepts the client mode, symmetric active and control requests
+// of allowed clients.
+func (s *ntpServer) validate(n int, buf []byte, remoteIP net.IP) bool {
+	if n == 0 {
+		return false
+	}
+	if v := buf[0] >> 3 & 0x07; v < 1 || v > ntpVersion {
+		return false
+	}
+	return s.accepts(mode(buf[0]&0x07), n) && s.config.ClientOK(remoteIP)
+}
+
+// accepts reports whether a request of mode md and n bytes is answered.
+// Control messages are, whatever the clock status, so it can be monitored.
+func (s *ntpServer) accepts(md mode, n int) bool {
+	switch md {
+	case controlMessage:
+		return n >= ctlHeaderSize
+	case client, symmetricActive:
+		return n >= ntpPacketSize && s.serving()
+	default:
+		return false
+	}
+}
+
+// broadcastPacket returns the next broadcast, nil while the clock is not
+// served.
+func (s *ntpServer) broadcastPacket() []byte {
+	if !s.serving() {
+		return nil
+	}
+	m := s.header(broadcast, ntpVersion)
+	m.Poll = byte(s.poll)
+	m.TransmitTime = encode(time.Now())
+	return packNTP(m)
+}
+
+// startBroadcast starts sending broadcasts as the flags ask.
+func (s *ntpServer) startBroadcast(o *ntpServerOptions) (*gtudpd.Broadcaster, error) {
+	group, err := broadcastGroup(o.broadcast)
+	if err != nil {
+		return nil, err
+	}
+	config := &gtudpd.BroadcastConfig{Group: group, Interface: o.iface, Interval: o.interval, TTL: o.ttl}
+	s.poll = precisionOf(o.interval)
+	b, err := gtudpd.NewBroadcaster(config, s.broadcastPacket)
+	if err != nil {
+		return nil, err
+	}
+	go b.Start()
+	_, _ = fmt.Printf("broadcasting to %s every %v\n", b.Group(), config.Interval)
+	return b, nil
+}
+
+// GSNTPClockDRun starts an NTP server listening on port 123.
+func GSNTPClockDRun(args []string) int {
+	fs := flag.NewFlagSet("gsntpclockd", flag.ContinueOnError)
+	opts := gsntpclockdFlags(fs)
+
+	setUsage(fs, "[-d dir] [-esterror duration] [-unsync mode] [-broadcast group] [-peer host]... [-refclock spec]...")
+	if err := fs.Parse(args); err != nil {
+		return parseFailed(err)
+	}
+
+	policy, err := parseUnsyncPolicy(opts.unsync)
+	if err != nil {
+		_, _ = fmt.Println(err)
+		return 111
+	}
+	srv := &ntpServer{clock: newStatusMonitor(policy, opts.estError), peers: newPeerSet(opts.peers)}
+	srv.config = &gtudpd.Config{
+		DefaultPort:    defaultNTPPort,
+		ConfigDir:      opts.configDir,
+		MaxRequestSize: ntpMaxRequest,
+		Sockets:        runtime.NumCPU(),
+	}
+
+	server, err := gtudpd.NewServer(srv.config, srv.respond, srv.validate)
+	if err != nil {
+		_, _ = fmt.Println(err)
+		return 111
+	}
+	defer func() { _ = server.Stop() }()
+
+	if opts.broadcast != "" {
+		b, err := srv.startBroadcast(opts)
+		if err != nil {
+			_, _ = fmt.Println(err)
+			return 111
+		}
+		defer func() { _ = b.Stop() }()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := startRefclocks(ctx, srv.clock, opts.refclocks); err != nil {
+		_, _ = fmt.Println(err)
+		return 111
+	}
+	srv.peers.run(ctx, srv.clock)
+
+	_, _ = fmt.Printf("NTP server listening on %s, clock %s\n", server.Addr().String(), srv.clock)
+	server.Start()
+	return 0
+}
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karasz/gtclock/gtudpd"
+)
+
+func TestBroadcastGroup(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"broadcast", "255.255.255.255:123", false},
+		{"192.0.2.255", "192.0.2.255:123", false},
+		{"224.0.1.1:1123", "224.0.1.1:1123", false},
+		{"ff05::101", "[ff05::101]:123", false},
+		{"224.0.1.1:x", "", true},
+	}
+	for _, tt := range tests {
+		got, err := broadcastGroup(tt.in)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("broadcastGroup(%q) = %s, %v, want %s", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestNTPShort(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want uint32
+	}{
+		{-time.Second, 0},
+		{0, 0},
+		{time.Second, 1 << 16},
+		{500 * time.Millisecond, 1 << 15},
+		{1 << 20 * time.Second, 1<<32 - 1},
+	}
+	for _, tt := range tests {
+		if got := ntpShort(tt.d); got != tt.want {
+			t.Errorf("ntpShort(%v) = %#x, want %#x", tt.d, got, tt.want)
+		}
+	}
+	if d := fromNTPShort(ntpShort(1500 * time.Millisecond)); d != 1500*time.Millisecond {
+		t.Errorf("fromNTPShort(ntpShort(1.5s)) = %v", d)
+	}
+}
+
+// testNTPServer returns an NTP server whose clock has the kernel state k.
+func testNTPServer(policy unsyncPolicy, k kernelClock) *ntpServer {
+	return &ntpServer{
+		clock:  fakeStatusMonitor(policy, 0, k, nil),
+		config: &gtudpd.Config{DefaultPort: "127.0.0.1:0", MaxRequestSize: ntpMaxRequest},
+	}
+}
+
+func TestNTPServerValidate(t *testing.T) {
+	request := func(version byte, md mode) []byte {
+		m := msg{}
+		m.setVersion(version)
+		m.setMode(md)
+		return packNTP(m)
+	}
+	tests := []struct {
+		name   string
+		policy unsyncPolicy
+		k      kernelClock
+		buf    []byte
+		want   bool
+	}{
+		{"client request", unsyncMark, kernelClock{}, request(4, client), true},
+		{"version 3", unsyncMark, kernelClock{}, request(3, client), true},
+		{"version 0", unsyncMark, kernelClock{}, request(0, client), false},
+		{"version 5", unsyncMark, kernelClock{}, request(5, client), false},
+		{"server packet", unsyncMark, kernelClock{}, request(4, server), false},
+		{"short", unsyncMark, kernelClock{}, request(4, client)[:47], false},
+		{"unsynchronized marked", unsyncMark, kernelClock{unsynced: true}, request(4, client), true},
+		{"unsynchronized refused", unsyncRefuse, kernelClock{unsynced: true}, request(4, client), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testNTPServer(tt.policy, tt.k)
+			if got := s.validate(len(tt.buf), tt.buf, net.IPv4(127, 0, 0, 1)); got != tt.want {
+				t.Errorf("validate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNTPServerRespond(t *testing.T) {
+	s := testNTPServer(unsyncMark, kernelClock{leap: leapInsert, maxError: time.Second, estError: time.Millisecond})
+	srv, err := gtudpd.NewServer(s.config, s.respond, s.validate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	defer func() { _ = srv.Stop() }()
+
+	m, dest, err := getTime(srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode(m.LiVnMode&0x07) != server || m.LiVnMode>>6 != leapInsert || m.LiVnMode>>3&0x07 != 4 {
+		t.Errorf("LiVnMode = %#x", m.LiVnMode)
+	}
+	// The server does not know when the host clock was last set
+	if m.Stratum != hostClockStratum || m.ReferenceID != refidLocal || m.RootDispersion != 1<<16 || m.ReferenceTime != 0 {
+		t.Errorf("response = %+v", m)
+	}
+	offset, rtt := getParams(m, dest)
+	if offset.Abs() > 10*time.Millisecond || rtt < 0 || rtt > time.Second {
+		t.Errorf("offset = %v, rtt = %v", offset, rtt)
+	}
+}
+
+func TestNTPServerBroadcast(t *testing.T) {
+	client, _ := testBroadcastClient(t, nil)
+	s := testNTPServer(unsyncMark, kernelClock{})
+	b, err := s.startBroadcast(&ntpServerOptions{
+		broadcast: client.conn.LocalAddr().String(), interval: time.Hour, ttl: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Stop() }()
+
+	sample, err := client.measure(serverConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sample.stratum != hostClockStratum || sample.unsynced || sample.offset.Abs() > 50*time.Millisecond {
+		t.Errorf("sample = %+v", sample)
+	}
+	if s.poll != 12 {
+		t.Errorf("poll = %d, want 12 for an hour", s.poll)
+	}
+}
+
+func TestNTPServerBroadcastRefused(t *testing.T) {
+	s := testNTPServer(unsyncRefuse, kernelClock{unsynced: true})
+	if p := s.broadcastPacket(); p != nil {
+		t.Errorf("broadcast sent while unsynchronized: %x", p)
+	}
+	s = testNTPServer(unsyncMark, kernelClock{unsynced: true})
+	m, ok := decodeBroadcast(s.broadcastPacket())
+	if !ok || m.Stratum != stratumUnsynchronized || m.LiVnMode>>6 != leapUnknown {
+		t.Errorf("unsynchronized broadcast = %+v", m)
+	}
+}
+
+func TestGSNTPClockDRunErrors(t *testing.T) {
+	silence(t)
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"help", []string{"-h"}, 0},
+		{"bad flag", []string{"-x"}, 111},
+		{"bad unsync", []string{"-unsync", "panic"}, 111},
+		{"bad refclock", []string{"-refclock", "gps:/dev/ttyS0"}, 111},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GSNTPClockDRun(tt.args); got != tt.want {
+				t.Errorf("GSNTPClockDRun(%q) = %d, want %d", tt.args, got, tt.want)
+			}
+		})
+	}
+	if _, err := (&ntpServer{}).startBroadcast(&ntpServerOptions{broadcast: "broadcast:x"}); err == nil {
+		t.Error("bad -broadcast accepted")
+	}
+}
@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/karasz/glibtai"
+)
+
+// stampLayout is the fixed width RFC3339 layout of gtai64n -rfc3339.
+const stampLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// stampFunc appends the label for now to dst.
+type stampFunc func(dst []byte, now glibtai.TAIN) []byte
+
+// appendTAINStamp appends a TAI64N label, as daemontools' tai64n writes it.
+func appendTAINStamp(dst []byte, now glibtai.TAIN) []byte {
+	dst = append(dst, '@')
+	return hex.AppendEncode(dst, glibtai.TAINPack(now))
+}
+
+// appendTAIStamp appends a TAI64 label, dropping the nanoseconds.
+func appendTAIStamp(dst []byte, now glibtai.TAIN) []byte {
+	dst = append(dst, '@')
+	return hex.AppendEncode(dst, glibtai.TAINPack(now)[:glibtai.TAILength])
+}
+
+// appendRFC3339Stamp appends the UTC time in RFC3339 with nanoseconds.
+func appendRFC3339Stamp(dst []byte, now glibtai.TAIN) []byte {
+	return glibtai.TAINTime(now).AppendFormat(dst, stampLayout)
+}
+
+// lineStamper prefixes every line it copies with a label of the time the
+// line started to arrive.
+type lineStamper struct {
+	stamp    stampFunc
+	now      func() glibtai.TAIN
+	output   *bufio.Writer
+	preserve bool
+	label    []byte
+}
+
+// newLineStamper returns a stamper writing to output.
+func newLineStamper(stamp stampFunc, output *bufio.Writer, preserve bool) *lineStamper {
+	return &lineStamper{stamp: stamp, now: glibtai.TAINNow, output: output, preserve: preserve}
+}
+
+// writeLabel writes the label and the separating space.
+func (s *lineStamper) writeLabel() error {
+	s.label = append(s.stamp(s.label[:0], s.now()), ' ')
+	_, err := s.output.Write(s.label)
+	return err
+}
+
+// copyLine copies one line, labelled as soon as it starts to arrive. It
+// returns io.EOF once the input is exhausted.
+func (s *lineStamper) copyLine(in *bufio.Reader) error {
+	if _, err := in.Peek(1); err != nil {
+		return err
+	}
+	if err := s.writeLabel(); err != nil {
+		return err
+	}
+	for {
+		chunk, rerr := in.ReadSlice('\n')
+		if _, err := s.output.Write(chunk); err != nil {
+			return err
+		}
+		if rerr != bufio.ErrBufferFull {
+			return s.endLine(rerr)
+		}
+	}
+}
+
+// endLine completes a final line without newline unless partial lines are
+// preserved.
+func (s *lineStamper) endLine(err error) error {
+	if err == io.EOF && !s.preserve {
+		if werr := s.output.WriteByte('\n'); werr != nil {
+			return werr
+		}
+	}
+	return err
+}
+
+// flushIdle flushes the output when no more input is waiting, so that
+// interactive use sees every line at once.
+func (s *lineStamper) flushIdle(in *bufio.Reader) error {
+	if in.Buffered() > 0 {
+		return nil
+	}
+	return s.output.Flush()
+}
+
+// run stamps every line of in.
+func (s *lineStamper) run(in *bufio.Reader) error {
+	for {
+		err := s.copyLine(in)
+		if err == io.EOF {
+			return s.output.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.flushIdle(in); err != nil {
+			return err
+		}
+	}
+}
+
+// stampMode picks the label format from the gtai64n flags.
+func stampMode(tai, rfc3339 bool) (stampFunc, error) {
+	switch {
+	case tai && rfc3339:
+		return nil, errors.New("-tai and -rfc3339 are mutually exclusive")
+	case tai:
+		return appendTAIStamp, nil
+	case rfc3339:
+		return appendRFC3339Stamp, nil
+	default:
+		return appendTAINStamp, nil
+	}
+}
+
+// stampOptions holds the gtai64n flags.
+type stampOptions struct {
+	tai      bool
+	rfc3339  bool
+	preserve bool
+}
+
+// stampFlags defines the gtai64n flags on fs.
+func stampFlags(fs *flag.FlagSet) *stampOptions {
+	o := &stampOptions{}
+	fs.BoolVar(&o.tai, "tai", false, "write TAI64 labels, without nanoseconds")
+	fs.BoolVar(&o.rfc3339, "rfc3339", false, "write RFC3339 UTC times instead of labels")
+	fs.BoolVar(&o.preserve, "p", false, "keep a final line without newline as it is")
+	return o
+}
+
+// GTAI64NRun prefixes each line of standard input with a TAI64N label,
+// like daemontools' tai64n.
+func GTAI64NRun(args []string) int {
+	fs := flag.NewFlagSet("gtai64n", flag.ContinueOnError)
+	opts := stampFlags(fs)
+
+	setUsage(fs, "[-tai|-rfc3339] [-p] < input")
+	if err := fs.Parse(args); err != nil {
+		return parseFailed(err)
+	}
+	stamp, err := stampMode(opts.tai, opts.rfc3339)
+	if err != nil {
+		_, _ = fmt.Println(err)
+		return 111
+	}
+
+	s := newLineStamper(stamp, bufio.NewWriter(os.Stdout), opts.preserve)
+	if err := s.run(bufio.NewReader(os.Stdin)); err != nil {
+		_, _ = fmt.Println(err)
+		return 111
+	}
+	return 0
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/karasz/glibtai"
+)
+
+// stampInput runs a stamper with a fixed clock over input
+func stampInput(t *testing.T, stamp stampFunc, preserve bool, in *bufio.Reader) string {
+	t.Helper()
+	now, err := glibtai.TAINfromString("@40000000433225833b6e1a8c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	s := newLineStamper(stamp, bufio.NewWriter(&buf), preserve)
+	s.now = func() glibtai.TAIN { return now }
+	if err := s.run(in); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestLineStamper(t *testing.T) {
+	const tain = "@40000000433225833b6e1a8c "
+	tests := []struct {
+		name     string
+		stamp    stampFunc
+		preserve bool
+		in       string
+		want     string
+	}{
+		{"tain", appendTAINStamp, false, "one\ntwo\n", tain + "one\n" + tain + "two\n"},
+		{"tai", appendTAIStamp, false, "one\n", "@4000000043322583 one\n"},
+		{"rfc3339", appendRFC3339Stamp, false, "one\n", "2005-09-22T03:30:33.997071500Z one\n"},
+		{"empty lines", appendTAINStamp, false, "\n\n", tain + "\n" + tain + "\n"},
+		{"partial line completed", appendTAINStamp, false, "one\ntwo", tain + "one\n" + tain + "two\n"},
+		{"partial line preserved", appendTAINStamp, true, "one\ntwo", tain + "one\n" + tain + "two"},
+		{"empty input", appendTAINStamp, false, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := stampInput(t, tt.stamp, tt.preserve, bufio.NewReader(strings.NewReader(tt.in)))
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineStamperLongLine(t *testing.T) {
+	line := strings.Repeat("x", 100) + "\n"
+	// A reader smaller than the line makes it arrive in several chunks
+	in := bufio.NewReaderSize(strings.NewReader(line+line), 16)
+	got := stampInput(t, appendTAINStamp, false, in)
+	want := "@40000000433225833b6e1a8c " + line + "@40000000433225833b6e1a8c " + line
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLineStamperRoundTrip(t *testing.T) {
+	got := stampInput(t, appendTAINStamp, false, bufio.NewReader(strings.NewReader("hello\n")))
+	if line := processline(got); line != "2005-09-22 03:30:33.9970715 +0000 UTC hello\n" {
+		t.Errorf("gtailocal turned %q into %q", got, line)
+	}
+}
+
+func TestStampMode(t *testing.T) {
+	if _, err := stampMode(true, true); err == nil {
+		t.Error("expected an error for -tai with -rfc3339")
+	}
+	if _, err := stampMode(false, false); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karasz/glibtai"
+)
+
+// calendarLayouts are the RFC3339 and ISO 8601 forms gtaiconv accepts.
+// Fractional seconds are accepted after the seconds of any of them. The
+// layouts without a zone are read in the -tz zone.
+var calendarLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02 15:04:05-0700",
+	"20060102T150405Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// leapSecondAt is where ":ss" starts in the extended calendar layouts.
+const leapSecondAt = len("2006-01-02T15:04")
+
+// errNotLeapSecond is returned for a :60 second outside a leap second.
+var errNotLeapSecond = errors.New("there is no leap second at this time")
+
+// isEpoch reports whether s looks like Unix epoch seconds, optionally
+// signed and with a fraction.
+func isEpoch(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	whole, frac, _ := strings.Cut(s, ".")
+	return whole != "" && isDigits(whole) && (frac == "" || isDigits(frac))
+}
+
+// isDigits reports whether s is made of decimal digits only.
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseEpoch parses Unix epoch seconds with up to nine fraction digits.
+func parseEpoch(s string) (time.Time, error) {
+	whole, frac, _ := strings.Cut(s, ".")
+	sec, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(frac) > 9 {
+		return time.Time{}, fmt.Errorf("%s: more than nine fraction digits", s)
+	}
+	nsec, _ := strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+	if strings.HasPrefix(whole, "-") {
+		nsec = -nsec
+	}
+	return time.Unix(sec, nsec), nil
+}
+
+// parseCalendar parses one of the calendarLayouts. A :60 second is read
+// as :59 and reported as leap.
+func parseCalendar(s string, loc *time.Location) (t time.Time, leap bool, err error) {
+	for _, layout := range calendarLayouts {
+		if t, err = time.ParseInLocation(layout, s, loc); err == nil {
+			return t, false, nil
+		}
+	}
+	if len(s) >= leapSecondAt+3 && s[leapSecondAt:leapSecondAt+3] == ":60" {
+		t, _, perr := parseCalendar(s[:leapSecondAt]+":59"+s[leapSecondAt+3:], loc)
+		return t, true, perr
+	}
+	return time.Time{}, false, fmt.Errorf("cannot parse %q as a time", s)
+}
+
+// encodeTime converts a human readable time or epoch seconds to TAIN.
+func encodeTime(s string, loc *time.Location) (glibtai.TAIN, error) {
+	if isEpoch(s) {
+		t, err := parseEpoch(s)
+		return glibtai.TAINfromTime(t), err
+	}
+	t, leap, err := parseCalendar(s, loc)
+	if err != nil {
+		return glibtai.TAIN{}, err
+	}
+	if !leap {
+		return glibtai.TAINfromTime(t), nil
+	}
+	if !isLeapSecond(t) {
+		return glibtai.TAIN{}, fmt.Errorf("%s: %w", s, errNotLeapSecond)
+	}
+	return glibtai.TAINAdd(glibtai.TAINfromTime(t), time.Second), nil
+}
+
+// decodeLabel decodes a TAI or TAIN label. TAI labels get zero
+// nanoseconds.
+func decodeLabel(s string) (glibtai.TAIN, error) {
+	if !strings.HasPrefix(s, "@") || labelDigits([]byte(s), 0) != len(s)-1 {
+		return glibtai.TAIN{}, fmt.Errorf("%q is not a TAI or TAIN label", s)
+	}
+	if len(s) == tainLabelDigits+1 {
+		return glibtai.TAINfromString(s)
+	}
+	t, err := glibtai.TAIfromString(s)
+	if err != nil {
+		return glibtai.TAIN{}, err
+	}
+	packed := append(glibtai.TAIPack(t), 0, 0, 0, 0)
+	return glibtai.TAINUnpack(packed), nil
+}
+
+// appendDecoded appends n as an RFC3339 time in loc. Inside a leap second
+// the seconds read 60, which time.Time cannot represent.
+func appendDecoded(dst []byte, n glibtai.TAIN, loc *time.Location) []byte {
+	t, leap := tainToTime(n)
+	t = t.In(loc)
+	if !leap {
+		return t.AppendFormat(dst, time.RFC3339Nano)
+	}
+	dst = t.AppendFormat(dst, "2006-01-02T15:04:")
+	dst = append(dst, "60"...)
+	return t.AppendFormat(dst, ".999999999Z07:00")
+}
+
+// taiConverter turns values into labels, or labels into times.
+type taiConverter struct {
+	decode bool
+	stamp  stampFunc
+	loc    *time.Location
+	line   []byte
+}
+
+// convert converts one value.
+func (c *taiConverter) convert(dst []byte, s string) ([]byte, error) {
+	if c.decode {
+		n, err := decodeLabel(s)
+		if err != nil {
+			return dst, err
+		}
+		return appendDecoded(dst, n, c.loc), nil
+	}
+	n, err := encodeTime(s, c.loc)
+	if err != nil {
+		return dst, err
+	}
+	return c.stamp(dst, n), nil
+}
+
+// writeValue converts one value and writes it on a line of its own.
+func (c *taiConverter) writeValue(s string, output *bufio.Writer) error {
+	var err error
+	if c.line, err = c.convert(c.line[:0], s); err != nil {
+		return err
+	}
+	c.line = append(c.line, '\n')
+	_, err = output.Write(c.line)
+	return err
+}
+
+// convertValues converts the values given as arguments, or one per line of
+// standard input when there are none.
+func (c *taiConverter) convertValues(values []string, output *bufio.Writer) error {
+	if len(values) == 0 {
+		return c.convertLines(bufio.NewScanner(os.Stdin), output)
+	}
+	for _, v := range values {
+		if err := c.writeValue(v, output); err != nil {
+			return err
+		}
+	}
+	return output.Flush()
+}
+
+// convertLines converts every non empty line read by sc.
+func (c *taiConverter) convertLines(sc *bufio.Scanner, output *bufio.Writer) error {
+	for sc.Scan() {
+		v := strings.TrimSpace(sc.Text())
+		if v == "" {
+			continue
+		}
+		if err := c.writeValue(v, output); err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return output.Flush()
+}
+
+// convOptions holds the gtaiconv flags.
+type convOptions struct {
+	decode bool
+	tai    bool
+	tz     string
+}
+
+// convFlags defines the gtaiconv flags on fs.
+func convFlags(fs *flag.FlagSet) *convOptions {
+	o := &convOptions{}
+	fs.BoolVar(&o.decode, "d", false, "decode labels to RFC3339 times")
+	fs.BoolVar(&o.tai, "tai", false, "write TAI64 labels, without nanoseconds")
+	fs.StringVar(&o.tz, "tz", "UTC", "zone of times without offset and of decoded times: UTC, Local or an IANA name")
+	return o
+}
+
+// GTAIConvRun converts RFC3339, ISO 8601 or Unix epoch times to TAI64N or
+// TAI64 labels, or with -d labels back to times.
+func GTAIConvRun(args []string) int {
+	fs := flag.NewFlagSet("gtaiconv", flag.ContinueOnError)
+	opts := convFlags(fs)
+
+	setUsage(fs, "[-d] [-tai] [-tz zone] [value ...]")
+	if err := fs.Parse(args); err != nil {
+		return parseFailed(err)
+	}
+	loc, err := loadZone(opts.tz)
+	if err != nil {
+		_, _ = fmt.Println(err)
+		return 111
+	}
+
+	c := &taiConverter{decode: opts.decode, stamp: appendTAINStamp, loc: loc}
+	if opts.tai {
+		c.stamp = appendTAIStamp
+	}
+	output := bufio.NewWriter(os.Stdout)
+	if err := c.convertValues(fs.Args(), output); err != nil {
+		_ = output.Flush()
+		_, _ = fmt.Println(err)
+		return 111
+	}
+	return 0
+}
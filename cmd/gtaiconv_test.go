@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeTime(t *testing.T) {
+	bucharest, err := time.LoadLocation("Europe/Bucharest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		in      string
+		loc     *time.Location
+		want    string
+		wantErr bool
+	}{
+		{"rfc3339", "2005-09-22T03:30:33Z", time.UTC, "@400000004332258300000000", false},
+		{"rfc3339 fraction", "2005-09-22T03:30:33.9970715Z", time.UTC, "@40000000433225833b6e1a8c", false},
+		{"offset", "2005-09-22T06:30:33.9970715+03:00", time.UTC, "@40000000433225833b6e1a8c", false},
+		{"space separated", "2005-09-22 03:30:33.9970715Z", time.UTC, "@40000000433225833b6e1a8c", false},
+		{"basic format", "20050922T033033Z", time.UTC, "@400000004332258300000000", false},
+		{"zone from -tz", "2005-09-22 06:30:33", bucharest, "@400000004332258300000000", false},
+		{"epoch", "1127359833", time.UTC, "@400000004332258300000000", false},
+		{"epoch fraction", "1127359833.9970715", time.UTC, "@40000000433225833b6e1a8c", false},
+		{"before leap second", "2016-12-31T23:59:59Z", time.UTC, "@40000000586846ad00000000", false},
+		{"leap second", "2016-12-31T23:59:60Z", time.UTC, "@40000000586846ae00000000", false},
+		{"after leap second", "2017-01-01T00:00:00Z", time.UTC, "@40000000586846af00000000", false},
+		{"leap second elsewhere", "2016-12-30T23:59:60Z", time.UTC, "", true},
+		{"leap minute", "2016-12-31T23:60:00Z", time.UTC, "", true},
+		{"garbage", "yesterday", time.UTC, "", true},
+		{"epoch too precise", "1.0123456789", time.UTC, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := encodeTime(tt.in, tt.loc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("encodeTime(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := string(appendTAINStamp(nil, n)); got != tt.want {
+				t.Errorf("encodeTime(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEpochNegative(t *testing.T) {
+	tm, err := parseEpoch("-1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Unix(-2, 500000000); !tm.Equal(want) {
+		t.Errorf("parseEpoch(-1.5) = %v, want %v", tm, want)
+	}
+}
+
+func TestDecodeLabel(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"@40000000433225833b6e1a8c", "2005-09-22T03:30:33.9970715Z", false},
+		{"@400000005A848EAD", "2018-02-14T19:31:10Z", false},
+		{"@40000000586846ad00000000", "2016-12-31T23:59:59Z", false},
+		{"@40000000586846ae1dcd6500", "2016-12-31T23:59:60.5Z", false},
+		{"@40000000586846af00000000", "2017-01-01T00:00:00Z", false},
+		{"@4000", "", true},
+		{"400000005A848EAD", "", true},
+		{"@400000005A848EAG", "", true},
+	}
+
+	for _, tt := range tests {
+		n, err := decodeLabel(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("decodeLabel(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if got := string(appendDecoded(nil, n, time.UTC)); got != tt.want {
+			t.Errorf("decodeLabel(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTAIConverterRoundTrip(t *testing.T) {
+	values := []string{"2016-12-31T23:59:60Z", "2005-09-22T03:30:33.9970715Z"}
+	enc := &taiConverter{stamp: appendTAINStamp, loc: time.UTC}
+	dec := &taiConverter{decode: true, loc: time.UTC}
+
+	var labels bytes.Buffer
+	if err := enc.convertValues(values, bufio.NewWriter(&labels)); err != nil {
+		t.Fatal(err)
+	}
+	var times bytes.Buffer
+	sc := bufio.NewScanner(strings.NewReader(labels.String()))
+	if err := dec.convertLines(sc, bufio.NewWriter(&times)); err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(values, "\n") + "\n"; times.String() != want {
+		t.Errorf("round trip gave %q, want %q", times.String(), want)
+	}
+}
+
+func TestTAIConverterTAILabels(t *testing.T) {
+	c := &taiConverter{stamp: appendTAIStamp, loc: time.UTC}
+	got, err := c.convert(nil, "2018-02-14T19:31:10.75Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "@400000005a848ead" {
+		t.Errorf("got %s, want @400000005a848ead", got)
+	}
+}
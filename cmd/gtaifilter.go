@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/karasz/glibtai"
+)
+
+// timeRange holds the -since and -until bounds of gtaifilter. A nil bound
+// is open.
+type timeRange struct {
+	since *glibtai.TAIN
+	until *glibtai.TAIN
+}
+
+// tainCompare returns -1, 0 or 1 as a is before, equal to or after b.
+func tainCompare(a, b glibtai.TAIN) int {
+	return bytes.Compare(glibtai.TAINPack(a), glibtai.TAINPack(b))
+}
+
+// parseBound parses a -since or -until value: a label, a duration relative
+// to now like -1h, or anything gtaiconv accepts. A bare number, 0 included,
+// is seconds since the epoch rather than a duration.
+func parseBound(s string, now glibtai.TAIN, loc *time.Location) (*glibtai.TAIN, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var n glibtai.TAIN
+	var err error
+	if strings.HasPrefix(s, "@") {
+		n, err = decodeLabel(s)
+	} else if d, derr := time.ParseDuration(s); derr == nil && !isEpoch(s) {
+		n = glibtai.TAINAdd(now, d)
+	} else {
+		n, err = encodeTime(s, loc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// newTimeRange parses both bounds.
+func newTimeRange(since, until string, loc *time.Location) (timeRange, error) {
+	now := glibtai.TAINNow()
+	var r timeRange
+	var err error
+	if r.since, err = parseBound(since, now, loc); err != nil {
+		return r, err
+	}
+	if r.until, err = parseBound(until, now, loc); err != nil {
+		return r, err
+	}
+	if r.since != nil && r.until != nil && tainCompare(*r.since, *r.until) > 0 {
+		return r, errors.New("-since is after -until")
+	}
+	return r, nil
+}
+
+// beforeSince reports whether n is before the -since bound.
+func (r timeRange) beforeSince(n glibtai.TAIN) bool {
+	return r.since != nil && tainCompare(n, *r.since) < 0
+}
+
+// afterUntil reports whether n is after the -until bound.
+func (r timeRange) afterUntil(n glibtai.TAIN) bool {
+	return r.until != nil && tainCompare(n, *r.until) > 0
+}
+
+// contains reports whether n is within the range.
+func (r timeRange) contains(n glibtai.TAIN) bool {
+	return !r.beforeSince(n) && !r.afterUntil(n)
+}
+
+// archiveLabel returns the rotation time in the name of a rotated log.
+func archiveLabel(path string) glibtai.TAIN {
+	n, _ := glibtai.TAINfromString(filepath.Base(path)[:tainLabelDigits+1])
+	return n
+}
+
+// selectArchives returns the rotated logs, in chronological order, that can
+// hold lines within r. A rotated file holds the lines written after the
+// rotation of the one before it and up to its own name, so the first file
+// needed is found by binary search and the files after the first one
+// rotated past -until are left out. more reports whether the lines after
+// the last archive, those of current, may still be in range.
+func selectArchives(archives []string, r timeRange) (selected []string, more bool) {
+	first := 0
+	if r.since != nil {
+		first = sort.Search(len(archives), func(i int) bool {
+			return !r.beforeSince(archiveLabel(archives[i]))
+		})
+	}
+	last := len(archives)
+	if r.until != nil {
+		last = sort.Search(len(archives), func(i int) bool {
+			return r.afterUntil(archiveLabel(archives[i]))
+		})
+	}
+	more = last == len(archives)
+	if !more {
+		// That file still holds the lines up to -until
+		last++
+	}
+	return archives[min(first, last):last], more
+}
+
+// filterInputs resolves the arguments to the files to read. Directories
+// are multilog directories whose rotated files are selected by name.
+func filterInputs(args []string, r timeRange) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if arg == "-" || (err == nil && !info.IsDir()) {
+			files = append(files, arg)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		selected, err := filterLogDir(arg, r)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, selected...)
+	}
+	return files, nil
+}
+
+// filterLogDir returns the files of a multilog directory that can hold
+// lines within r.
+func filterLogDir(dir string, r timeRange) ([]string, error) {
+	archives, err := archivedLogs(dir)
+	if err != nil {
+		return nil, err
+	}
+	selected, more := selectArchives(archives, r)
+	current := filepath.Join(dir, multilogCurrent)
+	if _, err := os.Stat(current); err == nil && more {
+		selected = append(selected, current)
+	}
+	return selected, nil
+}
+
+// lineLabel decodes the TAI64N label a line starts with.
+func lineLabel(line []byte) (glibtai.TAIN, bool) {
+	if len(line) < tainLabelDigits+1 || line[0] != '@' {
+		return glibtai.TAIN{}, false
+	}
+	if labelDigits(line, 0) != tainLabelDigits {
+		return glibtai.TAIN{}, false
+	}
+	return labelTAIN(parseLabelDigits(line[1 : tainLabelDigits+1])), true
+}
+
+// lineFilter copies the lines within a time range. Lines without a label
+// continue the entry before them and share its fate.
+type lineFilter struct {
+	r      timeRange
+	output *bufio.Writer
+	keep   bool
+}
+
+// filterStream copies the lines of in that are within range.
+func (f *lineFilter) filterStream(in *bufio.Reader) error {
+	// Leading lines without a label are only kept when nothing is cut off
+	f.keep = f.r.since == nil
+	for {
+		line, err := in.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(line) > 0 {
+			f.filterLine(line)
+		}
+		if err == io.EOF {
+			return f.output.Flush()
+		}
+	}
+}
+
+// filterLine writes line when it is within range.
+func (f *lineFilter) filterLine(line []byte) {
+	if n, ok := lineLabel(line); ok {
+		f.keep = f.r.contains(n)
+	}
+	if f.keep {
+		_, _ = f.output.Write(line)
+	}
+}
+
+// filterFiles filters every file in turn.
+func (f *lineFilter) filterFiles(files []string) error {
+	for _, path := range files {
+		in, err := openInput(path, false, nil)
+		if err != nil {
+			return err
+		}
+		err = f.filterStream(bufio.NewReader(in))
+		_ = in.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filterOptions holds the gtaifilter flags.
+type filterOptions struct {
+	since string
+	until string
+	tz    string
+}
+
+// filterFlags defines the gtaifilter flags on fs.
+func filterFlags(fs *flag.FlagSet) *filterOptions {
+	o := &filterOptions{}
+	fs.StringVar(&o.since, "since", "", "first time to keep: a label, a time, epoch seconds or a duration from now like -1h")
+	fs.StringVar(&o.until, "until", "", "last time to keep, in the same forms as -since")
+	fs.StringVar(&o.tz, "tz", "UTC", "zone of times without offset: UTC, Local or an IANA name")
+	return o
+}
+
+// GTAIFilterRun copies the lines of TAI64N stamped logs whose labels are
+// within -since and -until.
+func GTAIFilterRun(args []string) int {
+	fs := flag.NewFlagSet("gtaifilter", flag.ContinueOnError)
+	opts := filterFlags(fs)
+
+	setUsage(fs, "[-since time] [-until time] [-tz zone] [file|dir ...]")
+	if err := fs.Parse(args); err != nil {
+		return parseFailed(err)
+	}
+	r, err := filterRange(opts.since, opts.until, opts.tz)
+	if err != nil {
+		_, _ = fmt.Println(err)
+		return 111
+	}
+
+	inputs, err := expandInputs(fs.Args())
+	if err == nil {
+		inputs, err = filterInputs(inputs, r)
+	}
+	if err != nil {
+		_, _ = fmt.Println(err)
+		return 111
+	}
+
+	f := &lineFilter{r: r, output: bufio.NewWriter(os.Stdout)}
+	if err := f.filterFiles(inputs); err != nil {
+		_, _ = fmt.Println(err)
+		return 111
+	}
+	return 0
+}
+
+// filterRange builds the time range from the gtaifilter flags.
+func filterRange(since, until, tz string) (timeRange, error) {
+	loc, err := loadZone(tz)
+	if err != nil {
+		return timeRange{}, err
+	}
+	return newTimeRange(since, until, loc)
+}
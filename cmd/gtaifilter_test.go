@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karasz/glibtai"
+)
+
+// mustTAIN decodes a label or fails the test
+func mustTAIN(t *testing.T, label string) glibtai.TAIN {
+	t.Helper()
+	n, err := glibtai.TAINfromString(label)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// labelRange builds a range from two labels, empty meaning open
+func labelRange(t *testing.T, since, until string) timeRange {
+	t.Helper()
+	r, err := newTimeRange(since, until, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestParseBound(t *testing.T) {
+	now := mustTAIN(t, "@400000005a848ead00000000")
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"@400000005a848eac00000000", "@400000005a848eac00000000", false},
+		{"@400000005a848eac", "@400000005a848eac00000000", false},
+		{"-1h", "@400000005a84809d00000000", false},
+		{"90s", "@400000005a848f0700000000", false},
+		{"2018-02-14T19:31:10Z", "@400000005a848ead00000000", false},
+		{"1518636670", "@400000005a848ead00000000", false},
+		{"0", "@400000000000000a00000000", false},
+		{"soon", "", true},
+		{"@12", "", true},
+	}
+
+	for _, tt := range tests {
+		n, err := parseBound(tt.in, now, time.UTC)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseBound(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if got := string(appendTAINStamp(nil, *n)); got != tt.want {
+			t.Errorf("parseBound(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNewTimeRangeOrder(t *testing.T) {
+	if _, err := newTimeRange("@400000005a848ead", "@400000005a848eac", time.UTC); err == nil {
+		t.Error("expected an error when -since is after -until")
+	}
+}
+
+func TestSelectArchives(t *testing.T) {
+	archives := []string{
+		"/log/@400000000000001000000000.s",
+		"/log/@400000000000002000000000.s",
+		"/log/@400000000000003000000000.s",
+	}
+	tests := []struct {
+		name     string
+		since    string
+		until    string
+		want     []int
+		wantMore bool
+	}{
+		{"open", "", "", []int{0, 1, 2}, true},
+		{"since in second file", "@400000000000001800000000", "", []int{1, 2}, true},
+		{"since on a rotation", "@400000000000002000000000", "", []int{1, 2}, true},
+		{"since after all", "@400000000000004000000000", "", nil, true},
+		{"until in second file", "", "@400000000000001800000000", []int{0, 1}, false},
+		{"until before all", "", "@400000000000000100000000", []int{0}, false},
+		{"until after all", "", "@400000000000004000000000", []int{0, 1, 2}, true},
+		{"window", "@400000000000002100000000", "@400000000000002200000000", []int{2}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, more := selectArchives(archives, labelRange(t, tt.since, tt.until))
+			var want []string
+			for _, i := range tt.want {
+				want = append(want, archives[i])
+			}
+			if strings.Join(got, ",") != strings.Join(want, ",") || more != tt.wantMore {
+				t.Errorf("selectArchives() = %v, %v; want %v, %v", got, more, want, tt.wantMore)
+			}
+		})
+	}
+}
+
+func TestFilterStream(t *testing.T) {
+	input := "leading\n" +
+		"@400000000000001000000000 early\n" +
+		"@400000000000002000000000 start\n" +
+		"  continued\n" +
+		"@400000000000002500000000 middle\n" +
+		"@400000000000003000000000 end\n" +
+		"@400000000000003000000001 late\n" +
+		"  late continued"
+
+	var buf bytes.Buffer
+	f := &lineFilter{
+		r:      labelRange(t, "@400000000000002000000000", "@400000000000003000000000"),
+		output: bufio.NewWriter(&buf),
+	}
+	if err := f.filterStream(bufio.NewReader(strings.NewReader(input))); err != nil {
+		t.Fatal(err)
+	}
+	want := "@400000000000002000000000 start\n" +
+		"  continued\n" +
+		"@400000000000002500000000 middle\n" +
+		"@400000000000003000000000 end\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestFilterLogDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "@400000000000001000000000.s"), "@400000000000000800000000 a\n")
+	writeFile(t, filepath.Join(dir, "@400000000000002000000000.s"), "@400000000000001800000000 b\n")
+	writeFile(t, filepath.Join(dir, "@400000000000003000000000.s"), "@400000000000002800000000 c\n")
+	writeFile(t, filepath.Join(dir, "current"), "@400000000000003800000000 d\n")
+
+	r := labelRange(t, "@400000000000001500000000", "@400000000000002900000000")
+	files, err := filterInputs([]string{dir}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected the second and third rotated files, got %v", files)
+	}
+
+	var buf bytes.Buffer
+	f := &lineFilter{r: r, output: bufio.NewWriter(&buf)}
+	if err := f.filterFiles(files); err != nil {
+		t.Fatal(err)
+	}
+	if want := "@400000000000001800000000 b\n@400000000000002800000000 c\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
@@ -2,122 +2,413 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
-	"strings"
+	"path/filepath"
+	"time"
 
 	"github.com/karasz/glibtai"
 )
 
-// tryParseTimestamp attempts to parse and replace a timestamp at the given position.
-func tryParseTimestamp(working string, atpos int, length int) (string, bool) {
-	if len(working) < atpos+length {
-		return working, false
-	}
+// Hex digits after the '@' of TAI and TAIN labels.
+const (
+	taiLabelDigits  = 2 * glibtai.TAILength
+	tainLabelDigits = 2 * glibtai.TAINLength
+)
 
-	lbl := working[atpos : atpos+length]
+// isWordByte reports whether c belongs to a word, so a label touching it
+// is part of something else, like an address or a file name.
+func isWordByte(c byte) bool {
+	return isHexDigit(c) || c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
 
-	if length == 25 {
-		// Try TAIN format first
-		if tn, err := glibtai.TAINfromString(lbl); err == nil {
-			return strings.Replace(working, lbl, fmt.Sprint(glibtai.TAINTime(tn)), 1), true
-		}
+// labelDigits returns the number of hex digits of a label whose '@' is at
+// b[at], or 0 when there is no TAI or TAIN label there.
+func labelDigits(b []byte, at int) int {
+	if at > 0 && isWordByte(b[at-1]) {
+		return 0
+	}
+	n := 0
+	for at+1+n < len(b) && isHexDigit(b[at+1+n]) {
+		n++
+	}
+	if end := at + 1 + n; end < len(b) && isWordByte(b[end]) {
+		return 0
+	}
+	if n != taiLabelDigits && n != tainLabelDigits {
+		return 0
 	}
+	return n
+}
 
-	if length >= 17 {
-		// Try TAI format
-		if t, err := glibtai.TAIfromString(lbl[:17]); err == nil {
-			return strings.Replace(working, lbl[:17], fmt.Sprint(glibtai.TAITime(t)), 1), true
-		}
+// parseHex returns the value of digits, which labelDigits has checked.
+func parseHex(digits []byte) uint64 {
+	var v uint64
+	for _, c := range digits {
+		v = v<<4 | uint64(hexDigits[c])
 	}
+	return v
+}
 
-	return working, false
+// parseLabelDigits returns the seconds and nanoseconds of the 16 or 24 hex
+// digits of a label.
+func parseLabelDigits(digits []byte) (sec uint64, nano uint32) {
+	sec = parseHex(digits[:taiLabelDigits])
+	if len(digits) == tainLabelDigits {
+		nano = uint32(parseHex(digits[taiLabelDigits:]))
+	}
+	return sec, nano
 }
 
-func processline(s string) string {
-	working := s
-	atpos := strings.Index(working, "@")
-	if atpos == -1 {
-		return working
+// labelTAIN builds a TAIN from its seconds and nanoseconds.
+func labelTAIN(sec uint64, nano uint32) glibtai.TAIN {
+	var buf [glibtai.TAINLength]byte
+	binary.BigEndian.PutUint64(buf[:], sec)
+	binary.BigEndian.PutUint32(buf[glibtai.TAILength:], nano)
+	return glibtai.TAINUnpack(buf[:])
+}
+
+// labelCache converts label seconds to UTC, remembering the last second
+// converted since log lines come many to a second.
+type labelCache struct {
+	sec  uint64
+	base time.Time
+	ok   bool
+}
+
+// labelTime returns the UTC time of a label.
+func (lc *labelCache) labelTime(sec uint64, nano uint32) time.Time {
+	if !lc.ok || lc.sec != sec {
+		lc.base, _ = tainToTime(labelTAIN(sec, 0))
+		lc.sec, lc.ok = sec, true
 	}
+	return lc.base.Add(time.Duration(nano))
+}
 
-	// Try TAIN format (25 chars) first
-	if result, ok := tryParseTimestamp(working, atpos, 25); ok {
-		return result
+// convertLabelAt appends the time of the label whose '@' is at line[at]
+// and returns the length of the label, or 0 when there is none.
+func (lc *labelCache) convertLabelAt(dst, line []byte, at int, f *timeFormatter) ([]byte, int) {
+	n := labelDigits(line, at)
+	if n == 0 {
+		return dst, 0
+	}
+	sec, nano := parseLabelDigits(line[at+1 : at+1+n])
+	if nano >= uint32(time.Second) {
+		return dst, 0
 	}
+	return f.appendTime(dst, lc.labelTime(sec, nano)), 1 + n
+}
 
-	// Try TAI format (17 chars)
-	if result, ok := tryParseTimestamp(working, atpos, 17); ok {
-		return result
+// appendConverted appends line to dst with every TAI and TAIN label
+// replaced by its time as printed by f. An '@' that does not start a label
+// is copied unchanged.
+func (lc *labelCache) appendConverted(dst, line []byte, f *timeFormatter) []byte {
+	pos := 0
+	for {
+		i := bytes.IndexByte(line[pos:], '@')
+		if i == -1 {
+			return append(dst, line[pos:]...)
+		}
+		at := pos + i
+		dst = append(dst, line[pos:at]...)
+		var used int
+		if dst, used = lc.convertLabelAt(dst, line, at, f); used == 0 {
+			dst = append(dst, '@')
+			used = 1
+		}
+		pos = at + used
 	}
+}
 
-	return working
+// processline converts a line with the default output format.
+func processline(s string) string {
+	return convertLine(s, defaultFormatter)
 }
 
-// validateInputFile checks if the input file is suitable for processing.
-func validateInputFile(file *os.File) error {
-	info, err := file.Stat()
-	if err != nil {
+// convertLine replaces the TAI and TAIN labels in s with their times as
+// printed by f.
+func convertLine(s string, f *timeFormatter) string {
+	var lc labelCache
+	return string(lc.appendConverted(nil, []byte(s), f))
+}
+
+// localConverter holds what gtailocal needs while converting its inputs.
+// Output is flushed when reading would block and at the end of the input,
+// or after every line when lineBuffered is set.
+type localConverter struct {
+	format       *timeFormatter
+	output       *bufio.Writer
+	follow       bool
+	lineBuffered bool
+	record       recordFormat
+	jsonFields   bool
+	source       string
+	stop         <-chan struct{}
+	labels       labelCache
+	line         []byte
+	buf          []byte
+}
+
+// appendRecord appends line converted to the output format.
+func (c *localConverter) appendRecord(dst, line []byte) []byte {
+	switch c.record {
+	case recordJSON:
+		return c.labels.appendJSONRecord(dst, splitRecord(line, c.source), c.format)
+	case recordLogfmt:
+		return c.labels.appendLogfmtRecord(dst, splitRecord(line, c.source), c.format)
+	}
+	if c.jsonFields {
+		if out, ok := c.labels.rewriteJSONTime(dst, line, c.format); ok {
+			return out
+		}
+	}
+	return c.labels.appendConverted(dst, line, c.format)
+}
+
+// writeLine converts a line and writes it to output.
+func (c *localConverter) writeLine(line []byte) error {
+	c.buf = c.appendRecord(c.buf[:0], line)
+	if _, err := c.output.Write(c.buf); err != nil {
 		return err
 	}
+	if c.lineBuffered {
+		return c.output.Flush()
+	}
+	return nil
+}
 
-	if info.Mode()&os.ModeNamedPipe == 0 {
-		return errors.New("the command is intended to work with pipes.\nUsage: cat logfile | gtailocal")
+// readLine returns the next line of in, which is only valid until the
+// next read. Lines longer than the buffer of in are assembled in c.line.
+func (c *localConverter) readLine(in *bufio.Reader) ([]byte, error) {
+	chunk, err := in.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return chunk, err
 	}
+	c.line = append(c.line[:0], chunk...)
+	for err == bufio.ErrBufferFull {
+		chunk, err = in.ReadSlice('\n')
+		c.line = append(c.line, chunk...)
+	}
+	return c.line, err
+}
 
-	return nil
+// convertNextLine converts the next line of in, returning io.EOF at the
+// end of the input. A final line without a newline is converted as well.
+func (c *localConverter) convertNextLine(in *bufio.Reader) error {
+	line, err := c.readLine(in)
+	if len(line) > 0 {
+		if werr := c.writeLine(line); werr != nil {
+			return werr
+		}
+	}
+	return err
 }
 
-// processAndWriteLine processes a single line and writes it to output.
-func processAndWriteLine(line string, output *bufio.Writer) error {
-	processed := processline(line)
-	if _, err := output.WriteString(processed); err != nil {
-		return err
+// flushIdle flushes the output when no more input is waiting, so that
+// nothing lingers in the buffer while reading blocks.
+func (c *localConverter) flushIdle(in *bufio.Reader) error {
+	if in.Buffered() > 0 {
+		return nil
 	}
-	return output.Flush()
+	return c.output.Flush()
 }
 
 // processInputStream reads from input and writes processed lines to output.
-func processInputStream(in *bufio.Reader, output *bufio.Writer) error {
+func (c *localConverter) processInputStream(in *bufio.Reader) error {
 	for {
-		input, err := in.ReadString('\n')
+		err := c.convertNextLine(in)
 		if err == io.EOF {
-			break
+			return c.output.Flush()
 		}
 		if err != nil {
 			return err
 		}
-
-		if err := processAndWriteLine(input, output); err != nil {
+		if err := c.flushIdle(in); err != nil {
 			return err
 		}
 	}
+}
+
+// resolveInput returns the files an argument stands for, in order. A
+// directory is read as a multilog directory; when following, its current
+// file is always last.
+func resolveInput(arg string, follow bool) ([]string, error) {
+	if arg == "-" {
+		return []string{arg}, nil
+	}
+	info, err := os.Stat(arg)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{arg}, nil
+	}
+	if !follow {
+		return logDirFiles(arg)
+	}
+	files, err := archivedLogs(arg)
+	if err != nil {
+		return nil, err
+	}
+	return append(files, filepath.Join(arg, multilogCurrent)), nil
+}
+
+// resolveInputs resolves every argument; only the last one is followed.
+func resolveInputs(args []string, follow bool) ([]string, error) {
+	var files []string
+	for i, arg := range args {
+		resolved, err := resolveInput(arg, follow && i == len(args)-1)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, resolved...)
+	}
+	return files, nil
+}
+
+// openInput opens one input, "-" being standard input. A followed file
+// never reaches end of file until stop is closed.
+func openInput(path string, follow bool, stop <-chan struct{}) (io.ReadCloser, error) {
+	switch {
+	case path == "-":
+		return io.NopCloser(os.Stdin), nil
+	case follow:
+		return newFollowReader(path, stop)
+	default:
+		return os.Open(path)
+	}
+}
 
+// processFiles converts the files in order, following the last one when
+// follow is set.
+func (c *localConverter) processFiles(files []string) error {
+	for i, path := range files {
+		in, err := openInput(path, c.follow && i == len(files)-1, c.stop)
+		if err != nil {
+			return err
+		}
+		c.source = path
+		if path == "-" {
+			c.source = ""
+		}
+		err = c.processInputStream(bufio.NewReader(in))
+		_ = in.Close()
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// GTAILocalRun converts TAI and TAIN timestamps to RFC3339 format from standard input.
+// convertInputs converts the inputs one after the other.
+func (c *localConverter) convertInputs(inputs []string) error {
+	files, err := resolveInputs(inputs, c.follow)
+	if err != nil {
+		return err
+	}
+	return c.processFiles(files)
+}
+
+// localOptions holds the gtailocal flags.
+type localOptions struct {
+	follow       bool
+	lineBuffered bool
+	merge        bool
+	tag          bool
+	jsonFields   bool
+	output       string
+	format       string
+	precision    int
+	tz           string
+}
+
+// localFlags defines the gtailocal flags on fs.
+func localFlags(fs *flag.FlagSet) *localOptions {
+	o := &localOptions{}
+	fs.BoolVar(&o.follow, "f", false, "follow the last file, or the current file of a log directory, across rotations")
+	fs.BoolVar(&o.lineBuffered, "l", false, "flush the output after every line")
+	fs.BoolVar(&o.merge, "m", false, "merge the inputs into one stream ordered by label")
+	fs.BoolVar(&o.tag, "tag", false, "with -m, prefix every line with the name of its input")
+	fs.BoolVar(&o.jsonFields, "j", false, "rewrite labels held by the time or ts field of JSON lines")
+	fs.StringVar(&o.output, "o", "text", "output: text, json or logfmt")
+	fs.StringVar(&o.format, "format", formatDefault,
+		"time format: default, rfc3339, rfc3339nano, iso8601, unix or +strftime")
+	fs.IntVar(&o.precision, "precision", -1, "fraction digits for the iso8601 and unix formats")
+	fs.StringVar(&o.tz, "tz", "UTC", "time zone: UTC, Local or an IANA name")
+	return o
+}
+
+// isFlagSet reports whether the flag name was given on the command line.
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
+
+// converter builds the converter the options ask for. Structured output
+// defaults to RFC3339 times with nanoseconds.
+func (o *localOptions) converter(fs *flag.FlagSet) (*localConverter, error) {
+	if o.follow && o.merge {
+		return nil, errors.New("-f and -m cannot be used together")
+	}
+	record, err := parseRecordFormat(o.output)
+	if err != nil {
+		return nil, err
+	}
+	format := o.format
+	if (record != recordText || o.jsonFields) && !isFlagSet(fs, "format") {
+		format = formatRFC3339Nano
+	}
+	formatter, err := newTimeFormatter(format, o.precision, o.tz)
+	if err != nil {
+		return nil, err
+	}
+	return &localConverter{
+		format:       formatter,
+		output:       bufio.NewWriter(os.Stdout),
+		follow:       o.follow,
+		lineBuffered: o.lineBuffered,
+		record:       record,
+		jsonFields:   o.jsonFields,
+	}, nil
+}
+
+// GTAILocalRun converts TAI and TAIN timestamps from standard input, files
+// or multilog directories.
 func GTAILocalRun(args []string) int {
-	file := os.Stdin
-	if len(args) > 0 && args[0] != "-" {
-		// In future, could support file input here, but for now we bail out
-		_, _ = fmt.Println("we do not support calling filenames yet")
+	fs := flag.NewFlagSet("gtailocal", flag.ContinueOnError)
+	opts := localFlags(fs)
+
+	setUsage(fs, "[flags] [file|glob|dir ...]")
+	if err := fs.Parse(args); err != nil {
+		return parseFailed(err)
+	}
+	c, err := opts.converter(fs)
+	if err != nil {
+		_, _ = fmt.Println(err)
 		return 111
 	}
-
-	if err := validateInputFile(file); err != nil {
+	inputs, err := expandInputs(fs.Args())
+	if err != nil {
 		_, _ = fmt.Println(err)
 		return 111
 	}
 
-	in := bufio.NewReader(file)
-	output := bufio.NewWriter(os.Stdout)
-
-	if err := processInputStream(in, output); err != nil {
+	if opts.merge {
+		err = c.mergeInputs(inputs, opts.tag)
+	} else {
+		err = c.convertInputs(inputs)
+	}
+	if err != nil {
 		_, _ = fmt.Println(err)
-		_ = output.Flush()
+		_ = c.output.Flush()
 		return 111
 	}
 
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultLayout is how gtailocal has always printed times, as fmt.Sprint
+// does for a time.Time.
+const defaultLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// Output formats accepted by gtailocal -format. A format starting with '+'
+// is a strftime layout, as for date(1).
+const (
+	formatDefault     = "default"
+	formatRFC3339     = "rfc3339"
+	formatRFC3339Nano = "rfc3339nano"
+	formatISO8601     = "iso8601"
+	formatUnix        = "unix"
+)
+
+// Default fraction digits when -precision is not given.
+const (
+	iso8601Precision = 9
+	unixPrecision    = 0
+)
+
+// appendFunc appends a formatted time to dst.
+type appendFunc func(dst []byte, t time.Time) []byte
+
+// timeFormatter renders the times decoded from TAI and TAIN labels.
+type timeFormatter struct {
+	loc    *time.Location
+	append appendFunc
+}
+
+// defaultFormatter prints UTC times in the default layout.
+var defaultFormatter = &timeFormatter{loc: time.UTC, append: layoutAppender(defaultLayout)}
+
+// newTimeFormatter returns a formatter for format in the zone tz, which is
+// UTC, Local or an IANA name. A negative precision selects the default
+// number of fraction digits of the format.
+func newTimeFormatter(format string, precision int, tz string) (*timeFormatter, error) {
+	loc, err := loadZone(tz)
+	if err != nil {
+		return nil, err
+	}
+	if precision > 9 {
+		return nil, errors.New("precision must be between 0 and 9")
+	}
+	fn, err := formatAppender(format, precision)
+	if err != nil {
+		return nil, err
+	}
+	return &timeFormatter{loc: loc, append: fn}, nil
+}
+
+// loadZone resolves a -tz value.
+func loadZone(tz string) (*time.Location, error) {
+	switch tz {
+	case "", "UTC", "utc":
+		return time.UTC, nil
+	case "Local", "local":
+		return time.Local, nil
+	default:
+		return time.LoadLocation(tz)
+	}
+}
+
+// formatAppender returns the append function for a -format value.
+func formatAppender(format string, precision int) (appendFunc, error) {
+	if strings.HasPrefix(format, "+") {
+		return compileStrftime(format[1:])
+	}
+	switch strings.ToLower(format) {
+	case formatDefault:
+		return layoutAppender(defaultLayout), nil
+	case formatRFC3339:
+		return layoutAppender(time.RFC3339), nil
+	case formatRFC3339Nano:
+		return layoutAppender(time.RFC3339Nano), nil
+	case formatISO8601:
+		return layoutAppender(iso8601Layout(withDefault(precision, iso8601Precision))), nil
+	case formatUnix:
+		digits := withDefault(precision, unixPrecision)
+		return func(dst []byte, t time.Time) []byte { return appendUnix(dst, t, digits) }, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// withDefault returns precision, or def when precision is negative.
+func withDefault(precision, def int) int {
+	if precision < 0 {
+		return def
+	}
+	return precision
+}
+
+// iso8601Layout returns an ISO 8601 layout with a fixed number of fraction
+// digits.
+func iso8601Layout(digits int) string {
+	layout := "2006-01-02T15:04:05"
+	if digits > 0 {
+		layout += "." + strings.Repeat("0", digits)
+	}
+	return layout + "Z07:00"
+}
+
+// layoutAppender returns an append function for a Go time layout.
+func layoutAppender(layout string) appendFunc {
+	return func(dst []byte, t time.Time) []byte { return t.AppendFormat(dst, layout) }
+}
+
+// appendUnix appends the seconds since the Unix epoch with digits fraction
+// digits.
+func appendUnix(dst []byte, t time.Time, digits int) []byte {
+	sec, nsec := t.Unix(), t.Nanosecond()
+	if sec < 0 && nsec > 0 {
+		// Keep the fraction positive while counting towards the epoch
+		sec++
+		nsec = int(time.Second) - nsec
+		if sec == 0 {
+			dst = append(dst, '-')
+		}
+	}
+	dst = strconv.AppendInt(dst, sec, 10)
+	if digits > 0 {
+		dst = append(dst, '.')
+		dst = appendFraction(dst, nsec, digits)
+	}
+	return dst
+}
+
+// appendFraction appends the first digits digits of nanoseconds.
+func appendFraction(dst []byte, nsec, digits int) []byte {
+	var buf [9]byte
+	for i := 8; i >= 0; i-- {
+		buf[i] = byte('0' + nsec%10)
+		nsec /= 10
+	}
+	return append(dst, buf[:digits]...)
+}
+
+// appendTime appends t, moved to the formatter's zone, to dst.
+func (f *timeFormatter) appendTime(dst []byte, t time.Time) []byte {
+	return f.append(dst, t.In(f.loc))
+}
+
+// format returns t as a string.
+func (f *timeFormatter) format(t time.Time) string {
+	return string(f.appendTime(nil, t))
+}
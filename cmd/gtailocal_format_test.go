@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeFormatter(t *testing.T) {
+	label := "x @40000000433225833b6e1a8c y"
+	tests := []struct {
+		name      string
+		format    string
+		precision int
+		tz        string
+		want      string
+	}{
+		{"default", formatDefault, -1, "UTC", "x 2005-09-22 03:30:33.9970715 +0000 UTC y"},
+		{"rfc3339", formatRFC3339, -1, "UTC", "x 2005-09-22T03:30:33Z y"},
+		{"rfc3339nano", formatRFC3339Nano, -1, "UTC", "x 2005-09-22T03:30:33.9970715Z y"},
+		{"iso8601 default precision", formatISO8601, -1, "UTC", "x 2005-09-22T03:30:33.997071500Z y"},
+		{"iso8601 milliseconds", formatISO8601, 3, "UTC", "x 2005-09-22T03:30:33.997Z y"},
+		{"iso8601 seconds", formatISO8601, 0, "UTC", "x 2005-09-22T03:30:33Z y"},
+		{"iso8601 zone", formatISO8601, 0, "Europe/Bucharest", "x 2005-09-22T06:30:33+03:00 y"},
+		{"unix", formatUnix, -1, "UTC", "x 1127359833 y"},
+		{"unix micro", formatUnix, 6, "UTC", "x 1127359833.997071 y"},
+		{"strftime", "+%Y/%m/%d %T.%3N %Z", -1, "UTC", "x 2005/09/22 03:30:33.997 UTC y"},
+		{"strftime zone", "+%F %H:%M %z", -1, "America/New_York", "x 2005-09-21 23:30 -0400 y"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newTimeFormatter(tt.format, tt.precision, tt.tz)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := convertLine(label, f); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeFormatterTAI(t *testing.T) {
+	f, err := newTimeFormatter(formatRFC3339, -1, "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := convertLine("@400000005A848EAD", f); got != "2018-02-14T19:31:10Z" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestNewTimeFormatterErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		precision int
+		tz        string
+	}{
+		{"unknown format", "rfc822", -1, "UTC"},
+		{"unknown zone", formatRFC3339, -1, "Nowhere/Special"},
+		{"precision too large", formatISO8601, 10, "UTC"},
+		{"bad strftime", "+%Q", -1, "UTC"},
+	}
+
+	for _, tt := range tests {
+		if _, err := newTimeFormatter(tt.format, tt.precision, tt.tz); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestAppendUnixBeforeEpoch(t *testing.T) {
+	tm := time.Unix(-1, 250000000)
+	if got := string(appendUnix(nil, tm, 2)); got != "-0.75" {
+		t.Errorf("got %q, want %q", got, "-0.75")
+	}
+	tm = time.Unix(-3, 500000000)
+	if got := string(appendUnix(nil, tm, 1)); got != "-2.5" {
+		t.Errorf("got %q, want %q", got, "-2.5")
+	}
+}
@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// followInterval is how often a followed file is polled for new data.
+const followInterval = 250 * time.Millisecond
+
+// multilogCurrent is the file daemontools and s6 loggers write to.
+const multilogCurrent = "current"
+
+// hasGlobMeta reports whether path contains glob metacharacters.
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// expandInputs expands glob patterns in args, keeping other arguments as
+// they are so missing files are reported when opened. No arguments means
+// standard input.
+func expandInputs(args []string) ([]string, error) {
+	if len(args) == 0 {
+		return []string{"-"}, nil
+	}
+
+	var inputs []string
+	for _, arg := range args {
+		if arg == "-" || !hasGlobMeta(arg) {
+			inputs = append(inputs, arg)
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, errors.New("no files match " + arg)
+		}
+		inputs = append(inputs, matches...)
+	}
+	return inputs, nil
+}
+
+// isArchivedLog reports whether name is a rotated multilog file, named
+// after the TAI64N label of its rotation: @<24 hex digits>.s (or .u for
+// files that were being written when the logger died).
+func isArchivedLog(name string) bool {
+	if len(name) != 27 || name[0] != '@' {
+		return false
+	}
+	if ext := name[25:]; ext != ".s" && ext != ".u" {
+		return false
+	}
+	for i := 1; i < 25; i++ {
+		if !isHexDigit(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// notHex marks the bytes of hexDigits that are not hex digits.
+const notHex = 0xff
+
+// hexDigits maps every byte to its value as a hex digit, or notHex.
+var hexDigits = func() (table [256]byte) {
+	for i := range table {
+		table[i] = notHex
+	}
+	for i, c := range "0123456789abcdef" {
+		table[c] = byte(i)
+		table[c-'a'+'A'] = byte(i)
+	}
+	return table
+}()
+
+// isHexDigit reports whether c is a hexadecimal digit.
+func isHexDigit(c byte) bool {
+	return hexDigits[c] != notHex
+}
+
+// archivedLogs returns the rotated files of a multilog directory in
+// chronological order.
+func archivedLogs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && isArchivedLog(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	// Labels are fixed width hex, so they sort like the times they encode
+	sort.Slice(names, func(i, j int) bool {
+		return strings.ToLower(names[i]) < strings.ToLower(names[j])
+	})
+
+	files := make([]string, len(names))
+	for i, name := range names {
+		files[i] = filepath.Join(dir, name)
+	}
+	return files, nil
+}
+
+// logDirFiles returns the files of a multilog directory in chronological
+// order: the rotated files followed by current, when present.
+func logDirFiles(dir string) ([]string, error) {
+	files, err := archivedLogs(dir)
+	if err != nil {
+		return nil, err
+	}
+	current := filepath.Join(dir, multilogCurrent)
+	if _, err := os.Stat(current); err == nil {
+		files = append(files, current)
+	}
+	return files, nil
+}
+
+// followReader reads a file like tail -F: at end of file it waits for more
+// data, and when the path is replaced, as multilog does on rotation, it
+// finishes the old file and carries on with the new one.
+type followReader struct {
+	path     string
+	file     *os.File
+	interval time.Duration
+	stop     <-chan struct{}
+}
+
+// newFollowReader opens path for following until stop is closed.
+func newFollowReader(path string, stop <-chan struct{}) (*followReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &followReader{path: path, file: file, interval: followInterval, stop: stop}, nil
+}
+
+// Read returns available data, blocking while the file has none.
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.file.Read(p)
+		if n > 0 {
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		switched, err := r.reopenIfRotated()
+		if err != nil {
+			return 0, err
+		}
+		if switched {
+			continue
+		}
+
+		select {
+		case <-r.stop:
+			return 0, io.EOF
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+// reopenIfRotated switches to a new file at path, or rewinds a truncated
+// one. It is only called once the open file was read to its end, and
+// reports whether there is something to read again.
+func (r *followReader) reopenIfRotated() (bool, error) {
+	pathInfo, err := os.Stat(r.path)
+	if err != nil {
+		// Between rename and re-creation the path may briefly be missing
+		return false, nil
+	}
+	openInfo, err := r.file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	if !os.SameFile(pathInfo, openInfo) {
+		return r.switchFile(openInfo)
+	}
+
+	pos, err := r.file.Seek(0, io.SeekCurrent)
+	if err == nil && openInfo.Size() < pos {
+		_, err = r.file.Seek(0, io.SeekStart)
+		return err == nil, err
+	}
+	return false, nil
+}
+
+// switchFile carries on with the new file at path, once the rotated one,
+// of info, is drained: lines written between the last read and the
+// rotation are read first, as tail -F does.
+func (r *followReader) switchFile(info os.FileInfo) (bool, error) {
+	if pos, err := r.file.Seek(0, io.SeekCurrent); err == nil && pos < info.Size() {
+		return true, nil
+	}
+	file, err := os.Open(r.path)
+	if err != nil {
+		return false, nil
+	}
+	_ = r.file.Close()
+	r.file = file
+	return true, nil
+}
+
+// Close closes the file being followed.
+func (r *followReader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,239 @@
+package cmd
+
+//revive:disable:cognitive-complexity
+//revive:disable:function-length
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile creates a file with the given content or fails the test
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// makeLogDir creates a multilog directory with two rotated files, written
+// in reverse order, and a current file.
+func makeLogDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "@400000005a848eb00000000a.s"), "second\n")
+	writeFile(t, filepath.Join(dir, "@400000005a848ead00000000.s"), "first\n")
+	writeFile(t, filepath.Join(dir, "current"), "third\n")
+	writeFile(t, filepath.Join(dir, "lock"), "")
+	writeFile(t, filepath.Join(dir, "state"), "")
+	return dir
+}
+
+func TestIsArchivedLog(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"@400000005a848ead00000000.s", true},
+		{"@400000005A848EAD00000000.u", true},
+		{"@400000005a848ead00000000.x", false},
+		{"@400000005a848ead0000000.s", false},
+		{"@400000005a848ead0000000g.s", false},
+		{"current", false},
+		{"400000005a848ead000000000.s", false},
+	}
+
+	for _, tt := range tests {
+		if got := isArchivedLog(tt.name); got != tt.want {
+			t.Errorf("isArchivedLog(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLogDirFiles(t *testing.T) {
+	dir := makeLogDir(t)
+
+	files, err := logDirFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"@400000005a848ead00000000.s", "@400000005a848eb00000000a.s", "current"}
+	if len(files) != len(want) {
+		t.Fatalf("logDirFiles() = %v, want %v", files, want)
+	}
+	for i, f := range files {
+		if filepath.Base(f) != want[i] {
+			t.Errorf("file %d = %s, want %s", i, filepath.Base(f), want[i])
+		}
+	}
+}
+
+func TestExpandInputs(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "b.log"), "")
+	writeFile(t, filepath.Join(dir, "a.log"), "")
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		wantErr bool
+	}{
+		{"no arguments", nil, []string{"-"}, false},
+		{"stdin", []string{"-"}, []string{"-"}, false},
+		{"glob sorted", []string{filepath.Join(dir, "*.log")},
+			[]string{filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log")}, false},
+		{"plain name kept", []string{"missing"}, []string{"missing"}, false},
+		{"glob without match", []string{filepath.Join(dir, "*.txt")}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandInputs(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandInputs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("expandInputs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessFilesLogDir(t *testing.T) {
+	dir := makeLogDir(t)
+	plain := filepath.Join(t.TempDir(), "plain")
+	// No trailing newline: the last line must not be lost
+	writeFile(t, plain, "@400000005A848EAD last")
+
+	files, err := resolveInputs([]string{dir, plain}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	c := &localConverter{format: defaultFormatter, output: bufio.NewWriter(&buf)}
+	if err := c.processFiles(files); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "first\nsecond\nthird\n2018-02-14 19:31:10 +0000 UTC last"
+	if buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestResolveInputFollowDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "@400000005a848ead00000000.s"), "")
+
+	files, err := resolveInput(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := files[len(files)-1]; last != filepath.Join(dir, "current") {
+		t.Errorf("followed file = %s, want current", last)
+	}
+}
+
+func TestFollowReaderDrainsRotated(t *testing.T) {
+	dir := t.TempDir()
+	current := filepath.Join(dir, "current")
+	writeFile(t, current, "one\n")
+	fr, err := newFollowReader(current, make(chan struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = fr.Close() }()
+	buf := make([]byte, 64)
+	if n, _ := fr.Read(buf); string(buf[:n]) != "one\n" {
+		t.Fatalf("got %q, want %q", buf[:n], "one\n")
+	}
+
+	// A line lands after the end of file was seen, just before rotation
+	f, err := os.OpenFile(current, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("late\n")
+	_ = f.Close()
+	if err := os.Rename(current, filepath.Join(dir, "@400000005a848ead00000000.s")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, current, "new\n")
+
+	if again, err := fr.reopenIfRotated(); !again || err != nil {
+		t.Fatalf("reopenIfRotated() = %v, %v", again, err)
+	}
+	for _, want := range []string{"late\n", "new\n"} {
+		if n, err := fr.Read(buf); string(buf[:n]) != want || err != nil {
+			t.Errorf("got %q, %v, want %q", buf[:n], err, want)
+		}
+	}
+}
+
+// readLineTimeout reads one line from r or fails after a timeout
+func readLineTimeout(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	lines := make(chan string, 1)
+	go func() {
+		line, _ := r.ReadString('\n')
+		lines <- line
+	}()
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for a followed line")
+		return ""
+	}
+}
+
+func TestFollowReaderRotation(t *testing.T) {
+	dir := t.TempDir()
+	current := filepath.Join(dir, "current")
+	writeFile(t, current, "one\n")
+
+	stop := make(chan struct{})
+	fr, err := newFollowReader(current, stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr.interval = 10 * time.Millisecond
+	defer func() { _ = fr.Close() }()
+	r := bufio.NewReader(fr)
+
+	if line := readLineTimeout(t, r); line != "one\n" {
+		t.Errorf("got %q, want %q", line, "one\n")
+	}
+
+	// Append to the file being followed
+	f, err := os.OpenFile(current, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("two\n")
+	_ = f.Close()
+	if line := readLineTimeout(t, r); line != "two\n" {
+		t.Errorf("got %q, want %q", line, "two\n")
+	}
+
+	// Rotate like multilog: rename current away and start a new one
+	if err := os.Rename(current, filepath.Join(dir, "@400000005a848ead00000000.s")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, current, "three\n")
+	if line := readLineTimeout(t, r); line != "three\n" {
+		t.Errorf("got %q, want %q", line, "three\n")
+	}
+
+	close(stop)
+	if _, err := r.ReadString('\n'); err != io.EOF {
+		t.Errorf("expected EOF after stop, got %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"io"
+	"path/filepath"
+
+	"github.com/karasz/glibtai"
+)
+
+// mergeEntry is a labelled line together with the unlabelled lines that
+// continue it.
+type mergeEntry struct {
+	label glibtai.TAIN
+	text  []byte
+	src   int
+}
+
+// mergeSource reads the entries of one input, which may span several
+// files, like the rotated files of a multilog directory.
+type mergeSource struct {
+	name    string
+	files   []string
+	in      *bufio.Reader
+	closer  io.Closer
+	pending []byte
+}
+
+// newMergeSource returns a source reading the files of arg.
+func newMergeSource(arg string) (*mergeSource, error) {
+	files, err := resolveInput(arg, false)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(filepath.Clean(arg))
+	if arg == "-" {
+		name = "stdin"
+	}
+	return &mergeSource{name: name, files: files}, nil
+}
+
+// readLine returns the next line of the source, moving on to its next
+// file at end of file. Every line returned ends with a newline.
+func (s *mergeSource) readLine() ([]byte, error) {
+	for {
+		if s.in == nil {
+			if err := s.openNext(); err != nil {
+				return nil, err
+			}
+		}
+		line, err := s.in.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if err == io.EOF {
+			s.close()
+		}
+		if len(line) > 0 {
+			return terminateLine(line), nil
+		}
+	}
+}
+
+// terminateLine adds the newline a final line may lack.
+func terminateLine(line []byte) []byte {
+	if line[len(line)-1] != '\n' {
+		line = append(line, '\n')
+	}
+	return line
+}
+
+// openNext opens the next file of the source.
+func (s *mergeSource) openNext() error {
+	if len(s.files) == 0 {
+		return io.EOF
+	}
+	in, err := openInput(s.files[0], false, nil)
+	if err != nil {
+		return err
+	}
+	s.files = s.files[1:]
+	s.in, s.closer = bufio.NewReader(in), in
+	return nil
+}
+
+// close closes the file being read.
+func (s *mergeSource) close() {
+	if s.closer != nil {
+		_ = s.closer.Close()
+	}
+	s.in, s.closer = nil, nil
+}
+
+// nextEntry reads the next entry. Lines before the first label of the
+// source form an entry of their own that sorts before everything else.
+func (s *mergeSource) nextEntry() (*mergeEntry, error) {
+	line := s.pending
+	s.pending = nil
+	if line == nil {
+		var err error
+		if line, err = s.readLine(); err != nil {
+			return nil, err
+		}
+	}
+
+	e := &mergeEntry{text: line}
+	e.label, _ = lineLabel(line)
+	for {
+		next, err := s.readLine()
+		if err == io.EOF {
+			return e, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := lineLabel(next); ok {
+			s.pending = next
+			return e, nil
+		}
+		e.text = append(e.text, next...)
+	}
+}
+
+// entryHeap orders entries by label, then by source so that equal labels
+// keep the order of the arguments.
+type entryHeap []*mergeEntry
+
+func (h entryHeap) Len() int { return len(h) }
+
+func (h entryHeap) Less(i, j int) bool {
+	if c := tainCompare(h[i].label, h[j].label); c != 0 {
+		return c < 0
+	}
+	return h[i].src < h[j].src
+}
+
+func (h entryHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *entryHeap) Push(x any) { *h = append(*h, x.(*mergeEntry)) }
+
+func (h *entryHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// pushNext reads the next entry of source i onto the heap.
+func pushNext(h *entryHeap, sources []*mergeSource, i int) error {
+	e, err := sources[i].nextEntry()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	e.src = i
+	heap.Push(h, e)
+	return nil
+}
+
+// writeEntry converts and writes the lines of an entry, each prefixed with
+// the name of its source when tag is set. Structured records carry the
+// name in their source field instead.
+func (c *localConverter) writeEntry(e *mergeEntry, name string, tag bool) error {
+	c.source = name
+	text := e.text
+	for len(text) > 0 {
+		end := bytes.IndexByte(text, '\n') + 1
+		if tag && c.record == recordText {
+			_, _ = c.output.WriteString(name + ": ")
+		}
+		if err := c.writeLine(text[:end]); err != nil {
+			return err
+		}
+		text = text[end:]
+	}
+	return nil
+}
+
+// mergeInputs merges the entries of every argument into one chronological
+// stream. Each argument is expected to be in order already, as logs are.
+func (c *localConverter) mergeInputs(args []string, tag bool) error {
+	sources := make([]*mergeSource, len(args))
+	for i, arg := range args {
+		s, err := newMergeSource(arg)
+		if err != nil {
+			return err
+		}
+		sources[i] = s
+	}
+	defer closeSources(sources)
+
+	h := &entryHeap{}
+	for i := range sources {
+		if err := pushNext(h, sources, i); err != nil {
+			return err
+		}
+	}
+	for h.Len() > 0 {
+		e := heap.Pop(h).(*mergeEntry)
+		if err := c.writeEntry(e, sources[e.src].name, tag); err != nil {
+			return err
+		}
+		if err := pushNext(h, sources, e.src); err != nil {
+			return err
+		}
+	}
+	return c.output.Flush()
+}
+
+// closeSources closes the files still open at the end of a merge.
+func closeSources(sources []*mergeSource) {
+	for _, s := range sources {
+		s.close()
+	}
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeInputs(t *testing.T) {
+	root := t.TempDir()
+	web := filepath.Join(root, "web")
+	db := filepath.Join(root, "db")
+	for _, dir := range []string{web, db} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(web, "@400000005a848eb000000000.s"),
+		"@400000005a848ead00000000 web one\n@400000005a848eaf00000000 web two\n  trace line\n")
+	writeFile(t, filepath.Join(web, "current"), "@400000005a848eb100000000 web three")
+	writeFile(t, filepath.Join(db, "current"),
+		"db banner\n@400000005a848eae00000000 db one\n@400000005a848eaf00000000 db two\n")
+
+	tests := []struct {
+		name string
+		tag  bool
+		want string
+	}{
+		{"untagged", false, "db banner\n" +
+			"@400000005a848ead00000000 web one\n" +
+			"@400000005a848eae00000000 db one\n" +
+			"@400000005a848eaf00000000 web two\n" +
+			"  trace line\n" +
+			"@400000005a848eaf00000000 db two\n" +
+			"@400000005a848eb100000000 web three\n"},
+		{"tagged", true, "db: db banner\n" +
+			"web: @400000005a848ead00000000 web one\n" +
+			"db: @400000005a848eae00000000 db one\n" +
+			"web: @400000005a848eaf00000000 web two\n" +
+			"web:   trace line\n" +
+			"db: @400000005a848eaf00000000 db two\n" +
+			"web: @400000005a848eb100000000 web three\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			c := &localConverter{format: defaultFormatter, output: bufio.NewWriter(&buf)}
+			if err := c.mergeInputs([]string{web, db}, tt.tag); err != nil {
+				t.Fatal(err)
+			}
+			// The merged lines are converted like any other
+			want := processline(tt.want)
+			if buf.String() != want {
+				t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+			}
+		})
+	}
+}
+
+func TestMergeInputsMissing(t *testing.T) {
+	c := &localConverter{format: defaultFormatter, output: bufio.NewWriter(&bytes.Buffer{})}
+	if err := c.mergeInputs([]string{filepath.Join(t.TempDir(), "nope")}, false); err == nil {
+		t.Error("expected an error for a missing input")
+	}
+}
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// recordFormat is how gtailocal writes each line.
+type recordFormat int
+
+// Record formats accepted by gtailocal -o.
+const (
+	// recordText replaces the labels within the line
+	recordText recordFormat = iota
+	// recordJSON writes a JSON object per line
+	recordJSON
+	// recordLogfmt writes key=value pairs per line
+	recordLogfmt
+)
+
+// parseRecordFormat parses the -o value.
+func parseRecordFormat(s string) (recordFormat, error) {
+	switch s {
+	case "text":
+		return recordText, nil
+	case "json":
+		return recordJSON, nil
+	case "logfmt":
+		return recordLogfmt, nil
+	default:
+		return recordText, fmt.Errorf("unknown output %q, want text, json or logfmt", s)
+	}
+}
+
+// jsonTimeFields are the fields of JSON log lines whose labels -j rewrites.
+var jsonTimeFields = map[string]bool{"time": true, "ts": true}
+
+// logRecord is a log line split into its leading label and message.
+type logRecord struct {
+	label   []byte
+	message []byte
+	source  string
+}
+
+// splitRecord splits line into the label it starts with, if any, and the
+// message after it, without the line end.
+func splitRecord(line []byte, source string) logRecord {
+	line = bytes.TrimRight(line, "\r\n")
+	r := logRecord{message: line, source: source}
+	if len(line) > 0 && line[0] == '@' {
+		if n := labelDigits(line, 0); n > 0 {
+			r.label = line[:1+n]
+			r.message = bytes.TrimPrefix(line[1+n:], []byte(" "))
+		}
+	}
+	return r
+}
+
+// appendRecordTime appends the time of the record label as printed by f.
+func (lc *labelCache) appendRecordTime(dst []byte, r logRecord, f *timeFormatter) []byte {
+	sec, nano := parseLabelDigits(r.label[1:])
+	return f.appendTime(dst, lc.labelTime(sec, nano))
+}
+
+// appendJSONRecord appends r as a JSON object and a newline.
+func (lc *labelCache) appendJSONRecord(dst []byte, r logRecord, f *timeFormatter) []byte {
+	dst = append(dst, '{')
+	if r.label != nil {
+		dst = append(dst, `"tai64n":`...)
+		dst = appendJSONString(dst, r.label)
+		dst = append(dst, `,"time":`...)
+		dst = appendJSONString(dst, lc.appendRecordTime(nil, r, f))
+		dst = append(dst, ',')
+	}
+	dst = append(dst, `"message":`...)
+	dst = appendJSONString(dst, r.message)
+	if r.source != "" {
+		dst = append(dst, `,"source":`...)
+		dst = appendJSONString(dst, []byte(r.source))
+	}
+	return append(dst, "}\n"...)
+}
+
+// appendLogfmtRecord appends r as logfmt pairs and a newline.
+func (lc *labelCache) appendLogfmtRecord(dst []byte, r logRecord, f *timeFormatter) []byte {
+	if r.label != nil {
+		dst = append(dst, "tai64n="...)
+		dst = append(dst, r.label...)
+		dst = append(dst, " time="...)
+		dst = appendLogfmtValue(dst, lc.appendRecordTime(nil, r, f))
+		dst = append(dst, ' ')
+	}
+	dst = append(dst, "message="...)
+	dst = appendLogfmtValue(dst, r.message)
+	if r.source != "" {
+		dst = append(dst, " source="...)
+		dst = appendLogfmtValue(dst, []byte(r.source))
+	}
+	return append(dst, '\n')
+}
+
+// appendLogfmtValue appends v, quoted when it is empty or holds spaces,
+// quotes, equal signs or control characters.
+func appendLogfmtValue(dst, v []byte) []byte {
+	if len(v) > 0 && bytes.IndexFunc(v, needsLogfmtQuote) == -1 {
+		return append(dst, v...)
+	}
+	return strconv.AppendQuote(dst, string(v))
+}
+
+// needsLogfmtQuote reports whether r forces a logfmt value into quotes.
+func needsLogfmtQuote(r rune) bool {
+	return r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError
+}
+
+// appendJSONString appends s as a JSON string. Invalid UTF-8 becomes
+// U+FFFD as encoding/json does.
+func appendJSONString(dst, s []byte) []byte {
+	dst = append(dst, '"')
+	for len(s) > 0 {
+		r, size := utf8.DecodeRune(s)
+		dst = appendJSONRune(dst, r, size)
+		s = s[size:]
+	}
+	return append(dst, '"')
+}
+
+// appendJSONRune appends one character of a JSON string.
+func appendJSONRune(dst []byte, r rune, size int) []byte {
+	switch {
+	case r == '"' || r == '\\':
+		return append(dst, '\\', byte(r))
+	case r == '\n':
+		return append(dst, `\n`...)
+	case r == '\r':
+		return append(dst, `\r`...)
+	case r == '\t':
+		return append(dst, `\t`...)
+	case r < ' ':
+		return fmt.Appendf(dst, `\u%04x`, r)
+	case r == utf8.RuneError && size == 1:
+		return append(dst, `\ufffd`...)
+	default:
+		return utf8.AppendRune(dst, r)
+	}
+}
+
+// rewriteJSONTime rewrites the time and ts fields of a JSON object line
+// that hold a TAI or TAIN label, keeping the order of the fields. ok is
+// false when line is not such an object, or has no label to rewrite.
+func (lc *labelCache) rewriteJSONTime(dst, line []byte, f *timeFormatter) ([]byte, bool) {
+	obj := bytes.TrimSpace(line)
+	if len(obj) == 0 || obj[0] != '{' {
+		return dst, false
+	}
+	dec := json.NewDecoder(bytes.NewReader(obj))
+	if _, err := dec.Token(); err != nil {
+		return dst, false
+	}
+
+	out, changed, err := lc.appendJSONFields(append(dst, '{'), dec, f)
+	if err != nil || !changed {
+		return dst, false
+	}
+	// The closing brace must end the line
+	if _, err := dec.Token(); err != nil || dec.InputOffset() != int64(len(obj)) {
+		return dst, false
+	}
+	out = append(out, '}')
+	if bytes.HasSuffix(line, []byte("\n")) {
+		out = append(out, '\n')
+	}
+	return out, true
+}
+
+// appendJSONFields appends the fields of the object being decoded, up to
+// its closing brace, rewriting the time fields.
+func (lc *labelCache) appendJSONFields(dst []byte, dec *json.Decoder, f *timeFormatter) ([]byte, bool, error) {
+	changed := false
+	for first := true; dec.More(); first = false {
+		key, value, err := nextJSONField(dec)
+		if err != nil {
+			return dst, false, err
+		}
+		if !first {
+			dst = append(dst, ',')
+		}
+		dst = appendJSONString(dst, []byte(key))
+		dst = append(dst, ':')
+		var rewritten bool
+		dst, rewritten = lc.appendJSONValue(dst, key, value, f)
+		changed = changed || rewritten
+	}
+	return dst, changed, nil
+}
+
+// nextJSONField reads the next key and raw value of an object.
+func nextJSONField(dec *json.Decoder) (string, json.RawMessage, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return "", nil, err
+	}
+	key, _ := tok.(string)
+	var value json.RawMessage
+	err = dec.Decode(&value)
+	return key, value, err
+}
+
+// appendJSONValue appends value, replaced by its time when key is a time
+// field holding a label.
+func (lc *labelCache) appendJSONValue(dst []byte, key string, value json.RawMessage, f *timeFormatter) ([]byte, bool) {
+	var s string
+	if !jsonTimeFields[key] || json.Unmarshal(value, &s) != nil {
+		return append(dst, value...), false
+	}
+	label := []byte(s)
+	if len(label) == 0 || label[0] != '@' || labelDigits(label, 0) != len(label)-1 {
+		return append(dst, value...), false
+	}
+	converted, used := lc.convertLabelAt(nil, label, 0, f)
+	if used == 0 {
+		return append(dst, value...), false
+	}
+	return appendJSONString(dst, converted), true
+}
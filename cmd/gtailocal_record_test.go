@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// rfc3339NanoFormatter returns the formatter structured output defaults to
+func rfc3339NanoFormatter(t *testing.T) *timeFormatter {
+	t.Helper()
+	f, err := newTimeFormatter(formatRFC3339Nano, -1, "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestRecordOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		record recordFormat
+		source string
+		in     string
+		want   string
+	}{
+		{"json", recordJSON, "",
+			"@40000000433225833b6e1a8c started\n",
+			`{"tai64n":"@40000000433225833b6e1a8c","time":"2005-09-22T03:30:33.9970715Z","message":"started"}` + "\n"},
+		{"json with source", recordJSON, "/var/log/web/current",
+			"@400000005A848EAD \"quoted\"\ttab\n",
+			`{"tai64n":"@400000005A848EAD","time":"2018-02-14T19:31:10Z","message":"\"quoted\"\ttab",` +
+				`"source":"/var/log/web/current"}` + "\n"},
+		{"json without label", recordJSON, "", "  continued\n", `{"message":"  continued"}` + "\n"},
+		{"json invalid utf-8", recordJSON, "", "bad \xff byte", `{"message":"bad \ufffd byte"}` + "\n"},
+		{"logfmt", recordLogfmt, "",
+			"@40000000433225833b6e1a8c started\n",
+			"tai64n=@40000000433225833b6e1a8c time=2005-09-22T03:30:33.9970715Z message=started\n"},
+		{"logfmt quoting", recordLogfmt, "web",
+			"@400000005A848EAD a=b c\n",
+			"tai64n=@400000005A848EAD time=2018-02-14T19:31:10Z message=\"a=b c\" source=web\n"},
+		{"logfmt empty message", recordLogfmt, "", "\n", "message=\"\"\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &localConverter{format: rfc3339NanoFormatter(t), record: tt.record, source: tt.source}
+			got := string(c.appendRecord(nil, []byte(tt.in)))
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+			if tt.record == recordJSON && !json.Valid([]byte(got)) {
+				t.Errorf("invalid JSON %s", got)
+			}
+		})
+	}
+}
+
+func TestRewriteJSONTime(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"time field",
+			`{"level":"info","time":"@40000000433225833b6e1a8c","msg":"up"}` + "\n",
+			`{"level":"info","time":"2005-09-22T03:30:33.9970715Z","msg":"up"}` + "\n"},
+		{"ts field and spacing",
+			`{ "ts": "@400000005A848EAD", "n": 1.50, "tags": ["a", "b"] }`,
+			`{"ts":"2018-02-14T19:31:10Z","n":1.50,"tags":["a", "b"]}`},
+		{"no label left alone", `{"time": "2025-01-01T00:00:00Z"}`, `{"time": "2025-01-01T00:00:00Z"}`},
+		{"label in another field", `{"at":"@400000005A848EAD"}`, `{"at":"2018-02-14T19:31:10Z"}`},
+		{"not JSON", "@400000005A848EAD plain\n", "2018-02-14T19:31:10Z plain\n"},
+		{"trailing text", `{"time":"@400000005A848EAD"} tail`, `{"time":"2018-02-14T19:31:10Z"} tail`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &localConverter{format: rfc3339NanoFormatter(t), jsonFields: true}
+			if got := string(c.appendRecord(nil, []byte(tt.in))); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordOutputSource(t *testing.T) {
+	dir := makeLogDir(t)
+	files, err := resolveInputs([]string{dir}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	c := &localConverter{format: rfc3339NanoFormatter(t), output: bufio.NewWriter(&buf), record: recordJSON}
+	if err := c.processFiles(files); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 records, got %q", buf.String())
+	}
+	var rec struct{ Message, Source string }
+	if err := json.Unmarshal([]byte(lines[2]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Message != "third" || !strings.HasSuffix(rec.Source, "current") {
+		t.Errorf("last record = %+v", rec)
+	}
+}
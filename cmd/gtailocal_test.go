@@ -1,7 +1,16 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/karasz/glibtai"
 )
 
 func TestGTAILocal(t *testing.T) {
@@ -22,3 +31,134 @@ func TestGTAILocal(t *testing.T) {
 		}
 	}
 }
+
+func TestProcessLineAllLabels(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"two labels",
+			"@40000000433225833b6e1a8c fwd: @400000005A848EAD msg\n",
+			"2005-09-22 03:30:33.9970715 +0000 UTC fwd: 2018-02-14 19:31:10 +0000 UTC msg\n"},
+		{"address before label",
+			"mail from root@host at @400000005A848EAD",
+			"mail from root@host at 2018-02-14 19:31:10 +0000 UTC"},
+		{"label in a word", "x@400000005A848EAD y", "x@400000005A848EAD y"},
+		{"label followed by a word", "@400000005A848EADzz", "@400000005A848EADzz"},
+		{"too many digits", "@400000005A848EAD0", "@400000005A848EAD0"},
+		{"label between brackets", "[@400000005A848EAD]", "[2018-02-14 19:31:10 +0000 UTC]"},
+		{"rotated log name", "@40000000433225833b6e1a8c.s", "2005-09-22 03:30:33.9970715 +0000 UTC.s"},
+		{"adjacent labels", "@400000005A848EAD@400000005A848EAD", "2018-02-14 19:31:10 +0000 UTC@400000005A848EAD"},
+		{"stray signs", "@@ @ a@b", "@@ @ a@b"},
+	}
+
+	for _, tt := range tests {
+		if got := processline(tt.in); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProcessInputStreamLongLine(t *testing.T) {
+	long := strings.Repeat("x", 100) + " @400000005A848EAD " + strings.Repeat("y", 100)
+	in := bufio.NewReaderSize(strings.NewReader(long+"\n"+long), 16)
+	var buf bytes.Buffer
+	c := &localConverter{format: defaultFormatter, output: bufio.NewWriter(&buf)}
+	if err := c.processInputStream(in); err != nil {
+		t.Fatal(err)
+	}
+	want := processline(long) + "\n" + processline(long)
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe to read while another goroutine writes
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestProcessInputStreamFlushesWhenIdle(t *testing.T) {
+	r, w := io.Pipe()
+	out := &lockedBuffer{}
+	c := &localConverter{format: defaultFormatter, output: bufio.NewWriter(out)}
+	done := make(chan error, 1)
+	go func() { done <- c.processInputStream(bufio.NewReader(r)) }()
+
+	// The line must come out while the input stays open
+	_, _ = w.Write([]byte("@400000005A848EAD up\n"))
+	want := "2018-02-14 19:31:10 +0000 UTC up\n"
+	deadline := time.Now().Add(2 * time.Second)
+	for out.String() != want && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := out.String(); got != want {
+		t.Errorf("got %q before end of input, want %q", got, want)
+	}
+
+	_ = w.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// syntheticLog returns lines like a busy multilog file, with a label every
+// millisecond.
+func syntheticLog(lines int) []byte {
+	var buf bytes.Buffer
+	start := glibtai.TAINfromTime(time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC))
+	for i := 0; i < lines; i++ {
+		label := appendTAINStamp(nil, glibtai.TAINAdd(start, time.Duration(i)*time.Millisecond))
+		fmt.Fprintf(&buf, "%s service[%d]: request from user@example.org handled in %d us\n", label, i%64, i%977)
+	}
+	return buf.Bytes()
+}
+
+// benchmarkStream converts a synthetic log, reporting throughput in bytes
+func benchmarkStream(b *testing.B, format string, lineBuffered bool) {
+	data := syntheticLog(100000)
+	f, err := newTimeFormatter(format, -1, "UTC")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := &localConverter{format: f, output: bufio.NewWriter(io.Discard), lineBuffered: lineBuffered}
+		if err := c.processInputStream(bufio.NewReader(bytes.NewReader(data))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStreamDefault(b *testing.B)      { benchmarkStream(b, formatDefault, false) }
+func BenchmarkStreamRFC3339Nano(b *testing.B)  { benchmarkStream(b, formatRFC3339Nano, false) }
+func BenchmarkStreamUnix(b *testing.B)         { benchmarkStream(b, formatUnix, false) }
+func BenchmarkStreamLineBuffered(b *testing.B) { benchmarkStream(b, formatDefault, true) }
+
+func BenchmarkStreamNoLabels(b *testing.B) {
+	data := bytes.Repeat([]byte("plain line without any label, just text to copy through\n"), 100000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c := &localConverter{format: defaultFormatter, output: bufio.NewWriter(io.Discard)}
+		if err := c.processInputStream(bufio.NewReader(bytes.NewReader(data))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
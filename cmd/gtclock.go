@@ -1,11 +1,14 @@
 package cmd
 
 import (
+	"bytes"
 	"errors"
+	"flag"
 	"fmt"
 	"math"
 	"math/rand"
 	"net"
+	"os"
 	"time"
 
 	"github.com/karasz/glibtai"
@@ -13,6 +16,13 @@ import (
 
 const tainPacket = 28
 
+const (
+	extendedSamples  = 8
+	exchangeTimeout  = 2 * time.Second
+	nonceLength      = 8
+	legacyRoundtrips = 10
+)
+
 const (
 	letterBytes   = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 	letterIdxBits = 6
@@ -51,18 +61,26 @@ func makeQuery() (query []byte, t0 glibtai.TAIN) {
 	return query, t0
 }
 
+// makeExtendedQuery builds a request asking for the extended response.
+func makeExtendedQuery() (query []byte, t0 glibtai.TAIN) {
+	q, t0 := makeQuery()
+	query = make([]byte, tainExtPacket)
+	copy(query, q)
+	return query, t0
+}
+
 func tainExchange(m []byte, c *net.UDPConn) (answer []byte, t1 glibtai.TAIN, e error) {
 	answer = make([]byte, tainPacket)
 
 	_, err := c.Write(m)
 	if err != nil {
-		_, _ = fmt.Println(err)
+		_, _ = fmt.Fprintln(os.Stderr, err)
 		return answer, glibtai.TAIN{}, err
 	}
 	t1 = glibtai.TAINNow()
 	_, err = c.Read(answer)
 	if err != nil {
-		_, _ = fmt.Println(err)
+		_, _ = fmt.Fprintln(os.Stderr, err)
 		return answer, glibtai.TAIN{}, err
 	}
 	return answer, t1, nil
@@ -78,6 +96,71 @@ func dur(d time.Duration) (int64, int32) {
 	return int64(sec), int32(nano)
 }
 
+// tainSample is one extended exchange with a server.
+type tainSample struct {
+	resp   extResponse
+	offset time.Duration
+	delay  time.Duration
+}
+
+// readReply reads until a reply carrying the nonce of query arrives,
+// discarding late answers to earlier queries.
+func readReply(c *net.UDPConn, query []byte) ([]byte, glibtai.TAIN, error) {
+	answer := make([]byte, tainExtPacket)
+	nonce := query[extNonceOffset : extNonceOffset+nonceLength]
+	for {
+		n, err := c.Read(answer)
+		t4 := glibtai.TAINNow()
+		if err != nil {
+			return nil, glibtai.TAIN{}, err
+		}
+		if n >= tainPacket && answer[0] == 's' && bytes.Equal(answer[extNonceOffset:extNonceOffset+nonceLength], nonce) {
+			return answer[:n], t4, nil
+		}
+	}
+}
+
+// extExchange performs one extended exchange. extended is false when the
+// server answered with a plain response.
+func extExchange(c *net.UDPConn) (sample tainSample, extended bool, err error) {
+	query, _ := makeExtendedQuery()
+	_ = c.SetReadDeadline(time.Now().Add(exchangeTimeout))
+	t1 := glibtai.TAINNow()
+	if _, err = c.Write(query); err != nil {
+		return tainSample{}, false, err
+	}
+	answer, t4, err := readReply(c, query)
+	if err != nil {
+		return tainSample{}, false, err
+	}
+	r, ok := unpackExtended(answer)
+	if !ok {
+		return tainSample{}, false, nil
+	}
+	offset, delay := exchangeParams(t1, r, t4)
+	return tainSample{resp: r, offset: offset, delay: delay}, true, nil
+}
+
+// measureExtended runs several extended exchanges and keeps the one with the
+// lowest delay, which suffers least from queueing. extended is false when
+// the server does not support the extension.
+func measureExtended(c *net.UDPConn) (best tainSample, extended bool, err error) {
+	for i := 0; i < extendedSamples; i++ {
+		sample, ok, err := extExchange(c)
+		if err != nil {
+			return tainSample{}, false, err
+		}
+		if !ok {
+			return tainSample{}, false, nil
+		}
+		if !extended || sample.delay < best.delay {
+			best = sample
+		}
+		extended = true
+	}
+	return best, true, nil
+}
+
 // parseGTClockArgs parses command line arguments for GTClock client.
 func parseGTClockArgs(args []string) (servIP net.IP, saveClock bool, err error) {
 	switch len(args) {
@@ -102,22 +185,20 @@ func parseGTClockArgs(args []string) (servIP net.IP, saveClock bool, err error)
 func measureServerTime(conn *net.UDPConn) (time.Time, error) {
 	var totalroundtrip time.Duration
 
-	for i := 0; i < 10; i++ {
+	for i := 0; i < legacyRoundtrips; i++ {
 		q, t0 := makeQuery()
 
-		_, t1, e := tainExchange(q, conn)
-		if e != nil {
-			_, _ = fmt.Println(e)
+		_, t1, err := tainExchange(q, conn)
+		if err != nil {
+			return time.Time{}, err
 		}
 
 		z, err := glibtai.TAINSub(t1, t0)
-		if err == nil {
-			totalroundtrip += z
-		} else {
-			_, _ = fmt.Println(err)
+		if err != nil {
+			return time.Time{}, err
 		}
+		totalroundtrip += z
 	}
-	_, _ = fmt.Println("before: ", glibtai.TAINTime(glibtai.TAINNow()))
 	qf, _ := makeQuery()
 	resp, _, e := tainExchange(qf, conn)
 	if e != nil {
@@ -129,39 +210,46 @@ func measureServerTime(conn *net.UDPConn) (time.Time, error) {
 	return serverSays, nil
 }
 
-// GTClockCRun implements the gtclockc client functionality for TAIN time synchronization.
-func GTClockCRun(args []string) int {
-	servIP, saveClock, err := parseGTClockArgs(args)
+// measureTAI measures a TAICLOCK server, with the extended protocol when
+// the server supports it.
+func measureTAI(s serverConfig) (clientSample, error) {
+	serverAddr, err := net.ResolveUDPAddr("udp", s.address())
 	if err != nil {
-		_, _ = fmt.Println(err)
-		return 111
+		return clientSample{}, err
 	}
-	serverAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(servIP.String(), "4014"))
+	conn, err := net.DialUDP("udp", nil, serverAddr)
 	if err != nil {
-		_, _ = fmt.Println(err)
-		return 111
+		return clientSample{}, err
 	}
-	conn, err := net.DialUDP("udp", nil, serverAddr)
+	defer func() { _ = conn.Close() }()
 
+	sample, extended, err := measureExtended(conn)
 	if err != nil {
-		_, _ = fmt.Println(err)
-		return 111
+		return clientSample{}, err
 	}
-	defer func() { _ = conn.Close() }()
+	if !extended {
+		return measureLegacy(conn)
+	}
+	st := sample.resp.status
+	return clientSample{
+		offset: sample.offset, delay: sample.delay, dispersion: st.estError,
+		stratum: int(st.stratum), leap: int(st.leap), unsynced: st.unsynced,
+	}, nil
+}
 
+// measureLegacy measures a server without the extended protocol.
+func measureLegacy(conn *net.UDPConn) (clientSample, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(exchangeTimeout * (legacyRoundtrips + 1)))
 	serverSays, err := measureServerTime(conn)
 	if err != nil {
-		_, _ = fmt.Println(err)
-		return 111
+		return clientSample{}, err
 	}
+	return clientSample{offset: time.Until(serverSays)}, nil
+}
 
-	if saveClock {
-		err = setSystemClockTime(serverSays)
-		if err != nil {
-			_, _ = fmt.Println(err)
-			return 111
-		}
-	}
-	_, _ = fmt.Println("after: ", serverSays)
-	return 0
+// GTClockCRun implements the gtclockc client functionality for TAIN time synchronization.
+func GTClockCRun(args []string) int {
+	fs := flag.NewFlagSet("gtclockc", flag.ContinueOnError)
+	opts := clientFlags(fs)
+	return runClient(fs, opts, clientProtocol{protoTAI, parseGTClockArgs, measureTAI}, args)
 }
@@ -2,10 +2,12 @@ package cmd
 
 //revive:disable:cognitive-complexity
 import (
+	"net"
 	"testing"
 	"time"
 
 	"github.com/karasz/glibtai"
+	"github.com/karasz/gtclock/gtudpd"
 )
 
 func TestRandomString(t *testing.T) {
@@ -219,3 +221,61 @@ func BenchmarkDur(b *testing.B) {
 		dur(d)
 	}
 }
+
+// startTAINServer runs a TAICLOCK server with the given handler on loopback
+// and returns a client connection to it.
+func startTAINServer(t *testing.T, handler gtudpd.RequestHandler) *net.UDPConn {
+	t.Helper()
+	config := &gtudpd.Config{DefaultPort: "127.0.0.1:0"}
+	server, err := gtudpd.NewServer(config, handler, validateTAINRequest(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Start()
+	t.Cleanup(func() { _ = server.Stop() })
+
+	conn, err := net.DialUDP("udp", nil, server.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// legacyResponse answers like a server predating the extended protocol.
+func legacyResponse(conn gtudpd.ResponseWriter, _ int, remoteaddr *net.UDPAddr, buf []byte, _ time.Time) {
+	buf[0] = 's'
+	copy(buf[4:16], glibtai.TAINPack(glibtai.TAINNow()))
+	_, _ = conn.WriteToUDP(buf, remoteaddr)
+}
+
+func TestMeasureExtended(t *testing.T) {
+	conn := startTAINServer(t, sendResponse)
+
+	sample, extended, err := measureExtended(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !extended {
+		t.Fatal("expected the server to support the extended protocol")
+	}
+	// Same host, same clock
+	if sample.offset < -10*time.Millisecond || sample.offset > 10*time.Millisecond {
+		t.Errorf("offset = %v, want about 0", sample.offset)
+	}
+	if sample.delay < 0 || sample.delay > time.Second {
+		t.Errorf("delay = %v out of range", sample.delay)
+	}
+}
+
+func TestMeasureExtendedLegacyServer(t *testing.T) {
+	conn := startTAINServer(t, legacyResponse)
+
+	_, extended, err := measureExtended(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extended {
+		t.Error("legacy server reported as extended")
+	}
+}
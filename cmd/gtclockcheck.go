@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// gtclockcheck is a monitoring plugin in the style of Nagios and Icinga: it
+// measures time servers without touching the clock and exits with the
+// state of the worst of them, printing a line of the form
+//
+//	CLOCK WARNING - 192.0.2.1: offset 0.150000000s, stratum 2 | '192.0.2.1_offset'=0.150000000s;0.100000000;1.000000000 '192.0.2.1_stratum'=2
+
+// Monitoring plugin states, which are also the exit codes.
+const (
+	checkOK = iota
+	checkWarning
+	checkCritical
+	checkUnknown
+)
+
+// checkStateNames are the names of the states, by state.
+var checkStateNames = [...]string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// checkOptions holds the gtclockcheck flags.
+type checkOptions struct {
+	warning    time.Duration
+	critical   time.Duration
+	maxStratum int
+	proto      string
+	config     string
+}
+
+// gtclockcheckFlags defines the gtclockcheck flags on fs.
+func gtclockcheckFlags(fs *flag.FlagSet) *checkOptions {
+	o := &checkOptions{}
+	fs.DurationVar(&o.warning, "w", 100*time.Millisecond, "warn for offsets above this")
+	fs.DurationVar(&o.critical, "c", time.Second, "critical for offsets above this")
+	fs.IntVar(&o.maxStratum, "maxstratum", stratumUnsynchronized-1, "warn for strata above this")
+	fs.StringVar(&o.proto, "proto", protoTAI, "protocol of the servers given as arguments: tai or ntp")
+	fs.StringVar(&o.config, "config", clientConfigPath, "client configuration listing the servers to check without arguments")
+	return o
+}
+
+// checkResult is the measurement of a server.
+type checkResult struct {
+	server serverConfig
+	sample clientSample
+	err    error
+}
+
+// errNoCheckServers is returned when there is nothing to check.
+var errNoCheckServers = errors.New("no servers to check")
+
+// servers returns the servers given as arguments, host[:port], or else
+// those of the client configuration. Reference clocks are left out, they
+// are local.
+func (o *checkOptions) servers(args []string) ([]serverConfig, error) {
+	if o.proto != protoTAI && o.proto != protoNTP {
+		return nil, fmt.Errorf("unknown protocol %q, want tai or ntp", o.proto)
+	}
+	var servers []serverConfig
+	for _, arg := range args {
+		s := serverConfig{host: arg, proto: o.proto, weight: 1}
+		if host, port, err := net.SplitHostPort(arg); err == nil {
+			s.host, s.port = host, port
+		}
+		servers = append(servers, s)
+	}
+	if len(args) == 0 {
+		cfg, err := loadClientConfig(o.config)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range cfg.servers {
+			if s.proto != protoRefclock {
+				servers = append(servers, s)
+			}
+		}
+	}
+	if len(servers) == 0 {
+		return nil, errNoCheckServers
+	}
+	return servers, nil
+}
+
+// measureServers measures every server with the function of its protocol.
+func measureServers(servers []serverConfig, measures map[string]measureFunc) []checkResult {
+	results := make([]checkResult, len(servers))
+	for i, s := range servers {
+		results[i] = checkResult{server: s}
+		results[i].sample, results[i].err = measures[s.proto](s)
+	}
+	return results
+}
+
+// state returns the state of a server and describes it.
+func (o *checkOptions) state(r checkResult) (int, string) {
+	s := r.sample
+	switch {
+	case r.err != nil:
+		return checkCritical, fmt.Sprintf("no answer (%v)", r.err)
+	case s.unsynced || s.leap == int(leapUnknown) || s.stratum >= stratumUnsynchronized:
+		return checkCritical, "clock unsynchronized"
+	}
+	desc := fmt.Sprintf("offset %.9fs, stratum %d", s.offset.Seconds(), s.stratum)
+	switch {
+	case s.offset.Abs() > o.critical:
+		return checkCritical, desc
+	case s.offset.Abs() > o.warning:
+		return checkWarning, desc
+	case s.stratum > o.maxStratum:
+		return checkWarning, desc + " above " + fmt.Sprint(o.maxStratum)
+	case s.leap == int(leapInsert) || s.leap == int(leapDelete):
+		return checkWarning, desc + ", leap second announced"
+	}
+	return checkOK, desc
+}
+
+// perfData returns the performance data of a server that answered.
+func (o *checkOptions) perfData(r checkResult) string {
+	if r.err != nil {
+		return ""
+	}
+	host := r.server.host
+	return fmt.Sprintf("'%s_offset'=%.9fs;%.9f;%.9f '%s_stratum'=%d",
+		host, r.sample.offset.Seconds(), o.warning.Seconds(), o.critical.Seconds(),
+		host, r.sample.stratum)
+}
+
+// report returns the worst state of the results and the plugin output.
+func (o *checkOptions) report(results []checkResult) (int, string) {
+	worst := checkOK
+	var descs, perf []string
+	for _, r := range results {
+		state, desc := o.state(r)
+		worst = max(worst, state)
+		descs = append(descs, r.server.host+": "+desc)
+		if p := o.perfData(r); p != "" {
+			perf = append(perf, p)
+		}
+	}
+	line := fmt.Sprintf("CLOCK %s - %s", checkStateNames[worst], strings.Join(descs, ", "))
+	if len(perf) > 0 {
+		line += " | " + strings.Join(perf, " ")
+	}
+	return worst, line
+}
+
+// checkUsage reports a usage error as a plugin does.
+func checkUsage(err error) int {
+	_, _ = fmt.Printf("CLOCK UNKNOWN - %v\n", err)
+	return checkUnknown
+}
+
+// GTClockCheckRun checks the offset of time servers as a monitoring plugin,
+// without adjusting the clock.
+func GTClockCheckRun(args []string) int {
+	fs := flag.NewFlagSet("gtclockcheck", flag.ContinueOnError)
+	opts := gtclockcheckFlags(fs)
+
+	setUsage(fs, "[-w duration] [-c duration] [-maxstratum n] [-proto tai|ntp] [server[:port]...]")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			// The usage is no check, so its state is unknown
+			return checkUnknown
+		}
+		return checkUsage(err)
+	}
+	if opts.warning > opts.critical {
+		return checkUsage(errors.New("the warning threshold is above the critical one"))
+	}
+	servers, err := opts.servers(fs.Args())
+	if err != nil {
+		return checkUsage(err)
+	}
+
+	results := measureServers(servers, map[string]measureFunc{protoTAI: measureTAI, protoNTP: measureNTP})
+	state, line := opts.report(results)
+	_, _ = fmt.Println(line)
+	return state
+}
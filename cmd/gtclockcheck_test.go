@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCheckOptions returns the gtclockcheck options of args.
+func testCheckOptions(t *testing.T, args ...string) *checkOptions {
+	t.Helper()
+	fs := flag.NewFlagSet("gtclockcheck", flag.ContinueOnError)
+	o := gtclockcheckFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestCheckState(t *testing.T) {
+	o := testCheckOptions(t, "-maxstratum", "3")
+	tests := []struct {
+		name   string
+		result checkResult
+		want   int
+		desc   string
+	}{
+		{"in sync", checkResult{sample: clientSample{offset: 5 * time.Millisecond, stratum: 2}}, checkOK, "offset 0.005000000s, stratum 2"},
+		{"negative warning", checkResult{sample: clientSample{offset: -200 * time.Millisecond, stratum: 2}}, checkWarning, "offset -0.200000000s"},
+		{"critical offset", checkResult{sample: clientSample{offset: 2 * time.Second, stratum: 2}}, checkCritical, "offset 2.000000000s"},
+		{"stratum", checkResult{sample: clientSample{stratum: 4}}, checkWarning, "stratum 4 above 3"},
+		{"leap second", checkResult{sample: clientSample{stratum: 1, leap: int(leapInsert)}}, checkWarning, "leap second announced"},
+		{"unsynchronized", checkResult{sample: clientSample{stratum: 2, unsynced: true}}, checkCritical, "clock unsynchronized"},
+		{"leap unknown", checkResult{sample: clientSample{stratum: 2, leap: int(leapUnknown)}}, checkCritical, "clock unsynchronized"},
+		{"stratum 16", checkResult{sample: clientSample{stratum: stratumUnsynchronized}}, checkCritical, "clock unsynchronized"},
+		{"no answer", checkResult{err: errors.New("i/o timeout")}, checkCritical, "no answer (i/o timeout)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, desc := o.state(tt.result)
+			if got != tt.want || !strings.Contains(desc, tt.desc) {
+				t.Errorf("state() = %d, %q, want %d, %q", got, desc, tt.want, tt.desc)
+			}
+		})
+	}
+}
+
+func TestCheckReport(t *testing.T) {
+	o := testCheckOptions(t, "-w", "50ms", "-c", "500ms")
+	results := []checkResult{
+		{server: serverConfig{host: "192.0.2.1"}, sample: clientSample{offset: 100 * time.Millisecond, stratum: 2}},
+		{server: serverConfig{host: "192.0.2.2"}, err: errors.New("refused")},
+	}
+	state, line := o.report(results)
+	want := "CLOCK CRITICAL - 192.0.2.1: offset 0.100000000s, stratum 2, 192.0.2.2: no answer (refused)" +
+		" | '192.0.2.1_offset'=0.100000000s;0.050000000;0.500000000 '192.0.2.1_stratum'=2"
+	if state != checkCritical || line != want {
+		t.Errorf("report() = %d, %q, want %d, %q", state, line, checkCritical, want)
+	}
+}
+
+func TestCheckServers(t *testing.T) {
+	o := testCheckOptions(t, "-proto", "ntp")
+	got, err := o.servers([]string{"192.0.2.1", "192.0.2.2:1123", "[2001:db8::1]:123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []serverConfig{
+		{host: "192.0.2.1", proto: protoNTP, weight: 1},
+		{host: "192.0.2.2", proto: protoNTP, port: "1123", weight: 1},
+		{host: "2001:db8::1", proto: protoNTP, port: "123", weight: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("servers() = %+v, want %+v", got, want)
+	}
+
+	path := filepath.Join(t.TempDir(), "client.conf")
+	writeFile(t, path, "server 192.0.2.1\nrefclock shm:0\nserver 192.0.2.2 proto=ntp\n")
+	o = testCheckOptions(t, "-config", path)
+	got, err = o.servers(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].proto != protoTAI || got[1].proto != protoNTP {
+		t.Errorf("servers() from the configuration = %+v", got)
+	}
+
+	writeFile(t, path, "refclock shm:0\n")
+	if _, err := o.servers(nil); !errors.Is(err, errNoCheckServers) {
+		t.Errorf("servers() of only reference clocks = %v, want %v", err, errNoCheckServers)
+	}
+	o.proto = "gps"
+	if _, err := o.servers([]string{"192.0.2.1"}); err == nil {
+		t.Error("servers() accepted an unknown protocol")
+	}
+}
+
+func TestGTClockCheckRun(t *testing.T) {
+	silence(t)
+	ntpAddr := startNTPServer(t, testNTPServer(unsyncMark, kernelClock{}))
+	taiAddr := startTAINServer(t, sendResponse).RemoteAddr().String()
+
+	// A loopback port nobody listens on is refused at once
+	closed, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.LocalAddr().String()
+	_ = closed.Close()
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"ntp server", []string{"-proto", "ntp", ntpAddr}, checkOK},
+		{"tai server", []string{taiAddr}, checkOK},
+		{"no server", []string{"-proto", "ntp", ntpAddr, closedAddr}, checkCritical},
+		{"help", []string{"-h"}, checkUnknown},
+		{"bad flag", []string{"-x"}, checkUnknown},
+		{"thresholds", []string{"-w", "2s", "-c", "1s", ntpAddr}, checkUnknown},
+		{"bad protocol", []string{"-proto", "gps", ntpAddr}, checkUnknown},
+		{"missing configuration", []string{"-config", filepath.Join(t.TempDir(), "none")}, checkUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GTClockCheckRun(tt.args); got != tt.want {
+				t.Errorf("GTClockCheckRun(%q) = %d, want %d", tt.args, got, tt.want)
+			}
+		})
+	}
+}
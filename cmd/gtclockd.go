@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net"
+	"runtime"
+	"time"
 
 	"github.com/karasz/glibtai"
 	"github.com/karasz/gtclock/gtudpd"
@@ -11,7 +14,13 @@ import (
 
 const defaultPort = ":4014"
 
-var configDir string
+var (
+	configDir   string
+	estError    time.Duration
+	unsyncFlag  string
+	refclocks   []string
+	serverClock *statusMonitor
+)
 
 // TAICLOCK Protocol Specification:
 //
@@ -47,12 +56,35 @@ var configDir string
 //   - TAI is atomic time without leap seconds
 //   - TAI64 epoch: 1970-01-01 00:00:10 TAI (10 seconds after Unix epoch)
 //   - Current offset: TAI = UTC + 37 seconds (as of 2025)
+//
+// Requests of 48 bytes or more get the extended response described in
+// taiclock.go, which adds the receive time and the server clock status.
 
 var responseHeader = []byte("s")
 
+// hostClockStratum is advertised for the host clock, which is assumed to be
+// synchronized by some other means.
+const hostClockStratum = 2
+
+// serverClockStatus reports the state of the clock gtclockd hands out.
+func serverClockStatus() clockStatus {
+	if serverClock == nil {
+		return clockStatus{
+			leap:      leapNone,
+			stratum:   hostClockStratum,
+			precision: clockPrecision(),
+		}
+	}
+	return serverClock.current()
+}
+
 // sendResponse handles TAIN protocol response
-func sendResponse(conn *net.UDPConn, _ int, remoteaddr *net.UDPAddr, buf []byte) {
+func sendResponse(conn gtudpd.ResponseWriter, n int, remoteaddr *net.UDPAddr, buf []byte, received time.Time) {
 	copy(buf[0:1], responseHeader)
+	if n >= tainExtPacket {
+		packExtended(buf, glibtai.TAINfromTime(received), serverClockStatus())
+	}
+	// Take the transmit time last, right before the write
 	taiTime := glibtai.TAINNow()
 	copy(buf[4:16], glibtai.TAINPack(taiTime))
 	// Send response - ignore errors for performance (UDP is best-effort anyway)
@@ -68,18 +100,42 @@ func validateTAINRequest(config *gtudpd.Config) gtudpd.RequestValidator {
 		if buf[0] != 'c' || buf[1] != 't' || buf[2] != 'a' || buf[3] != 'i' {
 			return false
 		}
+		if serverClock != nil && !serverClock.serving() {
+			return false
+		}
 
 		// Check client permissions (this may involve filesystem operations)
 		return config.ClientOK(remoteIP)
 	}
 }
 
+// gtclockdFlags defines the gtclockd flags on fs.
+func gtclockdFlags(fs *flag.FlagSet) {
+	fs.StringVar(&configDir, "d", "", "config directory path")
+	fs.DurationVar(&estError, "esterror", 0, "treat the clock as unsynchronized above this estimated error")
+	fs.StringVar(&unsyncFlag, "unsync", "mark", "when unsynchronized: mark, refuse or ignore")
+	refclockFlag(fs, &refclocks)
+}
+
 // GTClockDRun starts a TAIN time server listening on port 4014.
 func GTClockDRun(args []string) int {
 	fs := flag.NewFlagSet("gtclockd", flag.ContinueOnError)
-	fs.StringVar(&configDir, "d", "", "config directory path")
+	gtclockdFlags(fs)
 
+	setUsage(fs, "[-d dir] [-esterror duration] [-unsync mode] [-refclock spec]...")
 	if err := fs.Parse(args); err != nil {
+		return parseFailed(err)
+	}
+
+	policy, err := parseUnsyncPolicy(unsyncFlag)
+	if err != nil {
+		_, _ = fmt.Println(err)
+		return 111
+	}
+	serverClock = newStatusMonitor(policy, estError)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := startRefclocks(ctx, serverClock, refclocks); err != nil {
 		_, _ = fmt.Println(err)
 		return 111
 	}
@@ -87,6 +143,7 @@ func GTClockDRun(args []string) int {
 	config := &gtudpd.Config{
 		DefaultPort: defaultPort,
 		ConfigDir:   configDir,
+		Sockets:     runtime.NumCPU(),
 	}
 
 	server, err := gtudpd.NewServer(config, sendResponse, validateTAINRequest(config))
@@ -96,7 +153,7 @@ func GTClockDRun(args []string) int {
 	}
 	defer func() { _ = server.Stop() }()
 
-	_, _ = fmt.Printf("TAIN time server listening on %s\n", server.Addr().String())
+	_, _ = fmt.Printf("TAIN time server listening on %s, clock %s\n", server.Addr().String(), serverClock)
 	server.Start()
 	return 0
 }
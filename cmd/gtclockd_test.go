@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/karasz/glibtai"
 	"github.com/karasz/gtclock/gtudpd"
 )
 
@@ -73,7 +74,7 @@ func TestSendResponse(t *testing.T) {
 	testBuf := make([]byte, 20)
 	copy(testBuf[:4], []byte("ctai"))
 
-	sendResponse(serverConn, 20, remoteAddr, testBuf)
+	sendResponse(serverConn, 20, remoteAddr, testBuf, time.Now())
 
 	responseBuf := make([]byte, 256)
 	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
@@ -176,3 +177,59 @@ func setupTestServer(t *testing.T) (serverConn *net.UDPConn, clientConn *net.UDP
 	}
 	return serverConn, clientConn
 }
+
+func TestSendResponseExtended(t *testing.T) {
+	serverConn, clientConn := setupTestServer(t)
+	defer func() { _ = serverConn.Close() }()
+	defer func() { _ = clientConn.Close() }()
+
+	remoteAddr, _ := net.ResolveUDPAddr("udp", clientConn.LocalAddr().String())
+
+	query, _ := makeExtendedQuery()
+	received := time.Now()
+	sendResponse(serverConn, len(query), remoteAddr, query, received)
+
+	responseBuf := make([]byte, 256)
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := clientConn.Read(responseBuf)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+
+	r, ok := unpackExtended(responseBuf[:n])
+	if !ok {
+		t.Fatalf("expected an extended response, got %x", responseBuf[:n])
+	}
+	if got := glibtai.TAINTime(r.receive); !got.Equal(received.UTC().Truncate(time.Nanosecond)) {
+		t.Errorf("receive time = %v, want %v", got, received)
+	}
+	if d := tainDiff(r.transmit, r.receive); d < 0 {
+		t.Errorf("transmit time precedes receive time by %v", -d)
+	}
+	if r.status.stratum != hostClockStratum || r.status.unsynced {
+		t.Errorf("unexpected status %+v", r.status)
+	}
+}
+
+func TestValidateTAINRequestRefusesUnsynced(t *testing.T) {
+	oldClock := serverClock
+	defer func() { serverClock = oldClock }()
+
+	config := &gtudpd.Config{DefaultPort: ":4014", MaxRequestSize: 64}
+	validator := validateTAINRequest(config)
+	request := append([]byte("ctai"), make([]byte, 16)...)
+	testIP := net.ParseIP("127.0.0.1")
+
+	serverClock = fakeStatusMonitor(unsyncRefuse, 0, kernelClock{unsynced: true}, nil)
+	if validator(len(request), request, testIP) {
+		t.Error("request answered while the clock is unsynchronized")
+	}
+
+	serverClock = fakeStatusMonitor(unsyncMark, 0, kernelClock{unsynced: true}, nil)
+	if !validator(len(request), request, testIP) {
+		t.Error("request refused with the mark policy")
+	}
+	if st := serverClockStatus(); !st.unsynced || st.stratum != stratumUnsynchronized {
+		t.Errorf("serverClockStatus() = %+v, want unsynchronized", st)
+	}
+}
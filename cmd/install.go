@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// linkState is what an applet link path currently holds.
+type linkState int
+
+const (
+	// linkMissing means nothing is at the path
+	linkMissing linkState = iota
+	// linkInstalled means the path leads to the gtclock binary
+	linkInstalled
+	// linkConflict means another file is at the path
+	linkConflict
+)
+
+func (s linkState) String() string {
+	switch s {
+	case linkInstalled:
+		return "installed"
+	case linkConflict:
+		return "conflict"
+	default:
+		return "missing"
+	}
+}
+
+// errLinkConflict is returned for applet paths held by other files.
+var errLinkConflict = errors.New("exists and is not gtclock")
+
+// versionSuffix matches version numbers appended to a binary name, like
+// -1.2.3, _v2 or @v0.4.1-rc1.
+var versionSuffix = regexp.MustCompile(`[-_.@]v?[0-9]+(\.[0-9]+)*([-+][0-9A-Za-z.-]+)?$`)
+
+// appletName maps the name gtclock was called by to a registered applet
+// name, or gtclock. The .exe extension, a version suffix and a prefix
+// ended by - or _, like that of a cross compiled binary, are ignored.
+func appletName(name string) string {
+	if isAppletName(name) {
+		return name
+	}
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".exe"), ".EXE")
+	for _, n := range []string{name, versionSuffix.ReplaceAllString(name, "")} {
+		if a, ok := matchAppletName(n); ok {
+			return a
+		}
+	}
+	return name
+}
+
+// matchAppletName matches name, or its end after a - or _, to gtclock or a
+// registered applet.
+func matchAppletName(name string) (string, bool) {
+	if isAppletName(name) {
+		return name, true
+	}
+	for _, a := range append([]string{"gtclock"}, appletNames()...) {
+		if strings.HasSuffix(name, "-"+a) || strings.HasSuffix(name, "_"+a) {
+			return a, true
+		}
+	}
+	return "", false
+}
+
+// isAppletName reports whether name is gtclock or a registered applet.
+func isAppletName(name string) bool {
+	_, ok := lookupApplet(name)
+	return ok || name == "gtclock"
+}
+
+// appletNames returns the names of the registered applets.
+func appletNames() []string {
+	names := make([]string, len(applets))
+	for i, a := range applets {
+		names[i] = a.Name
+	}
+	return names
+}
+
+// installer creates, lists or removes the links to the gtclock binary by
+// which its applets are run.
+type installer struct {
+	target  string
+	dir     string
+	ext     string
+	symlink bool
+	force   bool
+}
+
+// newInstaller returns an installer linking to the running binary.
+func newInstaller(dir string, symlink, force bool) (*installer, error) {
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		return nil, err
+	}
+	in := &installer{target: exe, dir: dir, symlink: symlink, force: force}
+	if strings.EqualFold(filepath.Ext(exe), ".exe") {
+		in.ext = filepath.Ext(exe)
+	}
+	return in, nil
+}
+
+// linkPath returns where the link for an applet goes.
+func (in *installer) linkPath(name string) string {
+	return filepath.Join(in.dir, name+in.ext)
+}
+
+// state tells what path holds. Hard and symbolic links to the binary are
+// both installed.
+func (in *installer) state(path string) (linkState, error) {
+	if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+		return linkMissing, nil
+	} else if err != nil {
+		return linkMissing, err
+	}
+	target, err := os.Stat(in.target)
+	if err != nil {
+		return linkMissing, err
+	}
+	info, err := os.Stat(path)
+	if err != nil || !os.SameFile(info, target) {
+		// Dangling symbolic links are conflicts as well
+		return linkConflict, nil
+	}
+	return linkInstalled, nil
+}
+
+// isTarget reports whether path is the binary itself rather than a link.
+func (in *installer) isTarget(path string) bool {
+	abs, err := filepath.Abs(path)
+	return err == nil && abs == in.target
+}
+
+// link creates the link at path.
+func (in *installer) link(path string) error {
+	if in.symlink {
+		return os.Symlink(in.target, path)
+	}
+	return os.Link(in.target, path)
+}
+
+// install links one applet. Conflicting files are only replaced with
+// -f.
+func (in *installer) install(name string) error {
+	path := in.linkPath(name)
+	st, err := in.state(path)
+	if err != nil || st == linkInstalled {
+		return err
+	}
+	if st == linkConflict {
+		if !in.force {
+			return fmt.Errorf("%s: %w", path, errLinkConflict)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return in.link(path)
+}
+
+// remove removes the link of one applet, leaving other files alone.
+func (in *installer) remove(name string) error {
+	path := in.linkPath(name)
+	st, err := in.state(path)
+	if err != nil || st == linkMissing || in.isTarget(path) {
+		return err
+	}
+	if st == linkConflict {
+		return fmt.Errorf("%s: %w", path, errLinkConflict)
+	}
+	return os.Remove(path)
+}
+
+// list prints the state of the link of one applet.
+func (in *installer) list(name string) error {
+	path := in.linkPath(name)
+	st, err := in.state(path)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Printf("%-9s %s\n", st, path)
+	return nil
+}
+
+// forEachApplet calls fn for every applet, reporting the errors, and
+// returns the exit code.
+func forEachApplet(fn func(name string) error) int {
+	ret := 0
+	for _, name := range appletNames() {
+		if err := fn(name); err != nil {
+			_, _ = fmt.Println(err)
+			ret = 111
+		}
+	}
+	return ret
+}
+
+// installOptions holds the gtclock install flags.
+type installOptions struct {
+	symlink bool
+	list    bool
+	remove  bool
+	force   bool
+}
+
+// installFlags defines the gtclock install flags on fs.
+func installFlags(fs *flag.FlagSet) *installOptions {
+	o := &installOptions{}
+	fs.BoolVar(&o.symlink, "s", false, "create symbolic links instead of hard links")
+	fs.BoolVar(&o.list, "l", false, "list the links and their state instead")
+	fs.BoolVar(&o.remove, "r", false, "remove the links instead")
+	fs.BoolVar(&o.force, "f", false, "replace files in the way of the links")
+	return o
+}
+
+// installRun creates the links of every applet in a directory, or lists
+// or removes them.
+func installRun(args []string) int {
+	fs := flag.NewFlagSet("gtclock install", flag.ContinueOnError)
+	opts := installFlags(fs)
+
+	setUsage(fs, "[-s] [-l] [-r] [-f] <dir>")
+	if err := fs.Parse(args); err != nil {
+		return parseFailed(err)
+	}
+	if fs.NArg() != 1 || (opts.list && opts.remove) {
+		fs.Usage()
+		return 111
+	}
+
+	in, err := newInstaller(fs.Arg(0), opts.symlink, opts.force)
+	if err != nil {
+		_, _ = fmt.Println(err)
+		return 111
+	}
+	switch {
+	case opts.list:
+		return forEachApplet(in.list)
+	case opts.remove:
+		return forEachApplet(in.remove)
+	default:
+		return forEachApplet(in.install)
+	}
+}
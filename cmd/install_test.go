@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAppletName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"gtclock", "gtclock"},
+		{"gtailocal", "gtailocal"},
+		{"gtai64n", "gtai64n"},
+		{"gtclockd.exe", "gtclockd"},
+		{"GTCLOCK.EXE", "GTCLOCK"},
+		{"gtclock-1.2.3", "gtclock"},
+		{"gtclock_v2", "gtclock"},
+		{"gtai64n-v0.4.1-rc1", "gtai64n"},
+		{"gtclockc-1.0.exe", "gtclockc"},
+		{"x86_64-linux-gnu-gtclockd", "gtclockd"},
+		{"arm_gsntpclockc", "gsntpclockc"},
+		{"gtclock-gtaifilter", "gtaifilter"},
+		{"unknown", "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := appletName(tt.name); got != tt.want {
+				t.Errorf("appletName(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+// testInstaller returns an installer for dir linking to a fake binary.
+func testInstaller(t *testing.T, dir string, symlink bool) *installer {
+	t.Helper()
+	target := filepath.Join(t.TempDir(), "gtclock")
+	writeFile(t, target, "binary")
+	return &installer{target: target, dir: dir, symlink: symlink}
+}
+
+func TestInstallerInstall(t *testing.T) {
+	for _, symlink := range []bool{false, true} {
+		dir := t.TempDir()
+		in := testInstaller(t, dir, symlink)
+		for range 2 {
+			// Installing again leaves the links as they are
+			if got := forEachApplet(in.install); got != 0 {
+				t.Fatalf("install (symlink %v) = %d, want 0", symlink, got)
+			}
+		}
+		for _, name := range appletNames() {
+			if st, err := in.state(in.linkPath(name)); err != nil || st != linkInstalled {
+				t.Errorf("%s (symlink %v) is %v, %v", name, symlink, st, err)
+			}
+		}
+	}
+}
+
+func TestInstallerConflict(t *testing.T) {
+	dir := t.TempDir()
+	in := testInstaller(t, dir, true)
+	other := in.linkPath("gtclockd")
+	writeFile(t, other, "another program")
+
+	if err := in.install("gtclockd"); !errors.Is(err, errLinkConflict) {
+		t.Fatalf("install over a file = %v, want a conflict", err)
+	}
+	if err := in.remove("gtclockd"); !errors.Is(err, errLinkConflict) {
+		t.Fatalf("remove of a file = %v, want a conflict", err)
+	}
+	if b, _ := os.ReadFile(other); string(b) != "another program" {
+		t.Fatalf("conflicting file changed to %q", b)
+	}
+
+	in.force = true
+	if err := in.install("gtclockd"); err != nil {
+		t.Fatalf("install -f: %v", err)
+	}
+	if st, _ := in.state(other); st != linkInstalled {
+		t.Errorf("after install -f gtclockd is %v", st)
+	}
+}
+
+func TestInstallerRemove(t *testing.T) {
+	dir := t.TempDir()
+	in := testInstaller(t, dir, false)
+	if got := forEachApplet(in.install); got != 0 {
+		t.Fatalf("install = %d, want 0", got)
+	}
+	if got := forEachApplet(in.remove); got != 0 {
+		t.Fatalf("remove = %d, want 0", got)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("after remove the directory holds %v, %v", entries, err)
+	}
+	// Removing links that are not there is not an error
+	if got := forEachApplet(in.remove); got != 0 {
+		t.Errorf("second remove = %d, want 0", got)
+	}
+}
+
+func TestInstallerKeepsBinary(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "gtclockd")
+	writeFile(t, target, "binary")
+	in := &installer{target: target, dir: dir}
+
+	if st, _ := in.state(target); st != linkInstalled {
+		t.Fatalf("binary is %v, want installed", st)
+	}
+	if err := in.remove("gtclockd"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(target); err != nil {
+		t.Errorf("remove deleted the binary itself: %v", err)
+	}
+}
+
+func TestInstallRunArguments(t *testing.T) {
+	silence(t)
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"help", []string{"-h"}, 0},
+		{"no directory", nil, 111},
+		{"two directories", []string{"a", "b"}, 111},
+		{"list and remove", []string{"-l", "-r", "a"}, 111},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := installRun(tt.args); got != tt.want {
+				t.Errorf("installRun(%q) = %d, want %d", tt.args, got, tt.want)
+			}
+		})
+	}
+}
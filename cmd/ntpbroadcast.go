@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// listenBroadcast is the -listen value receiving IPv4 broadcasts.
+const listenBroadcast = "broadcast"
+
+// The NTP multicast groups assigned by IANA.
+const (
+	ntpMulticastIPv4 = "224.0.1.1"
+	ntpMulticastIPv6 = "ff05::101"
+)
+
+const (
+	ntpPort       = 123
+	ntpPacketSize = 48
+)
+
+var (
+	// errNoBroadcast is returned when no broadcast arrived in time.
+	errNoBroadcast = errors.New("no broadcast received")
+	// errAnySource is returned when the clock would be adjusted by the
+	// broadcasts of any host, which nothing authenticates.
+	errAnySource = errors.New("adjusting the clock from the broadcasts of any server needs -anysource, or servers in the configuration")
+)
+
+// ntpFlags defines the flags only gsntpclockc has on fs.
+func ntpFlags(fs *flag.FlagSet, o *clientOptions) {
+	fs.StringVar(&o.listen, "listen", "",
+		"receive broadcasts instead of asking: broadcast, or a multicast group like 224.0.1.1 or ff05::101, optionally with :port")
+	fs.StringVar(&o.iface, "iface", "", "interface to join the multicast group on")
+	fs.BoolVar(&o.anySource, "anysource", false,
+		"with -listen and saveclock but no configured servers, let the broadcasts of any host adjust the clock")
+}
+
+// splitListen splits a -listen value into the group and the port.
+func splitListen(s string) (string, int, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		// No port given
+		return s, ntpPort, nil
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 0 || p > 65535 {
+		return "", 0, fmt.Errorf("invalid port %q", port)
+	}
+	return host, p, nil
+}
+
+// listenNTP opens the socket receiving the broadcasts of a -listen value.
+func listenNTP(listen, iface string) (*net.UDPConn, error) {
+	group, port, err := splitListen(listen)
+	if err != nil {
+		return nil, err
+	}
+	if group == listenBroadcast {
+		return net.ListenUDP("udp4", &net.UDPAddr{Port: port})
+	}
+	ip := net.ParseIP(group)
+	if ip == nil || !ip.IsMulticast() {
+		return nil, fmt.Errorf("%s is neither broadcast nor a multicast group", group)
+	}
+	var ifi *net.Interface
+	if iface != "" {
+		if ifi, err = net.InterfaceByName(iface); err != nil {
+			return nil, err
+		}
+	}
+	network := "udp6"
+	if ip.To4() != nil {
+		network = "udp4"
+	}
+	return net.ListenMulticastUDP(network, ifi, &net.UDPAddr{IP: ip, Port: port})
+}
+
+// decodeBroadcast decodes a broadcast mode packet.
+func decodeBroadcast(b []byte) (msg, bool) {
+	var m msg
+	if len(b) < ntpPacketSize || binary.Read(bytes.NewReader(b), binary.BigEndian, &m) != nil {
+		return msg{}, false
+	}
+	return m, mode(m.LiVnMode&0x07) == broadcast
+}
+
+// calibrateDelay measures the round trip delay to a server with a client
+// mode exchange.
+func calibrateDelay(address string) (time.Duration, error) {
+	m, dst, err := getTime(address)
+	if err != nil {
+		return 0, err
+	}
+	_, rtt := getParams(m, dst)
+	return rtt, nil
+}
+
+// broadcastClient turns the broadcasts of NTP servers into samples. The
+// delay to every server is calibrated with a client mode exchange the
+// first time it is heard from.
+type broadcastClient struct {
+	conn      *net.UDPConn
+	wait      time.Duration
+	sources   map[string]serverConfig
+	delays    map[string]time.Duration
+	calibrate func(address string) (time.Duration, error)
+	buf       []byte
+}
+
+// splitRefclocks splits servers into network servers and reference clocks.
+func splitRefclocks(servers []serverConfig) (network, refclocks []serverConfig) {
+	for _, s := range servers {
+		if s.proto == protoRefclock {
+			refclocks = append(refclocks, s)
+		} else {
+			network = append(network, s)
+		}
+	}
+	return network, refclocks
+}
+
+// allowedSources maps the addresses of servers to their configuration,
+// leaving the reference clocks out. A nil map lets every server in.
+func allowedSources(servers []serverConfig) (map[string]serverConfig, error) {
+	network, _ := splitRefclocks(servers)
+	if len(network) == 0 {
+		return nil, nil
+	}
+	sources := map[string]serverConfig{}
+	for _, s := range network {
+		ips, err := net.LookupIP(s.host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			sources[ip.String()] = s
+		}
+	}
+	return sources, nil
+}
+
+// newBroadcastClient listens as -listen and -iface ask, for broadcasts of
+// the configured servers, or of any server when none is.
+func newBroadcastClient(listen, iface string, cfg clientConfig) (*broadcastClient, error) {
+	sources, err := allowedSources(cfg.servers)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := listenNTP(listen, iface)
+	if err != nil {
+		return nil, err
+	}
+	return &broadcastClient{
+		conn: conn, wait: 2 * cfg.poll, sources: sources,
+		delays: map[string]time.Duration{}, calibrate: calibrateDelay,
+		buf: make([]byte, 1500),
+	}, nil
+}
+
+// source returns the configuration of the server at ip.
+func (b *broadcastClient) source(ip net.IP) (serverConfig, bool) {
+	if b.sources == nil {
+		return serverConfig{host: ip.String(), proto: protoNTP, weight: 1}, true
+	}
+	s, ok := b.sources[ip.String()]
+	return s, ok
+}
+
+// read waits for the next broadcast packet of an allowed server.
+func (b *broadcastClient) read() (msg, ntpTime, serverConfig, error) {
+	if b.wait > 0 {
+		_ = b.conn.SetReadDeadline(time.Now().Add(b.wait))
+	}
+	for {
+		n, src, err := b.conn.ReadFromUDP(b.buf)
+		dest := encode(time.Now())
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return msg{}, 0, serverConfig{}, errNoBroadcast
+		}
+		if err != nil {
+			return msg{}, 0, serverConfig{}, err
+		}
+		m, ok := decodeBroadcast(b.buf[:n])
+		if !ok {
+			continue
+		}
+		if s, ok := b.source(src.IP); ok {
+			return m, dest, s, nil
+		}
+	}
+}
+
+// delay returns the calibrated delay to a server.
+func (b *broadcastClient) delay(s serverConfig) (time.Duration, error) {
+	if d, ok := b.delays[s.host]; ok {
+		return d, nil
+	}
+	d, err := b.calibrate(s.address())
+	if err != nil {
+		return 0, fmt.Errorf("calibrating the delay: %w", err)
+	}
+	b.delays[s.host] = d
+	return d, nil
+}
+
+// measure waits for the next broadcast and returns its sample. The server
+// configuration it is given stands for the group and is not used.
+func (b *broadcastClient) measure(serverConfig) (clientSample, error) {
+	m, dest, s, err := b.read()
+	if err != nil {
+		return clientSample{}, err
+	}
+	delay, err := b.delay(s)
+	if err != nil {
+		return clientSample{server: s}, err
+	}
+	leap := m.LiVnMode >> 6
+	return clientSample{
+		server: s, offset: m.TransmitTime.sub(dest) + delay/2, delay: delay,
+		stratum: int(m.Stratum), leap: int(leap), dispersion: fromNTPShort(m.RootDispersion),
+		unsynced: leap == leapUnknown || m.Stratum == 0,
+	}, nil
+}
+
+// measureWith returns the function measuring the broadcasts, and the
+// reference clocks with refclocks.
+func (b *broadcastClient) measureWith(refclocks measureFunc) measureFunc {
+	return func(s serverConfig) (clientSample, error) {
+		if s.proto == protoRefclock {
+			return refclocks(s)
+		}
+		return b.measure(s)
+	}
+}
+
+// Close stops listening.
+func (b *broadcastClient) Close() error {
+	return b.conn.Close()
+}
+
+// listenRun makes run take its samples from broadcasts, one per round, as
+// -listen asks. It returns what to close once done, nil when not
+// listening. Broadcasts are not authenticated: without configured servers
+// any host could send them, so they only adjust the clock with -anysource.
+// The reference clocks are still measured every round.
+func (o *clientOptions) listenRun(run *clientRun) (io.Closer, error) {
+	if o.listen == "" {
+		return nil, nil
+	}
+	network, refclocks := splitRefclocks(run.cfg.servers)
+	if len(network) == 0 && run.saveClock && !o.anySource {
+		return nil, errAnySource
+	}
+	b, err := newBroadcastClient(o.listen, o.iface, run.cfg)
+	if err != nil {
+		return nil, err
+	}
+	run.measure = b.measureWith(run.measure)
+	run.cfg.servers = append([]serverConfig{{host: o.listen, proto: protoNTP, weight: 1}}, refclocks...)
+	// Broadcasts come at the pace of the servers
+	run.cfg.poll = 0
+	return b, nil
+}
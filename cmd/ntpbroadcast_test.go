@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSplitListen(t *testing.T) {
+	tests := []struct {
+		in       string
+		wantHost string
+		wantPort int
+		wantErr  bool
+	}{
+		{"broadcast", "broadcast", ntpPort, false},
+		{"224.0.1.1", "224.0.1.1", ntpPort, false},
+		{"ff05::101", "ff05::101", ntpPort, false},
+		{"224.0.1.1:1123", "224.0.1.1", 1123, false},
+		{"[ff05::101]:1123", "ff05::101", 1123, false},
+		{"broadcast:x", "", 0, true},
+	}
+	for _, tt := range tests {
+		host, port, err := splitListen(tt.in)
+		if host != tt.wantHost || port != tt.wantPort || (err != nil) != tt.wantErr {
+			t.Errorf("splitListen(%q) = %s, %d, %v", tt.in, host, port, err)
+		}
+	}
+}
+
+func TestListenNTPErrors(t *testing.T) {
+	for _, listen := range []string{"192.0.2.1", "not-a-group", "224.0.1.1:99999"} {
+		if conn, err := listenNTP(listen, ""); err == nil {
+			_ = conn.Close()
+			t.Errorf("listenNTP(%q) succeeded", listen)
+		}
+	}
+}
+
+// broadcastPacket encodes a broadcast mode packet sent at t.
+func broadcastPacket(t *testing.T, md mode, stratum byte, sent time.Time) []byte {
+	t.Helper()
+	m := msg{Stratum: stratum, TransmitTime: encode(sent)}
+	m.setVersion(4)
+	m.setMode(md)
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.BigEndian, &m); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeBroadcast(t *testing.T) {
+	now := time.Now()
+	if m, ok := decodeBroadcast(broadcastPacket(t, broadcast, 2, now)); !ok || m.Stratum != 2 {
+		t.Errorf("broadcast packet not decoded: %+v, %v", m, ok)
+	}
+	if _, ok := decodeBroadcast(broadcastPacket(t, server, 2, now)); ok {
+		t.Error("server packet taken for a broadcast")
+	}
+	if _, ok := decodeBroadcast([]byte{0x25}); ok {
+		t.Error("short packet taken for a broadcast")
+	}
+}
+
+// testBroadcastClient listens on a loopback port, for the broadcasts of
+// sources.
+func testBroadcastClient(t *testing.T, sources map[string]serverConfig) (*broadcastClient, *net.UDPConn) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	sender, err := net.DialUDP("udp4", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sender.Close() })
+	b := &broadcastClient{
+		conn: conn, wait: time.Second, sources: sources,
+		delays: map[string]time.Duration{}, buf: make([]byte, 1500),
+		calibrate: func(string) (time.Duration, error) { return 10 * time.Millisecond, nil },
+	}
+	return b, sender
+}
+
+func TestBroadcastMeasure(t *testing.T) {
+	b, sender := testBroadcastClient(t, nil)
+	calibrations := 0
+	b.calibrate = func(address string) (time.Duration, error) {
+		calibrations++
+		if address != "127.0.0.1:123" {
+			t.Errorf("calibrating %s", address)
+		}
+		return 10 * time.Millisecond, nil
+	}
+	for i := 0; i < 2; i++ {
+		_, _ = sender.Write(broadcastPacket(t, server, 2, time.Now()))
+		_, _ = sender.Write(broadcastPacket(t, broadcast, 2, time.Now().Add(time.Second)))
+		sample, err := b.measure(serverConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sample.server.host != "127.0.0.1" || sample.delay != 10*time.Millisecond || sample.unsynced {
+			t.Errorf("sample = %+v", sample)
+		}
+		if d := sample.offset - time.Second; d.Abs() > 50*time.Millisecond {
+			t.Errorf("offset = %v, want about 1s", sample.offset)
+		}
+	}
+	if calibrations != 1 {
+		t.Errorf("calibrated %d times, want once", calibrations)
+	}
+}
+
+func TestBroadcastSources(t *testing.T) {
+	b, sender := testBroadcastClient(t, map[string]serverConfig{
+		"192.0.2.1": {host: "192.0.2.1", proto: protoNTP, weight: 1},
+	})
+	b.wait = 100 * time.Millisecond
+	_, _ = sender.Write(broadcastPacket(t, broadcast, 2, time.Now()))
+	if _, err := b.measure(serverConfig{}); !errors.Is(err, errNoBroadcast) {
+		t.Errorf("broadcast of an unknown server not ignored: %v", err)
+	}
+}
+
+func TestBroadcastCalibrationFailure(t *testing.T) {
+	b, sender := testBroadcastClient(t, nil)
+	b.calibrate = func(string) (time.Duration, error) { return 0, errors.New("timeout") }
+	_, _ = sender.Write(broadcastPacket(t, broadcast, 0, time.Now()))
+	sample, err := b.measure(serverConfig{})
+	if err == nil || sample.server.host != "127.0.0.1" {
+		t.Errorf("measure() = %+v, %v, want a calibration error", sample, err)
+	}
+}
+
+func TestClientLoadConfigListen(t *testing.T) {
+	fs, opts := clientFlagSet(t, "-config", t.TempDir())
+	opts.listen = ntpMulticastIPv4
+	if _, err := opts.loadConfig(fs, protoNTP, parseNTPArgs); err != nil {
+		t.Errorf("listening without servers: %v", err)
+	}
+}
+
+func TestListenRunRefclocks(t *testing.T) {
+	shm := serverConfig{host: "shm:2", proto: protoRefclock, weight: 1}
+	if sources, err := allowedSources([]serverConfig{shm}); sources != nil || err != nil {
+		t.Errorf("allowedSources(refclock) = %v, %v, want any source", sources, err)
+	}
+
+	cfg := defaultClientConfig()
+	cfg.servers = []serverConfig{shm}
+	refclock := func(s serverConfig) (clientSample, error) {
+		return clientSample{server: s, offset: time.Millisecond}, nil
+	}
+	run := newClientRun(cfg, refclock, false, io.Discard)
+	o := &clientOptions{listen: listenBroadcast + ":0"}
+	closer, err := o.listenRun(run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = closer.Close() }()
+	if len(run.cfg.servers) != 2 || run.cfg.servers[1].host != shm.host {
+		t.Fatalf("servers = %+v, want the group and the reference clock", run.cfg.servers)
+	}
+	if sample, err := run.measure(shm); err != nil || sample.offset != time.Millisecond {
+		t.Errorf("measure(refclock) = %+v, %v, want the reference clock sample", sample, err)
+	}
+}
+
+func TestListenRunAnySource(t *testing.T) {
+	tests := []struct {
+		name      string
+		servers   []serverConfig
+		saveClock bool
+		anySource bool
+		wantErr   bool
+	}{
+		{"any source, measuring", nil, false, false, false},
+		{"any source, adjusting", nil, true, false, true},
+		{"any source, opted in", nil, true, true, false},
+		{"configured sources", []serverConfig{{host: "127.0.0.1", proto: protoNTP, weight: 1}}, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &clientOptions{listen: listenBroadcast + ":0", anySource: tt.anySource}
+			cfg := defaultClientConfig()
+			cfg.servers = tt.servers
+			closer, err := o.listenRun(newClientRun(cfg, nil, tt.saveClock, io.Discard))
+			if closer != nil {
+				_ = closer.Close()
+			}
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, errAnySource)) {
+				t.Errorf("listenRun() = %v", err)
+			}
+		})
+	}
+}
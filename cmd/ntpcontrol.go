@@ -0,0 +1,401 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karasz/gtclock/gtudpd"
+)
+
+// NTP Control Messages (mode 6):
+//
+// gsntpclockd answers the READSTAT and READVAR queries of ntpq and gntpq
+// from the clients allowed in its -d directory, whatever the state of its
+// clock. Every message has a 12 byte header followed by data, padded to a
+// multiple of 4 bytes:
+//
+//   Byte  0:     Leap indicator (0), version, mode 6
+//   Byte  1:     Response (0x80), error (0x40) and more (0x20) bits, opcode
+//   Bytes 2-3:   Sequence number, echoed in the response
+//   Bytes 4-5:   Status word, the error code in the high byte of errors
+//   Bytes 6-7:   Association ID, 0 for the system
+//   Bytes 8-9:   Offset of the data of this fragment in the response
+//   Bytes 10-11: Count of data bytes in this fragment
+//
+// READSTAT of association 0 returns the system status word and, as data,
+// the association IDs and peer status words of every peer. READVAR returns
+// the variables of the system, or of an association, as text; a request
+// with data only returns the comma separated variables it names.
+
+const (
+	ctlOpReadStat = 1
+	ctlOpReadVar  = 2
+
+	ctlResponse = 0x80
+	ctlError    = 0x40
+	ctlMore     = 0x20
+	ctlOpMask   = 0x1f
+
+	ctlHeaderSize = 12
+	ctlMaxData    = 468
+)
+
+// ctlErr is the error code of a control message.
+type ctlErr byte
+
+// Control message error codes.
+const (
+	ctlErrBadFormat  ctlErr = 2
+	ctlErrBadOp      ctlErr = 3
+	ctlErrBadAssoc   ctlErr = 4
+	ctlErrUnknownVar ctlErr = 5
+)
+
+// Error describes the code.
+func (e ctlErr) Error() string {
+	switch e {
+	case ctlErrBadFormat:
+		return "bad request format"
+	case ctlErrBadOp:
+		return "unknown opcode"
+	case ctlErrBadAssoc:
+		return "unknown association"
+	case ctlErrUnknownVar:
+		return "unknown variable"
+	default:
+		return "unspecified error"
+	}
+}
+
+// Clock sources of the system status word.
+const (
+	ctlSourcePPS   = 1
+	ctlSourceUHF   = 4
+	ctlSourceLocal = 5
+	ctlSourceNTP   = 6
+)
+
+// Peer status bits and peer selection values of the peer status word.
+const (
+	ctlPeerConfigured = 0x80
+	ctlPeerReachable  = 0x10
+	ctlSelReject      = 0
+	ctlSelCandidate   = 4
+	ctlSelSysPeer     = 6
+)
+
+// ctlHeader is the header of a control message.
+type ctlHeader struct {
+	LiVnMode byte
+	Op       byte
+	Sequence uint16
+	Status   uint16
+	AssocID  uint16
+	Offset   uint16
+	Count    uint16
+}
+
+// ctlPacket is a control message.
+type ctlPacket struct {
+	ctlHeader
+	data []byte
+}
+
+// unpackControl decodes a control message.
+func unpackControl(b []byte) (ctlPacket, error) {
+	var p ctlPacket
+	if len(b) < ctlHeaderSize || binary.Read(bytes.NewReader(b), binary.BigEndian, &p.ctlHeader) != nil {
+		return ctlPacket{}, ctlErrBadFormat
+	}
+	if mode(p.LiVnMode&0x07) != controlMessage || int(p.Count) > len(b)-ctlHeaderSize {
+		return ctlPacket{}, ctlErrBadFormat
+	}
+	p.data = b[ctlHeaderSize : ctlHeaderSize+int(p.Count)]
+	return p, nil
+}
+
+// pack encodes p, padding the data to a multiple of 4 bytes.
+func (p ctlPacket) pack() []byte {
+	var buf bytes.Buffer
+	h := p.ctlHeader
+	h.Count = uint16(len(p.data))
+	_ = binary.Write(&buf, binary.BigEndian, &h)
+	buf.Write(p.data)
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+// controlFragments splits the response to req carrying status and data
+// into messages of at most ctlMaxData bytes of data.
+func controlFragments(req ctlPacket, status uint16, data []byte, op byte) []ctlPacket {
+	var frags []ctlPacket
+	for offset := 0; offset == 0 || offset < len(data); offset += ctlMaxData {
+		end := min(offset+ctlMaxData, len(data))
+		p := ctlPacket{ctlHeader: req.ctlHeader, data: data[offset:end]}
+		p.Op = op | ctlResponse
+		p.Status = status
+		p.Offset = uint16(offset)
+		if end < len(data) {
+			p.Op |= ctlMore
+		}
+		frags = append(frags, p)
+	}
+	return frags
+}
+
+// ctlVar is a variable returned by READVAR.
+type ctlVar struct {
+	name  string
+	value string
+}
+
+// formatVars formats vars as READVAR data, only those named by the comma
+// separated list names when it is not empty.
+func formatVars(vars []ctlVar, names string) ([]byte, error) {
+	if strings.TrimSpace(names) == "" {
+		return joinVars(vars), nil
+	}
+	byName := make(map[string]ctlVar, len(vars))
+	for _, v := range vars {
+		byName[v.name] = v
+	}
+	var selected []ctlVar
+	for _, name := range strings.Split(names, ",") {
+		v, ok := byName[strings.TrimSpace(name)]
+		if !ok {
+			return nil, ctlErrUnknownVar
+		}
+		selected = append(selected, v)
+	}
+	return joinVars(selected), nil
+}
+
+// joinVars formats vars as name=value pairs.
+func joinVars(vars []ctlVar) []byte {
+	pairs := make([]string, len(vars))
+	for i, v := range vars {
+		pairs[i] = v.name + "=" + v.value
+	}
+	return []byte(strings.Join(pairs, ", "))
+}
+
+// refidString formats a reference ID: as text for the host clock and stratum
+// 1 sources, which name a kind of clock, as an address otherwise.
+func refidString(refid uint32, stratum byte) string {
+	b := binary.BigEndian.AppendUint32(nil, refid)
+	if refid == refidLocal || stratum <= 1 {
+		return string(bytes.TrimRight(b, "\x00"))
+	}
+	return net.IP(b).String()
+}
+
+// ntpTimeString formats t as ntpq does.
+func ntpTimeString(t ntpTime) string {
+	return fmt.Sprintf("0x%08x.%08x", uint64(t)>>32, uint64(t)&0xffffffff)
+}
+
+// millis formats d in milliseconds.
+func millis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+// peerInfo is a copy of an association, with its ID and selection.
+type peerInfo struct {
+	association
+	id       uint16
+	selected bool
+}
+
+// associations returns a copy of the associations of p.
+func (p *peerSet) associations() []peerInfo {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sel := p.selected()
+	infos := make([]peerInfo, len(p.assocs))
+	for i, a := range p.assocs {
+		infos[i] = peerInfo{association: *a, id: uint16(i + 1), selected: a == sel}
+	}
+	return infos
+}
+
+// status returns the peer status word.
+func (pi peerInfo) status() uint16 {
+	st := byte(ctlPeerConfigured)
+	if pi.reach != 0 {
+		st |= ctlPeerReachable
+	}
+	switch {
+	case pi.selected:
+		st |= ctlSelSysPeer
+	case pi.synchronized():
+		st |= ctlSelCandidate
+	default:
+		st |= ctlSelReject
+	}
+	return uint16(st) << 8
+}
+
+// vars returns the READVAR variables of the association.
+func (pi peerInfo) vars() []ctlVar {
+	host, port, _ := net.SplitHostPort(pi.address)
+	best, _ := pi.best()
+	var delays, offsets []string
+	for _, f := range pi.filter {
+		if f.valid {
+			delays = append(delays, millis(f.delay))
+			offsets = append(offsets, millis(f.offset))
+		}
+	}
+	return []ctlVar{
+		{"srcadr", host}, {"srcport", port},
+		{"leap", strconv.Itoa(int(pi.leap))},
+		{"stratum", strconv.Itoa(int(pi.stratum))},
+		{"refid", refidString(pi.refid, pi.stratum)},
+		{"reach", fmt.Sprintf("0x%02x", pi.reach)},
+		{"hpoll", strconv.Itoa(int(pi.poll))},
+		{"offset", millis(best.offset)},
+		{"delay", millis(best.delay)},
+		{"filtdelay", strings.Join(delays, " ")},
+		{"filtoffset", strings.Join(offsets, " ")},
+	}
+}
+
+// systemStatus returns the system status word and the system peer.
+func (s *ntpServer) systemStatus(peers []peerInfo) (uint16, *peerInfo) {
+	st := s.status()
+	if st.stratum == 1 {
+		return uint16(st.leap)<<14 | refclockCtlSource(st.refid)<<8, nil
+	}
+	for i := range peers {
+		if peers[i].selected {
+			return uint16(st.leap)<<14 | ctlSourceNTP<<8, &peers[i]
+		}
+	}
+	return uint16(st.leap)<<14 | ctlSourceLocal<<8, nil
+}
+
+// refclockCtlSource returns the clock source of the system status word for
+// the reference clock with ID refid, GPS receivers being UHF radios.
+func refclockCtlSource(refid uint32) uint16 {
+	if refidString(refid, 1) == "PPS" {
+		return ctlSourcePPS
+	}
+	return ctlSourceUHF
+}
+
+// systemVars returns the READVAR variables of the system.
+func (s *ntpServer) systemVars(peers []peerInfo) []ctlVar {
+	st := s.status()
+	_, sysPeer := s.systemStatus(peers)
+	var peer uint16
+	var offset time.Duration
+	if sysPeer != nil {
+		best, _ := sysPeer.best()
+		peer, offset = sysPeer.id, best.offset
+	}
+	version, _, _ := strings.Cut(versionInfo(), "\n")
+	now := ntpTimeString(encode(time.Now()))
+	return []ctlVar{
+		{"version", strconv.Quote(version)},
+		{"leap", strconv.Itoa(int(st.leap))},
+		{"stratum", strconv.Itoa(int(st.stratum))},
+		{"precision", strconv.Itoa(int(st.precision))},
+		{"rootdisp", millis(st.estError)},
+		{"refid", refidString(servedRefid(st), st.stratum)},
+		{"reftime", ntpTimeString(refTime(st.reftime))},
+		{"clock", now},
+		{"peer", strconv.Itoa(int(peer))},
+		{"offset", millis(offset)},
+	}
+}
+
+// readStat answers READSTAT for association id, 0 for the system.
+func (s *ntpServer) readStat(id uint16, peers []peerInfo) (uint16, []byte, error) {
+	if id != 0 {
+		pi, err := findPeer(peers, id)
+		return pi.status(), nil, err
+	}
+	status, _ := s.systemStatus(peers)
+	var data []byte
+	for _, pi := range peers {
+		data = binary.BigEndian.AppendUint16(data, pi.id)
+		data = binary.BigEndian.AppendUint16(data, pi.status())
+	}
+	return status, data, nil
+}
+
+// readVar answers READVAR for association id, 0 for the system.
+func (s *ntpServer) readVar(id uint16, names string, peers []peerInfo) (uint16, []byte, error) {
+	if id != 0 {
+		pi, err := findPeer(peers, id)
+		if err != nil {
+			return 0, nil, err
+		}
+		data, err := formatVars(pi.vars(), names)
+		return pi.status(), data, err
+	}
+	status, _ := s.systemStatus(peers)
+	data, err := formatVars(s.systemVars(peers), names)
+	return status, data, err
+}
+
+// findPeer returns the association with ID id.
+func findPeer(peers []peerInfo, id uint16) (peerInfo, error) {
+	if id == 0 || int(id) > len(peers) {
+		return peerInfo{}, ctlErrBadAssoc
+	}
+	return peers[id-1], nil
+}
+
+// controlPeers returns the associations of s, the one it falls back on, if
+// any, selected.
+func (s *ntpServer) controlPeers() []peerInfo {
+	peers := s.peers.associations()
+	if s.status().refid == 0 {
+		for i := range peers {
+			peers[i].selected = false
+		}
+	}
+	return peers
+}
+
+// controlData returns the status word and data answering req.
+func (s *ntpServer) controlData(req ctlPacket) (uint16, []byte, error) {
+	peers := s.controlPeers()
+	switch req.Op & ctlOpMask {
+	case ctlOpReadStat:
+		return s.readStat(req.AssocID, peers)
+	case ctlOpReadVar:
+		return s.readVar(req.AssocID, string(req.data), peers)
+	default:
+		return 0, nil, ctlErrBadOp
+	}
+}
+
+// control answers a control message.
+func (s *ntpServer) control(conn gtudpd.ResponseWriter, remoteaddr *net.UDPAddr, buf []byte) {
+	req, err := unpackControl(buf)
+	if err != nil || req.Op&ctlResponse != 0 {
+		return
+	}
+	op := req.Op & ctlOpMask
+	status, data, err := s.controlData(req)
+	var code ctlErr
+	if errors.As(err, &code) {
+		status, data, op = uint16(code)<<8, nil, op|ctlError
+	}
+	for _, p := range controlFragments(req, status, data, op) {
+		_, _ = conn.WriteToUDP(p.pack(), remoteaddr)
+	}
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestControlPacketRoundTrip(t *testing.T) {
+	p := ctlPacket{
+		ctlHeader: ctlHeader{LiVnMode: byte(controlMessage) | 2<<3, Op: ctlOpReadVar, Sequence: 7, AssocID: 3},
+		data:      []byte("stratum"),
+	}
+	b := p.pack()
+	if len(b) != ctlHeaderSize+8 {
+		t.Fatalf("packed %d bytes, want the data padded to 8", len(b))
+	}
+	got, err := unpackControl(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Sequence != 7 || got.AssocID != 3 || got.Count != 7 || string(got.data) != "stratum" {
+		t.Errorf("unpackControl() = %+v", got)
+	}
+
+	for _, bad := range [][]byte{b[:11], packNTP(msg{LiVnMode: byte(client)}), b[:ctlHeaderSize+6]} {
+		if _, err := unpackControl(bad); !errors.Is(err, ctlErrBadFormat) {
+			t.Errorf("unpackControl(% x) error = %v", bad, err)
+		}
+	}
+}
+
+func TestControlFragments(t *testing.T) {
+	req := ctlPacket{ctlHeader: ctlHeader{Op: ctlOpReadVar, Sequence: 9}}
+	data := bytes.Repeat([]byte("x"), 2*ctlMaxData+10)
+	frags := controlFragments(req, 0x0615, data, ctlOpReadVar)
+	if len(frags) != 3 {
+		t.Fatalf("got %d fragments, want 3", len(frags))
+	}
+	for i, f := range frags {
+		more := f.Op&ctlMore != 0
+		if more != (i < 2) || f.Op&ctlResponse == 0 || f.Sequence != 9 || f.Status != 0x0615 || int(f.Offset) != i*ctlMaxData {
+			t.Errorf("fragment %d = %+v", i, f.ctlHeader)
+		}
+	}
+	if empty := controlFragments(req, 0, nil, ctlOpReadStat); len(empty) != 1 || len(empty[0].data) != 0 {
+		t.Errorf("empty response = %+v", empty)
+	}
+}
+
+func TestFormatVars(t *testing.T) {
+	vars := []ctlVar{{"stratum", "2"}, {"leap", "0"}, {"version", `"gtclock"`}}
+	tests := []struct {
+		names   string
+		want    string
+		wantErr error
+	}{
+		{"", `stratum=2, leap=0, version="gtclock"`, nil},
+		{"leap, stratum", "leap=0, stratum=2", nil},
+		{"jitter", "", ctlErrUnknownVar},
+	}
+	for _, tt := range tests {
+		got, err := formatVars(vars, tt.names)
+		if string(got) != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("formatVars(%q) = %q, %v", tt.names, got, err)
+		}
+	}
+}
+
+func TestRefidString(t *testing.T) {
+	tests := []struct {
+		refid   uint32
+		stratum byte
+		want    string
+	}{
+		{refidLocal, 2, "LOCL"},
+		{0x47505300, 1, "GPS"},
+		{0xc0000201, 3, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		if got := refidString(tt.refid, tt.stratum); got != tt.want {
+			t.Errorf("refidString(%#x, %d) = %q, want %q", tt.refid, tt.stratum, got, tt.want)
+		}
+	}
+}
+
+// controlRequest returns a control request with opcode op about assoc.
+func controlRequest(op byte, assoc uint16, data string) []byte {
+	return ctlPacket{
+		ctlHeader: ctlHeader{LiVnMode: byte(controlMessage) | ntpVersion<<3, Op: op, Sequence: 1, AssocID: assoc},
+		data:      []byte(data),
+	}.pack()
+}
+
+func TestNTPServerControl(t *testing.T) {
+	s := testNTPServer(unsyncMark, kernelClock{})
+	s.peers = testPeerSet(map[string]ntpReply{"192.0.2.1:123": peerReply(time.Millisecond, 2*time.Millisecond)})
+	s.peers.poll(s.peers.assocs[0])
+
+	tests := []struct {
+		name    string
+		req     []byte
+		wantErr ctlErr
+		want    string
+	}{
+		{"system variables", controlRequest(ctlOpReadVar, 0, ""), 0, "stratum=2"},
+		{"selected variable", controlRequest(ctlOpReadVar, 0, "refid"), 0, "refid=LOCL"},
+		{"peer variables", controlRequest(ctlOpReadVar, 1, ""), 0, "srcadr=192.0.2.1, srcport=123"},
+		{"system status", controlRequest(ctlOpReadStat, 0, ""), 0, "\x00\x01\x94\x00"},
+		{"unknown association", controlRequest(ctlOpReadVar, 2, ""), ctlErrBadAssoc, ""},
+		{"unknown variable", controlRequest(ctlOpReadVar, 0, "jitter"), ctlErrUnknownVar, ""},
+		{"unknown opcode", controlRequest(8, 0, ""), ctlErrBadOp, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := exchangeControl(t, s, tt.req)
+			if tt.wantErr != 0 {
+				if resp.Op&ctlError == 0 || ctlErr(resp.Status>>8) != tt.wantErr {
+					t.Errorf("response = %+v, want error %v", resp.ctlHeader, tt.wantErr)
+				}
+				return
+			}
+			if resp.Op&ctlError != 0 || !strings.Contains(string(resp.data), tt.want) {
+				t.Errorf("response = %+v %q, want %q", resp.ctlHeader, resp.data, tt.want)
+			}
+		})
+	}
+}
+
+// exchangeControl has s answer req and returns the response.
+func exchangeControl(t *testing.T, s *ntpServer, req []byte) ctlPacket {
+	t.Helper()
+	serverConn, clientConn := setupTestServer(t)
+	defer func() { _ = serverConn.Close() }()
+	defer func() { _ = clientConn.Close() }()
+
+	if !s.validate(len(req), req, net.IPv4(127, 0, 0, 1)) {
+		t.Fatal("control request not accepted")
+	}
+	s.respond(serverConn, len(req), clientConn.LocalAddr().(*net.UDPAddr), req, time.Now())
+	buf := make([]byte, 1024)
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := clientConn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := unpackControl(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestNTPServerControlWhileRefusing(t *testing.T) {
+	s := testNTPServer(unsyncRefuse, kernelClock{unsynced: true})
+	req := controlRequest(ctlOpReadVar, 0, "")
+	if !s.validate(len(req), req, net.IPv4(127, 0, 0, 1)) {
+		t.Error("control request refused with the clock")
+	}
+	if short := req[:ctlHeaderSize-1]; s.validate(len(short), short, net.IPv4(127, 0, 0, 1)) {
+		t.Error("short control request accepted")
+	}
+}
@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// NTP Symmetric Mode:
+//
+// With -peer gsntpclockd keeps an association with every peer: it sends
+// symmetric active packets every 2^poll seconds and keeps the offset and
+// delay of the answers in a clock filter. The reach register records which
+// of the last eight polls were answered; the poll interval grows while all
+// of them were and drops back to the minimum on a miss.
+//
+// While the kernel reports the host clock unsynchronized, as when its
+// upstream source is lost, the server falls back on the best reachable and
+// synchronized peer: it adjusts the clock by the offset of that peer and
+// serves its stratum plus one, with the peer as reference ID. A peer whose
+// reference ID is this server is not fallen back on, so two peers that
+// both lost their upstream do not end up synchronized to each other.
+//
+// Symmetric active packets are answered with symmetric passive ones, from
+// configured peers or not, as client requests are.
+
+const (
+	peerMinPoll  = 6
+	peerMaxPoll  = 10
+	filterStages = 8
+)
+
+// filterSample is one stage of the clock filter of an association.
+type filterSample struct {
+	offset time.Duration
+	delay  time.Duration
+	valid  bool
+	// stale marks samples taken before the clock was last adjusted, whose
+	// offsets no longer hold
+	stale bool
+}
+
+// association is what a server knows about one of its peers.
+type association struct {
+	address string
+	reach   uint8
+	poll    int8
+	filter  [filterStages]filterSample
+	next    int
+	stratum byte
+	leap    byte
+	// refid is the reference ID the peer advertises
+	refid uint32
+	// source is the peer, and self this server, as reference IDs
+	source uint32
+	self   uint32
+}
+
+// newAssociation creates the association with the peer at address.
+func newAssociation(address string) *association {
+	return &association{
+		address: address,
+		poll:    peerMinPoll,
+		stratum: stratumUnsynchronized,
+		leap:    leapUnknown,
+	}
+}
+
+// refidOf returns the reference ID standing for ip: the IPv4 address, or
+// the first four bytes of the MD5 digest of the IPv6 one.
+func refidOf(ip net.IP) uint32 {
+	if ip4 := ip.To4(); ip4 != nil {
+		return binary.BigEndian.Uint32(ip4)
+	}
+	sum := md5.Sum(ip.To16())
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// update records an answer of the peer.
+func (a *association) update(r ntpReply) {
+	offset, delay := getParams(r.msg, r.dest)
+	a.filter[a.next] = filterSample{offset: offset, delay: delay, valid: true}
+	a.next = (a.next + 1) % filterStages
+	a.reach = a.reach<<1 | 1
+	a.stratum, a.leap, a.refid = r.Stratum, r.LiVnMode>>6, r.ReferenceID
+	a.source, a.self = refidOf(r.remote), refidOf(r.local)
+	if a.reach == 0xff && a.poll < peerMaxPoll {
+		a.poll++
+	}
+}
+
+// miss records an unanswered poll.
+func (a *association) miss() {
+	a.reach <<= 1
+	a.poll = peerMinPoll
+}
+
+// interval is the time until the next poll.
+func (a *association) interval() time.Duration {
+	return time.Second << a.poll
+}
+
+// best returns the sample of the filter with the lowest delay, which is the
+// least disturbed by the network.
+func (a *association) best() (filterSample, bool) {
+	var best filterSample
+	for _, f := range a.filter {
+		if f.valid && (!best.valid || f.delay < best.delay) {
+			best = f
+		}
+	}
+	return best, best.valid
+}
+
+// fresh returns the sample with the lowest delay among those taken since
+// the clock was last adjusted.
+func (a *association) fresh() (filterSample, bool) {
+	var best filterSample
+	for _, f := range a.filter {
+		if f.valid && !f.stale && (!best.valid || f.delay < best.delay) {
+			best = f
+		}
+	}
+	return best, best.valid
+}
+
+// adjusted marks the samples stale once the clock was adjusted.
+func (a *association) adjusted() {
+	for i := range a.filter {
+		a.filter[i].stale = true
+	}
+}
+
+// synchronized reports whether the peer can be fallen back on.
+func (a *association) synchronized() bool {
+	return a.reach != 0 && a.leap != leapUnknown && a.refid != a.self &&
+		a.stratum > 0 && a.stratum < stratumUnsynchronized-1
+}
+
+// peerSet holds the associations of a server.
+type peerSet struct {
+	mu       sync.Mutex
+	assocs   []*association
+	exchange func(address string, md mode) (ntpReply, error)
+	adjust   func(offset time.Duration) (string, error)
+	// adjusted is when the clock was last adjusted to a peer
+	adjusted time.Time
+}
+
+// newPeerSet creates the associations with the peers at addresses.
+func newPeerSet(addresses []string) *peerSet {
+	p := &peerSet{
+		exchange: ntpExchange,
+		adjust: func(offset time.Duration) (string, error) {
+			return adjustClock(offset, disciplineStep)
+		},
+	}
+	for _, address := range addresses {
+		p.assocs = append(p.assocs, newAssociation(address))
+	}
+	return p
+}
+
+// peerAddress adds the NTP port to a -peer value without one.
+func peerAddress(s string) string {
+	if _, _, err := net.SplitHostPort(s); err == nil {
+		return s
+	}
+	return net.JoinHostPort(s, "123")
+}
+
+// poll polls a peer and returns the time until the next poll.
+func (p *peerSet) poll(a *association) time.Duration {
+	r, err := p.exchange(a.address, symmetricActive)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		a.miss()
+	} else {
+		a.update(r)
+	}
+	return a.interval()
+}
+
+// selected returns the peer to fall back on, the synchronized one with the
+// lowest stratum and then the lowest delay, or nil. p.mu must be held.
+func (p *peerSet) selected() *association {
+	var sel *association
+	var selDelay time.Duration
+	for _, a := range p.assocs {
+		best, ok := a.best()
+		if !ok || !a.synchronized() {
+			continue
+		}
+		if sel == nil || a.stratum < sel.stratum || (a.stratum == sel.stratum && best.delay < selDelay) {
+			sel, selDelay = a, best.delay
+		}
+	}
+	return sel
+}
+
+// fallback returns the status to serve when the host clock has status st:
+// st while it is synchronized, else the status of the selected peer.
+func (p *peerSet) fallback(st clockStatus) clockStatus {
+	if p == nil || !st.unsynced {
+		return st
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	a := p.selected()
+	if a == nil {
+		return st
+	}
+	best, _ := a.best()
+	return clockStatus{
+		leap:      a.leap,
+		stratum:   a.stratum + 1,
+		precision: st.precision,
+		estError:  best.delay / 2,
+		refid:     a.source,
+		reftime:   p.adjusted,
+	}
+}
+
+// discipline adjusts the clock by the offset of a, when a is the selected
+// peer and the host clock has the unsynchronized status st. Only samples
+// taken since the last adjustment are used, an offset is applied once.
+func (p *peerSet) discipline(a *association, st clockStatus) {
+	if !st.unsynced {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.selected() != a {
+		return
+	}
+	best, ok := a.fresh()
+	if !ok {
+		return
+	}
+	action, err := p.adjust(best.offset)
+	if err != nil {
+		_, _ = fmt.Printf("peer %s: %v\n", a.address, err)
+		return
+	}
+	a.adjusted()
+	p.adjusted = time.Now()
+	_, _ = fmt.Printf("peer %s: offset %v, %s\n", a.address, best.offset, action)
+}
+
+// runAssociation polls a peer until ctx is done, falling back on it as
+// needed after every poll.
+func (p *peerSet) runAssociation(ctx context.Context, a *association, clock *statusMonitor) {
+	for {
+		wait := p.poll(a)
+		p.discipline(a, clock.current())
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// run polls every peer until ctx is done.
+func (p *peerSet) run(ctx context.Context, clock *statusMonitor) {
+	for _, a := range p.assocs {
+		go p.runAssociation(ctx, a, clock)
+	}
+}
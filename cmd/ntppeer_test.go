@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karasz/gtclock/gtudpd"
+)
+
+func TestPeerAddress(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.1":          "192.0.2.1:123",
+		"192.0.2.1:1123":     "192.0.2.1:1123",
+		"2001:db8::1":        "[2001:db8::1]:123",
+		"[2001:db8::1]:1123": "[2001:db8::1]:1123",
+		"ntp.example.com":    "ntp.example.com:123",
+	}
+	for in, want := range tests {
+		if got := peerAddress(in); got != want {
+			t.Errorf("peerAddress(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestRefidOf(t *testing.T) {
+	if got := refidOf(net.IPv4(192, 0, 2, 1)); got != 0xc0000201 {
+		t.Errorf("refidOf(192.0.2.1) = %#x", got)
+	}
+	v6 := refidOf(net.ParseIP("2001:db8::1"))
+	if v6 == 0 || v6 == refidOf(net.ParseIP("2001:db8::2")) {
+		t.Errorf("refidOf(2001:db8::1) = %#x", v6)
+	}
+}
+
+// peerReply is an answer of a stratum 2 peer at 192.0.2.1 to 192.0.2.2,
+// offset by offset after delay.
+func peerReply(offset, delay time.Duration) ntpReply {
+	now := time.Now()
+	m := msg{
+		Stratum:       2,
+		ReferenceID:   refidLocal,
+		OriginateTime: encode(now),
+		ReceiveTime:   encode(now.Add(offset + delay/2)),
+		TransmitTime:  encode(now.Add(offset + delay/2)),
+	}
+	return ntpReply{
+		msg: m, dest: encode(now.Add(delay)),
+		local: net.IPv4(192, 0, 2, 2), remote: net.IPv4(192, 0, 2, 1),
+	}
+}
+
+func TestAssociationReach(t *testing.T) {
+	a := newAssociation("192.0.2.1:123")
+	if a.synchronized() {
+		t.Error("new association synchronized")
+	}
+	if _, ok := a.best(); ok {
+		t.Error("new association has a sample")
+	}
+
+	a.update(peerReply(time.Millisecond, 20*time.Millisecond))
+	a.update(peerReply(3*time.Millisecond, 4*time.Millisecond))
+	a.miss()
+	if a.reach != 0b110 || a.poll != peerMinPoll {
+		t.Errorf("reach = %08b, poll = %d", a.reach, a.poll)
+	}
+	best, ok := a.best()
+	if !ok || best.delay.Round(time.Millisecond) != 4*time.Millisecond || best.offset.Round(time.Millisecond) != 3*time.Millisecond {
+		t.Errorf("best() = %+v", best)
+	}
+	if !a.synchronized() || a.source != 0xc0000201 {
+		t.Errorf("association = %+v", a)
+	}
+
+	for i := 0; i < filterStages+2; i++ {
+		a.update(peerReply(0, time.Millisecond))
+	}
+	if a.reach != 0xff || a.poll != peerMinPoll+3 || a.interval() != 512*time.Second {
+		t.Errorf("reach = %08b, poll = %d after answered polls", a.reach, a.poll)
+	}
+}
+
+func TestAssociationSynchronized(t *testing.T) {
+	tests := []struct {
+		name  string
+		apply func(r *ntpReply)
+		want  bool
+	}{
+		{"synchronized", func(*ntpReply) {}, true},
+		{"stratum 0", func(r *ntpReply) { r.Stratum = 0 }, false},
+		{"stratum 15", func(r *ntpReply) { r.Stratum = 15 }, false},
+		{"leap unknown", func(r *ntpReply) { r.LiVnMode = leapUnknown << 6 }, false},
+		{"synchronized to us", func(r *ntpReply) { r.ReferenceID = 0xc0000202 }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := peerReply(0, time.Millisecond)
+			tt.apply(&r)
+			a := newAssociation("192.0.2.1:123")
+			a.update(r)
+			if got := a.synchronized(); got != tt.want {
+				t.Errorf("synchronized() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testPeerSet returns a peer set whose peers answer with replies, or fail
+// when missing.
+func testPeerSet(replies map[string]ntpReply) *peerSet {
+	var addresses []string
+	for address := range replies {
+		addresses = append(addresses, address)
+	}
+	p := newPeerSet(addresses)
+	p.exchange = func(address string, md mode) (ntpReply, error) {
+		if md != symmetricActive {
+			return ntpReply{}, errors.New("not symmetric active")
+		}
+		r, ok := replies[address]
+		if !ok {
+			return ntpReply{}, errors.New("timeout")
+		}
+		return r, nil
+	}
+	return p
+}
+
+func TestPeerSetFallback(t *testing.T) {
+	near := peerReply(time.Millisecond, 2*time.Millisecond)
+	far := peerReply(time.Second, 80*time.Millisecond)
+	far.remote = net.IPv4(192, 0, 2, 3)
+	p := testPeerSet(map[string]ntpReply{"near:123": near, "far:123": far})
+	for _, a := range p.assocs {
+		if wait := p.poll(a); wait != 64*time.Second {
+			t.Errorf("poll() = %v", wait)
+		}
+	}
+
+	synced := clockStatus{stratum: hostClockStratum, precision: -20}
+	if got := p.fallback(synced); got != synced {
+		t.Errorf("fallback(synchronized) = %+v", got)
+	}
+	unsynced := clockStatus{stratum: stratumUnsynchronized, leap: leapUnknown, unsynced: true, precision: -20}
+	got := p.fallback(unsynced)
+	if got.unsynced || got.stratum != 3 || got.refid != 0xc0000201 || got.precision != -20 || got.estError.Round(time.Millisecond) != time.Millisecond {
+		t.Errorf("fallback(unsynchronized) = %+v", got)
+	}
+	if got := (*peerSet)(nil).fallback(unsynced); got != unsynced {
+		t.Errorf("fallback without peers = %+v", got)
+	}
+
+	// A lower stratum wins over a lower delay
+	far.Stratum = 1
+	p = testPeerSet(map[string]ntpReply{"near:123": near, "far:123": far})
+	for _, a := range p.assocs {
+		p.poll(a)
+	}
+	if got := p.fallback(unsynced); got.stratum != 2 || got.refid != 0xc0000203 {
+		t.Errorf("fallback() = %+v, want the stratum 1 peer", got)
+	}
+}
+
+func TestPeerSetDiscipline(t *testing.T) {
+	p := testPeerSet(map[string]ntpReply{"peer:123": peerReply(5*time.Millisecond, 2*time.Millisecond)})
+	var adjusted []time.Duration
+	p.adjust = func(offset time.Duration) (string, error) {
+		adjusted = append(adjusted, offset)
+		return "slew", nil
+	}
+	silence(t)
+	a := p.assocs[0]
+	p.discipline(a, clockStatus{unsynced: true})
+	p.poll(a)
+	p.discipline(a, clockStatus{})
+	p.discipline(a, clockStatus{unsynced: true})
+	p.discipline(newAssociation("other:123"), clockStatus{unsynced: true})
+	if len(adjusted) != 1 || adjusted[0].Round(time.Millisecond) != 5*time.Millisecond {
+		t.Errorf("adjusted %v, want the peer offset once", adjusted)
+	}
+
+	// The samples taken before an adjustment are not applied again, only
+	// the next poll is
+	p.discipline(a, clockStatus{unsynced: true})
+	p.poll(a)
+	p.discipline(a, clockStatus{unsynced: true})
+	if len(adjusted) != 2 {
+		t.Errorf("adjusted %v, want the offset of each poll once", adjusted)
+	}
+	if _, ok := a.best(); !ok {
+		t.Error("the peer has no sample left to fall back on after an adjustment")
+	}
+	if st := p.fallback(clockStatus{unsynced: true}); time.Since(st.reftime) > time.Second {
+		t.Errorf("fallback() reference time = %v, want the last adjustment", st.reftime)
+	}
+}
+
+// startNTPServer runs s on loopback and returns its address.
+func startNTPServer(t *testing.T, s *ntpServer) string {
+	t.Helper()
+	srv, err := gtudpd.NewServer(s.config, s.respond, s.validate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	t.Cleanup(func() { _ = srv.Stop() })
+	return srv.Addr().String()
+}
+
+func TestSymmetricPeers(t *testing.T) {
+	upstream := testNTPServer(unsyncMark, kernelClock{})
+	upstreamAddr := startNTPServer(t, upstream)
+
+	lost := testNTPServer(unsyncMark, kernelClock{unsynced: true})
+	lost.peers = newPeerSet([]string{upstreamAddr})
+	lostAddr := startNTPServer(t, lost)
+
+	lost.peers.poll(lost.peers.assocs[0])
+	if a := lost.peers.assocs[0]; a.reach != 1 || a.stratum != hostClockStratum {
+		t.Fatalf("association = %+v", a)
+	}
+	m, _, err := getTime(lostAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Stratum != hostClockStratum+1 || m.ReferenceID != 0x7f000001 || m.LiVnMode>>6 == leapUnknown {
+		t.Errorf("server falling back on its peer answered %+v", m)
+	}
+
+	// The peer answers in symmetric passive mode
+	r, err := ntpExchange(upstreamAddr, symmetricActive)
+	if err != nil || mode(r.LiVnMode&0x07) != symmetricPassive {
+		t.Errorf("symmetric active exchange = %+v, %v", r, err)
+	}
+
+	// Once the upstream server loses its own source it does not fall back
+	// on the server synchronized to it
+	upstreamLost := testNTPServer(unsyncMark, kernelClock{unsynced: true})
+	upstreamLost.peers = newPeerSet([]string{lostAddr})
+	upstreamLost.peers.poll(upstreamLost.peers.assocs[0])
+	if st := upstreamLost.status(); !st.unsynced {
+		t.Errorf("upstream fell back on its own client: %+v", st)
+	}
+}
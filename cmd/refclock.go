@@ -0,0 +1,373 @@
+package cmd
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/karasz/gtclock/refclock"
+)
+
+// Reference Clocks:
+//
+// With -refclock gtclockd and gsntpclockd read reference clocks attached to
+// the host, like the NMEA sentences or the PPS signal of a GPS receiver, and
+// discipline the host clock with them: every refclockPoll the clock is
+// slewed, or stepped, by the median offset of the samples taken since the
+// last adjustment. While a reference clock has fresh samples the server
+// serves stratum 1, with the name of the clock as reference ID.
+//
+// The first reference clock given with fresh samples is used, except that a
+// PPS one wins while a non-PPS one puts the clock within
+// refclockPulseWindow: a pulse only tells where the second starts, the
+// other clock tells which second it is. A set of PPS clocks alone is
+// refused: nothing would number their seconds.
+//
+// The clients measure the reference clocks of the refclock lines of their
+// configuration, like the SHM segments gpsd writes, as stratum 0 servers:
+// their offset is the median of the samples of the last poll interval.
+
+const (
+	// refclockPoll is the time between two adjustments of the clock
+	refclockPoll = 16 * time.Second
+	// refclockMaxAge is the age past which samples are not used
+	refclockMaxAge = 4 * refclockPoll
+	// refclockSamples is how many samples of a clock are kept
+	refclockSamples = 64
+	// refclockPulseWindow is how close to the time the clock must be
+	// for PPS samples to apply
+	refclockPulseWindow = 400 * time.Millisecond
+)
+
+// refclockEstimate sums up the recent samples of a reference clock.
+type refclockEstimate struct {
+	offset time.Duration
+	spread time.Duration
+	leap   byte
+	// pulse marks the estimates of PPS clocks
+	pulse bool
+}
+
+// refclockSource keeps the recent samples of a reference clock.
+type refclockSource struct {
+	driver  refclock.Driver
+	refid   uint32
+	mu      sync.Mutex
+	samples []refclock.Sample
+}
+
+// newRefclockSource creates the source reading driver.
+func newRefclockSource(driver refclock.Driver) *refclockSource {
+	var refid [4]byte
+	copy(refid[:], driver.RefID())
+	return &refclockSource{driver: driver, refid: binary.BigEndian.Uint32(refid[:])}
+}
+
+// add records a sample.
+func (r *refclockSource) add(s refclock.Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.samples) == refclockSamples {
+		r.samples = r.samples[1:]
+	}
+	r.samples = append(r.samples, s)
+}
+
+// estimate returns the median offset of the samples taken after since, and
+// their spread.
+func (r *refclockSource) estimate(since time.Time) (refclockEstimate, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var offsets []time.Duration
+	var est refclockEstimate
+	for _, s := range r.samples {
+		if s.Time.After(since) {
+			offsets = append(offsets, s.Offset)
+			est.leap, est.pulse = s.Leap, s.Pulse
+		}
+	}
+	if len(offsets) == 0 {
+		return refclockEstimate{}, false
+	}
+	slices.Sort(offsets)
+	est.offset = offsets[len(offsets)/2]
+	est.spread = (offsets[len(offsets)-1] - offsets[0]) / 2
+	return est, true
+}
+
+// precision returns the precision of the clock as a duration.
+func (r *refclockSource) precision() time.Duration {
+	return time.Duration(math.Ldexp(float64(time.Second), int(r.driver.Precision())))
+}
+
+// run records the samples of the clock until its driver is closed. Errors
+// are logged once until they change, a receiver without a fix would
+// otherwise fill the log.
+func (r *refclockSource) run() {
+	var last string
+	for {
+		s, err := r.driver.Sample()
+		switch {
+		case err == nil:
+			r.add(s)
+			last = ""
+		case errors.Is(err, os.ErrClosed), errors.Is(err, io.EOF):
+			return
+		default:
+			if err.Error() != last {
+				_, _ = fmt.Printf("refclock %s: %v\n", r.driver.RefID(), err)
+				last = err.Error()
+			}
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// refclockSet holds the reference clocks of a server.
+type refclockSet struct {
+	sources []*refclockSource
+	adjust  func(offset time.Duration) (string, error)
+	// mu guards adjusted, the time of the last adjustment
+	mu       sync.Mutex
+	adjusted time.Time
+}
+
+// errPulseOnly is returned for reference clocks that are all PPS ones.
+var errPulseOnly = errors.New("a pps reference clock needs an nmea or shm one to number its seconds")
+
+// pulseOnly reports whether the specifications are all of PPS clocks.
+func pulseOnly(specs []string) bool {
+	for _, spec := range specs {
+		if name, _, _, _ := refclock.ParseSpec(spec); name != "pps" {
+			return false
+		}
+	}
+	return true
+}
+
+// openRefclocks opens the reference clocks of the -refclock specifications,
+// returning nil without any.
+func openRefclocks(specs []string) (*refclockSet, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	if pulseOnly(specs) {
+		return nil, errPulseOnly
+	}
+	rs := &refclockSet{adjust: func(offset time.Duration) (string, error) {
+		return adjustClock(offset, disciplineStep)
+	}}
+	for _, spec := range specs {
+		d, err := refclock.Open(spec)
+		if err != nil {
+			rs.close()
+			return nil, fmt.Errorf("refclock %s: %w", spec, err)
+		}
+		rs.sources = append(rs.sources, newRefclockSource(d))
+	}
+	return rs, nil
+}
+
+// close closes the drivers.
+func (rs *refclockSet) close() {
+	for _, r := range rs.sources {
+		_ = r.driver.Close()
+	}
+}
+
+// selected returns the reference clock to use and its estimate over the
+// samples taken after since, or nil.
+func (rs *refclockSet) selected(since time.Time) (*refclockSource, refclockEstimate) {
+	var coarse, pulse *refclockSource
+	var coarseEst, pulseEst refclockEstimate
+	for _, r := range rs.sources {
+		est, ok := r.estimate(since)
+		switch {
+		case !ok:
+		case est.pulse && pulse == nil:
+			pulse, pulseEst = r, est
+		case !est.pulse && coarse == nil:
+			coarse, coarseEst = r, est
+		}
+	}
+	if pulse != nil && coarse != nil && coarseEst.offset.Abs() < refclockPulseWindow {
+		return pulse, pulseEst
+	}
+	return coarse, coarseEst
+}
+
+// status returns the status to serve when the host clock has status st:
+// that of the selected reference clock, if any, else st.
+func (rs *refclockSet) status(st clockStatus) clockStatus {
+	if rs == nil {
+		return st
+	}
+	r, est := rs.selected(time.Now().Add(-refclockMaxAge))
+	if r == nil {
+		return st
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return clockStatus{
+		leap:      est.leap,
+		stratum:   1,
+		precision: r.driver.Precision(),
+		estError:  est.spread + r.precision(),
+		refid:     r.refid,
+		reftime:   rs.adjusted,
+	}
+}
+
+// lastAdjusted returns the time of the last adjustment.
+func (rs *refclockSet) lastAdjusted() time.Time {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.adjusted
+}
+
+// discipline adjusts the clock by the offset of the selected reference
+// clock over the samples taken since the last adjustment.
+func (rs *refclockSet) discipline(now time.Time) {
+	since := now.Add(-refclockMaxAge)
+	if last := rs.lastAdjusted(); last.After(since) {
+		since = last
+	}
+	r, est := rs.selected(since)
+	if r == nil {
+		return
+	}
+	action, err := rs.adjust(est.offset)
+	if err != nil {
+		_, _ = fmt.Printf("refclock %s: %v\n", r.driver.RefID(), err)
+		return
+	}
+	rs.mu.Lock()
+	rs.adjusted = now
+	rs.mu.Unlock()
+	_, _ = fmt.Printf("refclock %s: offset %v, %s\n", r.driver.RefID(), est.offset, action)
+}
+
+// run reads the reference clocks and disciplines the clock with them until
+// ctx is done.
+func (rs *refclockSet) run(ctx context.Context) {
+	if rs == nil {
+		return
+	}
+	for _, r := range rs.sources {
+		go r.run()
+	}
+	go func() {
+		ticker := time.NewTicker(refclockPoll)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				rs.discipline(now)
+			case <-ctx.Done():
+				rs.close()
+				return
+			}
+		}
+	}()
+}
+
+// refclockFlag defines the repeatable -refclock flag on fs.
+func refclockFlag(fs *flag.FlagSet, specs *[]string) {
+	fs.Func("refclock", "read a reference clock, driver:device[,option=value...] with driver nmea, pps or shm (repeatable)",
+		func(s string) error {
+			if _, _, _, err := refclock.ParseSpec(s); err != nil {
+				return err
+			}
+			*specs = append(*specs, s)
+			return nil
+		})
+}
+
+// startRefclocks opens the reference clocks of specs and has m serve their
+// status while ctx is not done.
+func startRefclocks(ctx context.Context, m *statusMonitor, specs []string) error {
+	rs, err := openRefclocks(specs)
+	if err != nil {
+		return err
+	}
+	m.refclocks = rs
+	rs.run(ctx)
+	return nil
+}
+
+// refclockWait is how long a client waits for the first sample of a
+// reference clock.
+const refclockWait = 3 * time.Second
+
+// errNoRefclockSample is returned for a reference clock without samples.
+var errNoRefclockSample = errors.New("no sample from the reference clock")
+
+// clientRefclocks are the reference clocks of a client, by specification.
+type clientRefclocks map[string]*refclockSource
+
+// openClientRefclocks opens the reference clocks among servers and starts
+// reading them.
+func openClientRefclocks(servers []serverConfig) (clientRefclocks, error) {
+	c := clientRefclocks{}
+	for _, s := range servers {
+		if s.proto != protoRefclock || c[s.host] != nil {
+			continue
+		}
+		d, err := refclock.Open(s.host)
+		if err != nil {
+			c.close()
+			return nil, fmt.Errorf("refclock %s: %w", s.host, err)
+		}
+		c[s.host] = newRefclockSource(d)
+		go c[s.host].run()
+	}
+	return c, nil
+}
+
+// close closes the drivers.
+func (c clientRefclocks) close() {
+	for _, r := range c {
+		_ = r.driver.Close()
+	}
+}
+
+// measure wraps measure, measuring the reference clocks over the samples
+// of the last window.
+func (c clientRefclocks) measure(measure measureFunc, window time.Duration) measureFunc {
+	return func(s serverConfig) (clientSample, error) {
+		r, ok := c[s.host]
+		if !ok || s.proto != protoRefclock {
+			return measure(s)
+		}
+		return r.clientSample(window)
+	}
+}
+
+// clientSample returns the median offset of the samples of the last window,
+// waiting up to refclockWait for one. A reference clock is stratum 0.
+func (r *refclockSource) clientSample(window time.Duration) (clientSample, error) {
+	deadline := time.Now().Add(refclockWait)
+	for {
+		est, ok := r.estimate(time.Now().Add(-window))
+		if ok {
+			return clientSample{
+				offset:     est.offset,
+				dispersion: est.spread + r.precision(),
+				leap:       int(est.leap),
+				unsynced:   est.leap == leapUnknown,
+			}, nil
+		}
+		if time.Now().After(deadline) {
+			return clientSample{}, errNoRefclockSample
+		}
+		time.Sleep(refclockWait / 30)
+	}
+}
@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karasz/gtclock/refclock"
+)
+
+// fakeDriver hands out the samples sent on its channel.
+type fakeDriver struct {
+	refid   string
+	samples chan refclock.Sample
+}
+
+func (d *fakeDriver) Sample() (refclock.Sample, error) {
+	s, ok := <-d.samples
+	if !ok {
+		return refclock.Sample{}, os.ErrClosed
+	}
+	return s, nil
+}
+
+func (d *fakeDriver) RefID() string { return d.refid }
+
+func (*fakeDriver) Precision() int8 { return -10 }
+
+func (d *fakeDriver) Close() error { return nil }
+
+// testRefclock returns a source of a clock named refid holding samples of
+// the given offsets, taken a second apart until now.
+func testRefclock(refid string, pulse bool, offsets ...time.Duration) *refclockSource {
+	r := newRefclockSource(&fakeDriver{refid: refid})
+	now := time.Now()
+	for i, offset := range offsets {
+		at := now.Add(time.Duration(i-len(offsets)+1) * time.Second)
+		r.add(refclock.Sample{Offset: offset, Time: at, Pulse: pulse})
+	}
+	return r
+}
+
+func TestRefclockEstimate(t *testing.T) {
+	r := testRefclock("GPS", false, 10*time.Millisecond, -30*time.Millisecond, 12*time.Millisecond, 50*time.Millisecond, 11*time.Millisecond)
+	est, ok := r.estimate(time.Now().Add(-time.Minute))
+	if !ok || est.offset != 11*time.Millisecond || est.spread != 40*time.Millisecond || est.pulse {
+		t.Errorf("estimate() = %+v, %v", est, ok)
+	}
+	if _, ok := r.estimate(time.Now()); ok {
+		t.Error("estimate() without fresh samples")
+	}
+	if r.refid != 0x47505300 || r.precision() != time.Second>>10 {
+		t.Errorf("refid = %#x, precision = %v", r.refid, r.precision())
+	}
+
+	for range refclockSamples {
+		r.add(refclock.Sample{Time: time.Now()})
+	}
+	if len(r.samples) != refclockSamples {
+		t.Errorf("kept %d samples", len(r.samples))
+	}
+}
+
+func TestRefclockSelected(t *testing.T) {
+	gpsNear := testRefclock("GPS", false, 100*time.Millisecond)
+	gpsFar := testRefclock("GPS", false, 2*time.Second)
+	pps := testRefclock("PPS", true, -3*time.Microsecond)
+	stale := newRefclockSource(&fakeDriver{refid: "GPS"})
+	tests := []struct {
+		name    string
+		sources []*refclockSource
+		want    *refclockSource
+	}{
+		{"none", nil, nil},
+		{"stale", []*refclockSource{stale}, nil},
+		{"first fresh", []*refclockSource{stale, gpsFar, gpsNear}, gpsFar},
+		{"pulse within the window", []*refclockSource{gpsNear, pps}, pps},
+		{"pulse outside the window", []*refclockSource{gpsFar, pps}, gpsFar},
+		{"pulse alone", []*refclockSource{pps}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := &refclockSet{sources: tt.sources}
+			if got, _ := rs.selected(time.Now().Add(-refclockMaxAge)); got != tt.want {
+				t.Errorf("selected() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefclockStatus(t *testing.T) {
+	m := fakeStatusMonitor(unsyncMark, 0, kernelClock{unsynced: true}, nil)
+	if st := m.current(); !st.unsynced {
+		t.Fatalf("current() = %+v", st)
+	}
+	m.refclocks = &refclockSet{sources: []*refclockSource{testRefclock("GPS", false, time.Millisecond, 3*time.Millisecond)}}
+	st := m.current()
+	want := clockStatus{stratum: 1, precision: -10, estError: time.Millisecond + time.Second>>10, refid: 0x47505300}
+	if st != want {
+		t.Errorf("current() = %+v, want %+v", st, want)
+	}
+	if !m.serving() {
+		t.Error("not serving with a reference clock")
+	}
+
+	s := &ntpServer{clock: m}
+	if status, peer := s.systemStatus(nil); status != ctlSourceUHF<<8 || peer != nil {
+		t.Errorf("systemStatus() = %04x, %v", status, peer)
+	}
+	if m := s.header(server, ntpVersion); m.Stratum != 1 || m.ReferenceID != 0x47505300 || m.ReferenceTime != 0 {
+		t.Errorf("header() = %+v", m)
+	}
+	adjusted := time.Now().Add(-time.Minute)
+	m.refclocks.adjusted = adjusted
+	if m := s.header(server, ntpVersion); m.ReferenceTime != encode(adjusted) {
+		t.Errorf("header() reference time = %v, want the last adjustment", m.ReferenceTime)
+	}
+}
+
+func TestRefclockDiscipline(t *testing.T) {
+	silence(t)
+	var adjusted []time.Duration
+	rs := &refclockSet{
+		sources: []*refclockSource{testRefclock("GPS", false, 4*time.Millisecond, 5*time.Millisecond, 6*time.Millisecond)},
+		adjust: func(offset time.Duration) (string, error) {
+			adjusted = append(adjusted, offset)
+			return "slew", nil
+		},
+	}
+	now := time.Now()
+	rs.discipline(now)
+	// The samples were taken before the adjustment
+	rs.discipline(now.Add(refclockPoll))
+	rs.sources[0].add(refclock.Sample{Offset: -time.Millisecond, Time: now.Add(time.Second)})
+	rs.discipline(now.Add(2 * refclockPoll))
+	if len(adjusted) != 2 || adjusted[0] != 5*time.Millisecond || adjusted[1] != -time.Millisecond {
+		t.Errorf("adjusted %v", adjusted)
+	}
+
+	rs.adjust = func(time.Duration) (string, error) { return "", errors.New("not permitted") }
+	rs.sources[0].add(refclock.Sample{Time: now.Add(3 * refclockPoll)})
+	rs.discipline(now.Add(3 * refclockPoll))
+	if !rs.adjusted.Equal(now.Add(2 * refclockPoll)) {
+		t.Errorf("adjusted at %v after a failure", rs.adjusted)
+	}
+}
+
+func TestRefclockRun(t *testing.T) {
+	silence(t)
+	d := &fakeDriver{refid: "GPS", samples: make(chan refclock.Sample)}
+	r := newRefclockSource(d)
+	done := make(chan struct{})
+	go func() {
+		r.run()
+		close(done)
+	}()
+	d.samples <- refclock.Sample{Offset: time.Millisecond, Time: time.Now()}
+	close(d.samples)
+	<-done
+	if est, ok := r.estimate(time.Now().Add(-time.Minute)); !ok || est.offset != time.Millisecond {
+		t.Errorf("estimate() = %+v, %v", est, ok)
+	}
+}
+
+func TestStartRefclocks(t *testing.T) {
+	silence(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := fakeStatusMonitor(unsyncMark, 0, kernelClock{}, nil)
+	if err := startRefclocks(ctx, m, nil); err != nil || m.refclocks != nil {
+		t.Errorf("startRefclocks(none) = %v, %+v", err, m.refclocks)
+	}
+	if err := startRefclocks(ctx, m, []string{"nmea:/nonexistent/ttyS0"}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("startRefclocks(missing device) = %v", err)
+	}
+	if err := startRefclocks(ctx, m, []string{"pps:/nonexistent/pps0"}); !errors.Is(err, errPulseOnly) {
+		t.Errorf("startRefclocks(pps alone) = %v, want %v", err, errPulseOnly)
+	}
+
+	// A file of canned sentences is read to its end
+	path := filepath.Join(t.TempDir(), "nmea")
+	writeFile(t, path, "$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A\r\n")
+	if err := startRefclocks(ctx, m, []string{"nmea:" + path}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := m.refclocks.sources[0].estimate(time.Now().Add(-time.Minute)); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no sample read")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := m.current(); st.stratum != 1 || refidString(st.refid, st.stratum) != "GPS" {
+		t.Errorf("current() = %+v", st)
+	}
+}
+
+func TestClientRefclocks(t *testing.T) {
+	gps := testRefclock("GPS", false, 2*time.Millisecond, 4*time.Millisecond)
+	c := clientRefclocks{"nmea:/dev/ttyS0": gps}
+	measured := c.measure(func(s serverConfig) (clientSample, error) {
+		return clientSample{offset: time.Second}, nil
+	}, time.Minute)
+
+	s, err := measured(serverConfig{host: "nmea:/dev/ttyS0", proto: protoRefclock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.offset != 4*time.Millisecond || s.dispersion != time.Millisecond+time.Second>>10 || s.stratum != 0 || !s.usable() {
+		t.Errorf("reference clock sample = %+v", s)
+	}
+	if s, _ := measured(serverConfig{host: "192.0.2.1", proto: protoTAI}); s.offset != time.Second {
+		t.Errorf("server sample = %+v", s)
+	}
+
+	gps.add(refclock.Sample{Time: time.Now(), Leap: leapUnknown})
+	if s, _ := gps.clientSample(time.Minute); s.usable() {
+		t.Errorf("sample of a clock without a fix = %+v", s)
+	}
+}
+
+func TestClientRefclockNoSample(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the reference clock")
+	}
+	r := testRefclock("GPS", false)
+	if _, err := r.clientSample(time.Minute); !errors.Is(err, errNoRefclockSample) {
+		t.Errorf("clientSample() = %v", err)
+	}
+}
+
+func TestClientWithRefclock(t *testing.T) {
+	dir := t.TempDir()
+	nmea := filepath.Join(dir, "nmea")
+	writeFile(t, nmea, "$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A\r\n")
+	cfg := defaultClientConfig()
+	cfg.servers = []serverConfig{{host: "nmea:" + nmea, proto: protoRefclock, weight: 1}}
+
+	refclocks, err := openClientRefclocks(cfg.servers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer refclocks.close()
+	var out bytes.Buffer
+	run := newClientRun(cfg, refclocks.measure(nil, cfg.poll), false, &out)
+	if code := run.once(); code != 0 {
+		t.Errorf("once() = %d, output %q", code, out.String())
+	}
+	if !strings.Contains(out.String(), "server: nmea:"+nmea) || !strings.Contains(out.String(), "action: none") {
+		t.Errorf("output %q", out.String())
+	}
+
+	silence(t)
+	conf := filepath.Join(dir, "client.conf")
+	writeFile(t, conf, "refclock nmea:"+filepath.Join(dir, "missing")+"\n")
+	if code := GTClockCRun([]string{"-config", conf}); code != 111 {
+		t.Errorf("GTClockCRun() with a missing device = %d", code)
+	}
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// strftimeLayouts maps the strftime conversions that have a Go layout
+// equivalent.
+var strftimeLayouts = map[byte]string{
+	'a': "Mon",
+	'A': "Monday",
+	'b': "Jan",
+	'B': "January",
+	'd': "02",
+	'D': "01/02/06",
+	'e': "_2",
+	'F': "2006-01-02",
+	'h': "Jan",
+	'H': "15",
+	'I': "03",
+	'j': "002",
+	'm': "01",
+	'M': "04",
+	'p': "PM",
+	'R': "15:04",
+	'S': "05",
+	'T': "15:04:05",
+	'y': "06",
+	'Y': "2006",
+	'z': "-0700",
+	'Z': "MST",
+}
+
+// strftimeLiterals maps the conversions that stand for fixed text.
+var strftimeLiterals = map[byte]string{
+	'%': "%",
+	'n': "\n",
+	't': "\t",
+}
+
+// compileStrftime turns a strftime layout into an append function. Besides
+// the usual conversions it knows %s (Unix seconds) and %N (nanoseconds),
+// which takes a digit count as in GNU date's %3N.
+func compileStrftime(layout string) (appendFunc, error) {
+	var parts []appendFunc
+	literal := []byte{}
+	flush := func() {
+		if len(literal) > 0 {
+			parts = append(parts, literalAppender(string(literal)))
+			literal = literal[:0]
+		}
+	}
+
+	for i := 0; i < len(layout); i++ {
+		if layout[i] != '%' {
+			literal = append(literal, layout[i])
+			continue
+		}
+		part, next, err := strftimeConversion(layout, i+1)
+		if err != nil {
+			return nil, err
+		}
+		i = next
+		flush()
+		parts = append(parts, part)
+	}
+	flush()
+
+	return func(dst []byte, t time.Time) []byte {
+		for _, p := range parts {
+			dst = p(dst, t)
+		}
+		return dst
+	}, nil
+}
+
+// strftimeConversion decodes the conversion starting at layout[i], just
+// after a '%'. It returns its append function and the index of the last
+// byte used. Only %N takes a digit count.
+func strftimeConversion(layout string, i int) (appendFunc, int, error) {
+	start, digits := i, 9
+	if i < len(layout) && layout[i] >= '1' && layout[i] <= '9' {
+		digits = int(layout[i] - '0')
+		i++
+	}
+	if i >= len(layout) {
+		return nil, i, fmt.Errorf("incomplete conversion at end of %q", layout)
+	}
+
+	c := layout[i]
+	if i > start && c != 'N' {
+		return nil, i, fmt.Errorf("unknown conversion %%%s", layout[start:i+1])
+	}
+	if l, ok := strftimeLayouts[c]; ok {
+		return layoutAppender(l), i, nil
+	}
+	if text, ok := strftimeLiterals[c]; ok {
+		return literalAppender(text), i, nil
+	}
+	switch c {
+	case 's':
+		return func(dst []byte, t time.Time) []byte { return strconv.AppendInt(dst, t.Unix(), 10) }, i, nil
+	case 'N':
+		return func(dst []byte, t time.Time) []byte { return appendFraction(dst, t.Nanosecond(), digits) }, i, nil
+	default:
+		return nil, i, fmt.Errorf("unknown conversion %%%c", c)
+	}
+}
+
+// literalAppender returns an append function for fixed text.
+func literalAppender(text string) appendFunc {
+	return func(dst []byte, _ time.Time) []byte { return append(dst, text...) }
+}
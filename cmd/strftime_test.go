@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompileStrftime(t *testing.T) {
+	tm := time.Date(2024, time.March, 5, 14, 7, 9, 123456789, time.UTC)
+	tests := []struct {
+		layout  string
+		want    string
+		wantErr bool
+	}{
+		{"%Y-%m-%d %H:%M:%S", "2024-03-05 14:07:09", false},
+		{"%a %b %e %I:%M %p", "Tue Mar  5 02:07 PM", false},
+		{"%A %B %j", "Tuesday March 065", false},
+		{"%s.%N", "1709647629.123456789", false},
+		{"%s.%3N", "1709647629.123", false},
+		{"100%% at%t%D", "100% at\t03/05/24", false},
+		{"literal 2006 text", "literal 2006 text", false},
+		{"%Q", "", true},
+		{"%3Y", "", true},
+		{"%2%", "", true},
+		{"trailing %", "", true},
+	}
+
+	for _, tt := range tests {
+		fn, err := compileStrftime(tt.layout)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("compileStrftime(%q) error = %v, wantErr %v", tt.layout, err, tt.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if got := string(fn(nil, tm)); got != tt.want {
+			t.Errorf("compileStrftime(%q) = %q, want %q", tt.layout, got, tt.want)
+		}
+	}
+}
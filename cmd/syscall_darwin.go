@@ -19,3 +19,9 @@ func setSystemClock(offset time.Duration) error {
 	t := time.Now().Add(offset)
 	return setSystemClockTime(t)
 }
+
+// slewSystemClock gradually corrects the clock by offset on Darwin.
+func slewSystemClock(offset time.Duration) error {
+	tv := syscall.NsecToTimeval(offset.Nanoseconds())
+	return syscall.Adjtime(&tv, nil)
+}
@@ -5,6 +5,8 @@ package cmd
 import (
 	"syscall"
 	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 // setSystemClockTime sets the system clock to the specified time on Linux.
@@ -19,3 +21,18 @@ func setSystemClock(offset time.Duration) error {
 	t := time.Now().Add(offset)
 	return setSystemClockTime(t)
 }
+
+// slewSystemClock gradually corrects the clock by offset on Linux, like
+// adjtime(3).
+func slewSystemClock(offset time.Duration) error {
+	tx := unix.Timex{Modes: unix.ADJ_OFFSET_SINGLESHOT}
+	setTimexField(&tx.Offset, offset.Microseconds())
+	_, err := unix.Adjtimex(&tx)
+	return err
+}
+
+// setTimexField sets a timex field, whose width depends on the
+// architecture.
+func setTimexField[T int32 | int64](field *T, v int64) {
+	*field = T(v)
+}
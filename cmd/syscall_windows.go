@@ -93,3 +93,9 @@ func setSystemClock(offset time.Duration) error {
 	t := time.Now().Add(offset)
 	return setSystemClockTime(t)
 }
+
+// slewSystemClock corrects the clock by offset on Windows, where
+// setSystemClockTime already adjusts gradually when it can.
+func slewSystemClock(offset time.Duration) error {
+	return setSystemClock(offset)
+}
@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/karasz/glibtai"
+)
+
+// Extended TAICLOCK Protocol:
+//
+// A client that sends a request of at least 48 bytes asks for the extended
+// response. The request keeps the plain layout, so servers that predate the
+// extension simply answer with the usual single timestamp and leave the
+// version byte at zero, which lets clients fall back.
+//
+// Extended Request Format (48 bytes):
+//   Bytes 0-3:   Magic bytes "ctai"
+//   Bytes 4-15:  Client transmit time (TAI64N), informational
+//   Bytes 16-19: Zero
+//   Bytes 20-27: Client nonce, echoed by the server
+//   Bytes 28-47: Zero (reserved, keeps the request as long as the response)
+//
+// Extended Response Format (48 bytes):
+//   Byte  0:     Response marker "s" (0x73)
+//   Bytes 1-3:   Copied from request
+//   Bytes 4-15:  Server transmit time (TAI64N), same place as the plain response
+//   Bytes 16-27: Copied from request (nonce in 20-27)
+//   Bytes 28-39: Server receive time (TAI64N)
+//   Byte  40:    Extension version (1)
+//   Byte  41:    Leap indicator (bits 7-6, NTP encoding) and status flags
+//                (bit 0: server clock unsynchronized)
+//   Byte  42:    Stratum (NTP meaning, 16 = unsynchronized)
+//   Byte  43:    Precision (signed log2 seconds)
+//   Bytes 44-47: Estimated error of the server clock in microseconds
+//                (big-endian, 0 when unknown)
+//
+// With the client transmit (t1) and receive (t4) times the client computes
+// offset = ((t2 - t1) + (t3 - t4)) / 2 and delay = (t4 - t1) - (t3 - t2).
+
+const (
+	tainExtPacket  = 48
+	tainExtVersion = 1
+
+	extNonceOffset   = 20
+	extReceiveOffset = 28
+	extVersionOffset = 40
+	extFlagsOffset   = 41
+	extStratumOffset = 42
+	extPrecOffset    = 43
+	extErrorOffset   = 44
+
+	extFlagUnsynced = 0x01
+
+	stratumUnsynchronized = 16
+)
+
+// Leap indicator values, as in NTP.
+const (
+	leapNone byte = iota
+	leapInsert
+	leapDelete
+	leapUnknown
+)
+
+// clockStatus describes the state of the clock a server hands out.
+type clockStatus struct {
+	leap      byte
+	stratum   byte
+	precision int8
+	unsynced  bool
+	estError  time.Duration
+	// refid is the NTP reference ID of the source, 0 for the host clock
+	refid uint32
+	// reftime is when the server last adjusted the clock, zero when it
+	// does not know, as when another program disciplines it
+	reftime time.Time
+}
+
+// extResponse is the decoded extended part of a TAICLOCK response.
+type extResponse struct {
+	transmit glibtai.TAIN
+	receive  glibtai.TAIN
+	status   clockStatus
+}
+
+// packExtended fills the extended fields of a response buffer.
+func packExtended(buf []byte, receive glibtai.TAIN, st clockStatus) {
+	copy(buf[extReceiveOffset:extReceiveOffset+glibtai.TAINLength], glibtai.TAINPack(receive))
+	buf[extVersionOffset] = tainExtVersion
+	flags := st.leap << 6
+	if st.unsynced {
+		flags |= extFlagUnsynced
+	}
+	buf[extFlagsOffset] = flags
+	buf[extStratumOffset] = st.stratum
+	buf[extPrecOffset] = byte(st.precision)
+	binary.BigEndian.PutUint32(buf[extErrorOffset:], durationMicros(st.estError))
+}
+
+// unpackExtended decodes an extended response. ok is false for plain
+// responses, including those of servers without the extension.
+func unpackExtended(resp []byte) (r extResponse, ok bool) {
+	if len(resp) < tainExtPacket || resp[extVersionOffset] != tainExtVersion {
+		return extResponse{}, false
+	}
+	flags := resp[extFlagsOffset]
+	r.transmit = glibtai.TAINUnpack(resp[4:16])
+	r.receive = glibtai.TAINUnpack(resp[extReceiveOffset : extReceiveOffset+glibtai.TAINLength])
+	r.status = clockStatus{
+		leap:      flags >> 6,
+		stratum:   resp[extStratumOffset],
+		precision: int8(resp[extPrecOffset]),
+		unsynced:  flags&extFlagUnsynced != 0,
+		estError:  time.Duration(binary.BigEndian.Uint32(resp[extErrorOffset:])) * time.Microsecond,
+	}
+	return r, true
+}
+
+// durationMicros converts d to microseconds, saturating at the field size.
+func durationMicros(d time.Duration) uint32 {
+	us := d.Microseconds()
+	switch {
+	case us < 0:
+		return 0
+	case us > math.MaxUint32:
+		return math.MaxUint32
+	default:
+		return uint32(us)
+	}
+}
+
+// tainDiff returns a - b. Unlike glibtai.TAINSub it handles a < b.
+func tainDiff(a, b glibtai.TAIN) time.Duration {
+	pa, pb := glibtai.TAINPack(a), glibtai.TAINPack(b)
+	sec := int64(binary.BigEndian.Uint64(pa) - binary.BigEndian.Uint64(pb))
+	nano := int64(binary.BigEndian.Uint32(pa[8:])) - int64(binary.BigEndian.Uint32(pb[8:]))
+	return time.Duration(sec)*time.Second + time.Duration(nano)
+}
+
+// tainToTime converts n to UTC. glibtai.TAINTime picks the leap second
+// offset for the TAI time itself, which is a second off for the first
+// seconds after a leap second, so the result is corrected against
+// glibtai.TAINfromTime. leap reports that n falls inside an inserted leap
+// second, which is returned as the second before it.
+func tainToTime(n glibtai.TAIN) (t time.Time, leap bool) {
+	t = glibtai.TAINTime(n)
+	for i := 0; i < 2; i++ {
+		d := tainDiff(n, glibtai.TAINfromTime(t))
+		if d == 0 {
+			return t, false
+		}
+		if d == time.Second && isLeapSecond(t) {
+			return t, true
+		}
+		t = t.Add(d)
+	}
+	return t, false
+}
+
+// isLeapSecond reports whether a leap second follows the second of t.
+func isLeapSecond(t time.Time) bool {
+	next := t.Add(time.Second)
+	return tainDiff(glibtai.TAINfromTime(next), glibtai.TAINfromTime(t)) == 2*time.Second
+}
+
+// exchangeParams returns the clock offset and round trip delay of an
+// exchange sent at t1 and answered at t4.
+func exchangeParams(t1 glibtai.TAIN, r extResponse, t4 glibtai.TAIN) (offset, delay time.Duration) {
+	offset = (tainDiff(r.receive, t1) + tainDiff(r.transmit, t4)) / 2
+	delay = tainDiff(t4, t1) - tainDiff(r.transmit, r.receive)
+	return offset, delay
+}
+
+// precisionOf returns the log2 of d in seconds, rounded up, as NTP does.
+func precisionOf(d time.Duration) int8 {
+	if d <= 0 {
+		d = time.Nanosecond
+	}
+	return int8(math.Ceil(math.Log2(d.Seconds())))
+}
+
+// measureClockTick returns the smallest observed step of the system clock.
+func measureClockTick() time.Duration {
+	tick := time.Second
+	for i := 0; i < 16; i++ {
+		t0 := time.Now()
+		t1 := time.Now()
+		for t1.Equal(t0) {
+			t1 = time.Now()
+		}
+		if d := t1.Sub(t0); d < tick {
+			tick = d
+		}
+	}
+	return tick
+}
+
+// clockPrecision is the precision of the system clock, measured once.
+var clockPrecision = sync.OnceValue(func() int8 {
+	return precisionOf(measureClockTick())
+})
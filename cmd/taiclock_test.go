@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/karasz/glibtai"
+)
+
+func TestPackUnpackExtended(t *testing.T) {
+	buf := make([]byte, tainExtPacket)
+	copy(buf, "stai")
+	transmit := glibtai.TAINNow()
+	receive := glibtai.TAINAdd(transmit, -250*time.Microsecond)
+	copy(buf[4:16], glibtai.TAINPack(transmit))
+
+	st := clockStatus{
+		leap:      leapInsert,
+		stratum:   3,
+		precision: -20,
+		unsynced:  true,
+		estError:  1500 * time.Microsecond,
+	}
+	packExtended(buf, receive, st)
+
+	r, ok := unpackExtended(buf)
+	if !ok {
+		t.Fatal("unpackExtended() did not recognise an extended response")
+	}
+	if r.transmit != transmit || r.receive != receive {
+		t.Errorf("timestamps = %v/%v, want %v/%v", r.transmit, r.receive, transmit, receive)
+	}
+	if r.status != st {
+		t.Errorf("status = %+v, want %+v", r.status, st)
+	}
+}
+
+func TestUnpackExtendedPlainResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		resp []byte
+	}{
+		{"plain response", make([]byte, tainPacket)},
+		{"old server echoing a long request", make([]byte, tainExtPacket)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := unpackExtended(tt.resp); ok {
+				t.Error("unpackExtended() accepted a plain response")
+			}
+		})
+	}
+}
+
+func TestTAINDiff(t *testing.T) {
+	base := glibtai.TAINNow()
+	tests := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"zero", 0},
+		{"positive", 1500 * time.Millisecond},
+		{"negative", -1500 * time.Millisecond},
+		{"nanoseconds", 7},
+		{"negative nanoseconds", -7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			later := glibtai.TAINAdd(base, tt.d)
+			if got := tainDiff(later, base); got != tt.d {
+				t.Errorf("tainDiff() = %v, want %v", got, tt.d)
+			}
+		})
+	}
+}
+
+func TestExchangeParams(t *testing.T) {
+	// Server clock 100ms ahead, 10ms each way, 1ms in the server
+	t1 := glibtai.TAINNow()
+	r := extResponse{
+		receive:  glibtai.TAINAdd(t1, 110*time.Millisecond),
+		transmit: glibtai.TAINAdd(t1, 111*time.Millisecond),
+	}
+	t4 := glibtai.TAINAdd(t1, 21*time.Millisecond)
+
+	offset, delay := exchangeParams(t1, r, t4)
+	if offset != 100*time.Millisecond {
+		t.Errorf("offset = %v, want 100ms", offset)
+	}
+	if delay != 20*time.Millisecond {
+		t.Errorf("delay = %v, want 20ms", delay)
+	}
+}
+
+func TestPrecisionOf(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want int8
+	}{
+		{time.Second, 0},
+		{time.Microsecond, -19},
+		{time.Nanosecond, -29},
+		{0, -29},
+		{10 * time.Millisecond, -6},
+	}
+
+	for _, tt := range tests {
+		if got := precisionOf(tt.d); got != tt.want {
+			t.Errorf("precisionOf(%v) = %d, want %d", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestTAINToTimeAroundLeapSecond(t *testing.T) {
+	tests := []struct {
+		label string
+		want  time.Time
+		leap  bool
+	}{
+		{"@40000000586846ad00000000", time.Date(2016, 12, 31, 23, 59, 59, 0, time.UTC), false},
+		{"@40000000586846ae00000000", time.Date(2016, 12, 31, 23, 59, 59, 0, time.UTC), true},
+		{"@40000000586846af00000000", time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"@40000000586846b000000000", time.Date(2017, 1, 1, 0, 0, 1, 0, time.UTC), false},
+		{"@40000000586846d200000000", time.Date(2017, 1, 1, 0, 0, 35, 0, time.UTC), false},
+	}
+
+	for _, tt := range tests {
+		n, err := glibtai.TAINfromString(tt.label)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, leap := tainToTime(n)
+		if !got.Equal(tt.want) || leap != tt.leap {
+			t.Errorf("tainToTime(%s) = %v, %v; want %v, %v", tt.label, got, leap, tt.want, tt.leap)
+		}
+	}
+}
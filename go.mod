@@ -2,4 +2,8 @@ module github.com/karasz/gtclock
 
 go 1.24.6
 
-require github.com/karasz/glibtai v0.2.0
+require (
+	github.com/karasz/glibtai v0.2.0
+	golang.org/x/net v0.50.0
+	golang.org/x/sys v0.41.0
+)
@@ -0,0 +1,159 @@
+package gtudpd
+
+import (
+	"context"
+	"net"
+	"runtime"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// ResponseWriter sends a datagram back to a client. *net.UDPConn satisfies it,
+// so handlers can be exercised directly against a plain socket in tests.
+type ResponseWriter interface {
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+}
+
+// batchConn is the subset of ipv4.PacketConn and ipv6.PacketConn used for
+// batched I/O. Both packages share the same Message type.
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// singleConn adapts a *net.UDPConn to batchConn one datagram at a time,
+// for platforms where x/net does not implement batched I/O.
+type singleConn struct {
+	conn *net.UDPConn
+}
+
+// ReadBatch reads a single datagram into the first message.
+func (c singleConn) ReadBatch(ms []ipv4.Message, _ int) (int, error) {
+	n, addr, err := c.conn.ReadFromUDP(ms[0].Buffers[0])
+	if err != nil {
+		return 0, err
+	}
+	ms[0].N, ms[0].NN, ms[0].Addr = n, 0, addr
+	return 1, nil
+}
+
+// WriteBatch writes the first message only.
+func (c singleConn) WriteBatch(ms []ipv4.Message, _ int) (int, error) {
+	addr, _ := ms[0].Addr.(*net.UDPAddr)
+	if _, err := c.conn.WriteToUDP(ms[0].Buffers[0], addr); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// newBatchConn wraps conn with the x/net PacketConn matching its address family.
+func newBatchConn(conn *net.UDPConn) batchConn {
+	if runtime.GOOS == "windows" {
+		return singleConn{conn: conn}
+	}
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		return ipv6.NewPacketConn(conn)
+	}
+	return ipv4.NewPacketConn(conn)
+}
+
+// listener is one socket of the server together with its batched reader
+// state and response writer.
+type listener struct {
+	conn   *net.UDPConn
+	pc     batchConn
+	writer *batchWriter
+	msgs   []ipv4.Message
+	tsMode TimestampMode
+}
+
+// newListener prepares the receive batch and response writer for conn.
+func newListener(conn *net.UDPConn, config *Config) *listener {
+	pc := newBatchConn(conn)
+	tsMode := enableTimestamps(conn, config.Timestamping)
+	msgs := make([]ipv4.Message, config.BatchSize)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{make([]byte, config.MaxRequestSize)}
+		if tsMode != TimestampUserspace {
+			msgs[i].OOB = make([]byte, timestampOOBSize)
+		}
+	}
+	return &listener{
+		conn:   conn,
+		pc:     pc,
+		writer: newBatchWriter(pc, config.BatchSize),
+		msgs:   msgs,
+		tsMode: tsMode,
+	}
+}
+
+// batchWriter queues responses and sends them with WriteBatch from a
+// dedicated goroutine, coalescing whatever accumulated since the last call.
+type batchWriter struct {
+	pc    batchConn
+	queue chan ipv4.Message
+	batch []ipv4.Message
+}
+
+// newBatchWriter creates a writer flushing up to size messages per call.
+func newBatchWriter(pc batchConn, size int) *batchWriter {
+	return &batchWriter{
+		pc:    pc,
+		queue: make(chan ipv4.Message, size*4),
+		batch: make([]ipv4.Message, 0, size),
+	}
+}
+
+// WriteToUDP queues a copy of b for addr. Responses are dropped when the
+// queue is full, as UDP is best-effort anyway.
+func (w *batchWriter) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	buf := make([]byte, len(b))
+	copy(buf, b)
+	select {
+	case w.queue <- ipv4.Message{Buffers: [][]byte{buf}, Addr: addr}:
+	default:
+	}
+	return len(b), nil
+}
+
+// collect blocks for one message and then drains the queue without blocking
+// until the batch is full.
+func (w *batchWriter) collect(ctx context.Context) bool {
+	w.batch = w.batch[:0]
+	select {
+	case m := <-w.queue:
+		w.batch = append(w.batch, m)
+	case <-ctx.Done():
+		return false
+	}
+	for len(w.batch) < cap(w.batch) {
+		select {
+		case m := <-w.queue:
+			w.batch = append(w.batch, m)
+		default:
+			return true
+		}
+	}
+	return true
+}
+
+// flush sends the collected batch, retrying partial writes.
+func (w *batchWriter) flush() {
+	pending := w.batch
+	for len(pending) > 0 {
+		n, err := w.pc.WriteBatch(pending, 0)
+		if err != nil || n == 0 {
+			// Skip the offending datagram and carry on with the rest
+			n = 1
+		}
+		pending = pending[n:]
+	}
+}
+
+// run sends queued responses until ctx is cancelled.
+func (w *batchWriter) run(ctx context.Context) {
+	for w.collect(ctx) {
+		w.flush()
+	}
+}
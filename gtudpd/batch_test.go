@@ -0,0 +1,253 @@
+package gtudpd
+
+//revive:disable:cognitive-complexity
+//revive:disable:function-length
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// startTestServer starts a server on an ephemeral loopback port
+func startTestServer(t testing.TB, config *Config) *Server {
+	t.Helper()
+	server, err := NewServer(config, testHandler, testValidator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Start()
+	return server
+}
+
+// dialServer opens a client socket connected to the server's port on loopback
+func dialServer(t testing.TB, server *Server) *net.UDPConn {
+	t.Helper()
+	port := server.Addr().(*net.UDPAddr).Port
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestServerEchoReusePort(t *testing.T) {
+	config := &Config{
+		DefaultPort: ":0",
+		Sockets:     4,
+		BatchSize:   8,
+	}
+	server := startTestServer(t, config)
+	defer func() { _ = server.Stop() }()
+
+	if reusePortSupported && len(server.listeners) != 4 {
+		t.Fatalf("expected 4 sockets, got %d", len(server.listeners))
+	}
+	for _, l := range server.listeners {
+		if l.conn.LocalAddr().(*net.UDPAddr).Port != server.Addr().(*net.UDPAddr).Port {
+			t.Errorf("socket bound to %v, want port of %v", l.conn.LocalAddr(), server.Addr())
+		}
+	}
+
+	// Several clients so the kernel spreads them across the sockets
+	for c := 0; c < 8; c++ {
+		conn := dialServer(t, server)
+		msg := []byte("ping-request")
+		if _, err := conn.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		reply := make([]byte, 64)
+		n, err := conn.Read(reply)
+		if err != nil {
+			t.Fatalf("client %d: %v", c, err)
+		}
+		if !bytes.Equal(reply[:n], msg) {
+			t.Errorf("client %d: got %q, want %q", c, reply[:n], msg)
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestServerStopUnblocksStart(t *testing.T) {
+	server, err := NewServer(&Config{DefaultPort: ":0", Sockets: 2}, testHandler, testValidator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		server.Start()
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	_ = server.Stop()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Start did not return after Stop")
+	}
+}
+
+// recordingConn is a batchConn recording the messages written to it
+type recordingConn struct {
+	mu      sync.Mutex
+	batches []int
+	written [][]byte
+}
+
+func (*recordingConn) ReadBatch(_ []ipv4.Message, _ int) (int, error) {
+	return 0, net.ErrClosed
+}
+
+func (c *recordingConn) WriteBatch(ms []ipv4.Message, _ int) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.batches = append(c.batches, len(ms))
+	for _, m := range ms {
+		c.written = append(c.written, m.Buffers[0])
+	}
+	return len(ms), nil
+}
+
+func TestBatchWriterCoalesces(t *testing.T) {
+	rc := &recordingConn{}
+	w := newBatchWriter(rc, 4)
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+
+	payload := []byte("abc")
+	for i := 0; i < 6; i++ {
+		if n, err := w.WriteToUDP(payload, addr); n != len(payload) || err != nil {
+			t.Fatalf("WriteToUDP() = %d, %v", n, err)
+		}
+	}
+	// The writer must own its copy of the payload
+	payload[0] = 'x'
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for w.collect(ctx) {
+		w.flush()
+		if len(w.queue) == 0 {
+			break
+		}
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if len(rc.written) != 6 {
+		t.Fatalf("expected 6 datagrams written, got %d", len(rc.written))
+	}
+	if rc.batches[0] != 4 {
+		t.Errorf("first batch = %d messages, want 4", rc.batches[0])
+	}
+	for _, b := range rc.written {
+		if string(b) != "abc" {
+			t.Errorf("written payload %q, want %q", b, "abc")
+		}
+	}
+}
+
+// BenchmarkServerLoopback measures request/response throughput on loopback.
+// Every client keeps a window of requests in flight; the result is reported
+// as replies per second.
+func BenchmarkServerLoopback(b *testing.B) {
+	const (
+		clients = 8
+		window  = 32
+	)
+	config := &Config{
+		DefaultPort:      ":0",
+		Sockets:          4,
+		MaxRequestsPerIP: 1 << 30,
+	}
+	server := startTestServer(b, config)
+	defer func() { _ = server.Stop() }()
+
+	var received atomic.Int64
+	var wg sync.WaitGroup
+	perClient := b.N/clients + 1
+
+	b.ResetTimer()
+	start := time.Now()
+	for c := 0; c < clients; c++ {
+		conn := dialServer(b, server)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { _ = conn.Close() }()
+			received.Add(int64(runLoopbackClient(conn, perClient, window)))
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	b.StopTimer()
+
+	b.ReportMetric(float64(received.Load())/elapsed.Seconds(), "pkts/s")
+	b.ReportMetric(float64(int64(clients*perClient)-received.Load()), "lost")
+}
+
+// runLoopbackClient sends count requests keeping up to window outstanding
+// and returns the number of replies received.
+func runLoopbackClient(conn *net.UDPConn, count, window int) int {
+	req := []byte("bench-request-payload")
+	reply := make([]byte, 64)
+	sent, answered, outstanding := 0, 0, 0
+	for sent < count || outstanding > 0 {
+		for sent < count && outstanding < window {
+			if _, err := conn.Write(req); err != nil {
+				return answered
+			}
+			sent++
+			outstanding++
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		if _, err := conn.Read(reply); err != nil {
+			// Give up on the replies still in flight
+			outstanding = 0
+			continue
+		}
+		answered++
+		outstanding--
+	}
+	return answered
+}
+
+func TestHandlerReceivesReceiveTime(t *testing.T) {
+	stamps := make(chan time.Time, 1)
+	handler := func(conn ResponseWriter, n int, remoteaddr *net.UDPAddr, buf []byte, received time.Time) {
+		stamps <- received
+		_, _ = conn.WriteToUDP(buf[:n], remoteaddr)
+	}
+	server, err := NewServer(&Config{DefaultPort: ":0"}, handler, testValidator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Start()
+	defer func() { _ = server.Stop() }()
+
+	conn := dialServer(t, server)
+	defer func() { _ = conn.Close() }()
+
+	before := time.Now()
+	if _, err := conn.Write([]byte("stamp-me")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case received := <-stamps:
+		// Allow for the kernel clock being read slightly before ours
+		if received.Before(before.Add(-time.Millisecond)) || received.After(time.Now()) {
+			t.Errorf("receive time %v outside [%v, now]", received, before)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler was not called")
+	}
+}
@@ -0,0 +1,169 @@
+package gtudpd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// DefaultBroadcastInterval is the default time between two broadcasts
+	DefaultBroadcastInterval = 64 * time.Second
+	// DefaultBroadcastTTL is the default TTL, or hop limit, of broadcasts,
+	// which keeps them on the local network
+	DefaultBroadcastTTL = 1
+)
+
+// PacketSource builds the datagram to broadcast, right before it is sent.
+// Returning nil skips the broadcast.
+type PacketSource func() []byte
+
+// BroadcastConfig holds the destination and pace of a Broadcaster
+type BroadcastConfig struct {
+	// Group is the broadcast or multicast address, with its port.
+	Group string
+	// Interface is the interface multicasts leave from, the system's
+	// choice when empty.
+	Interface string
+	// Interval is the time between two broadcasts.
+	Interval time.Duration
+	// TTL is the time to live, or hop limit, of the broadcasts.
+	TTL int
+}
+
+// Broadcaster periodically sends a datagram to a broadcast or multicast
+// group
+type Broadcaster struct {
+	conn     *net.UDPConn
+	group    *net.UDPAddr
+	interval time.Duration
+	packet   PacketSource
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// setBroadcastDefaults initializes BroadcastConfig fields with default values if they are zero
+func setBroadcastDefaults(config *BroadcastConfig) {
+	if config.Interval <= 0 {
+		config.Interval = DefaultBroadcastInterval
+	}
+	if config.TTL <= 0 {
+		config.TTL = DefaultBroadcastTTL
+	}
+}
+
+// setHops sets the TTL, or hop limit, of the datagrams conn sends to
+// group, and the interface multicasts leave from.
+func setHops(conn *net.UDPConn, group net.IP, ifi *net.Interface, ttl int) error {
+	if group.To4() == nil {
+		pc := ipv6.NewPacketConn(conn)
+		if err := pc.SetMulticastHopLimit(ttl); err != nil {
+			return err
+		}
+		if ifi == nil {
+			return nil
+		}
+		return pc.SetMulticastInterface(ifi)
+	}
+	pc := ipv4.NewPacketConn(conn)
+	if !group.IsMulticast() {
+		return pc.SetTTL(ttl)
+	}
+	if err := pc.SetMulticastTTL(ttl); err != nil {
+		return err
+	}
+	if ifi == nil {
+		return nil
+	}
+	return pc.SetMulticastInterface(ifi)
+}
+
+// openBroadcast opens the socket sending to config.Group.
+func openBroadcast(config *BroadcastConfig) (*net.UDPConn, *net.UDPAddr, error) {
+	group, err := net.ResolveUDPAddr("udp", config.Group)
+	if err != nil {
+		return nil, nil, err
+	}
+	var ifi *net.Interface
+	if config.Interface != "" {
+		if ifi, err = net.InterfaceByName(config.Interface); err != nil {
+			return nil, nil, err
+		}
+	}
+	network := "udp6"
+	if group.IP.To4() != nil {
+		network = "udp4"
+	}
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := setHops(conn, group.IP, ifi, config.TTL); err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("setting the TTL of %s: %w", config.Group, err)
+	}
+	return conn, group, nil
+}
+
+// NewBroadcaster creates a broadcaster sending what packet builds to
+// config.Group
+func NewBroadcaster(config *BroadcastConfig, packet PacketSource) (*Broadcaster, error) {
+	setBroadcastDefaults(config)
+	conn, group, err := openBroadcast(config)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Broadcaster{
+		conn:     conn,
+		group:    group,
+		interval: config.Interval,
+		packet:   packet,
+		ctx:      ctx,
+		cancel:   cancel,
+	}, nil
+}
+
+// send broadcasts one datagram, when the packet source has one.
+func (b *Broadcaster) send() {
+	if p := b.packet(); p != nil {
+		// Ignore errors, the next broadcast may get through
+		_, _ = b.conn.WriteToUDP(p, b.group)
+	}
+}
+
+// Start broadcasts right away and then every interval. It blocks until
+// Stop is called.
+func (b *Broadcaster) Start() {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	b.send()
+	for {
+		select {
+		case <-ticker.C:
+			b.send()
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop ends the broadcasts and closes the socket
+func (b *Broadcaster) Stop() error {
+	b.cancel()
+	if err := b.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// Group returns the address broadcasts are sent to
+func (b *Broadcaster) Group() net.Addr {
+	return b.group
+}
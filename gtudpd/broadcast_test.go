@@ -0,0 +1,89 @@
+package gtudpd
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBroadcasterSends(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	var calls atomic.Int32
+	config := &BroadcastConfig{Group: conn.LocalAddr().String(), Interval: 10 * time.Millisecond}
+	b, err := NewBroadcaster(config, func() []byte {
+		// Every other broadcast is skipped
+		if calls.Add(1)%2 == 0 {
+			return nil
+		}
+		return []byte("tick")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.TTL != DefaultBroadcastTTL {
+		t.Errorf("TTL = %d, want the default", config.TTL)
+	}
+	if b.Group().String() != conn.LocalAddr().String() {
+		t.Errorf("Group() = %s, want %s", b.Group(), conn.LocalAddr())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		b.Start()
+		close(done)
+	}()
+
+	buf := make([]byte, 16)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for i := 0; i < 2; i++ {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != "tick" {
+			t.Errorf("received %q", buf[:n])
+		}
+	}
+
+	if err := b.Stop(); err != nil {
+		t.Errorf("Stop() = %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Start did not return after Stop")
+	}
+	if calls.Load() < 3 {
+		t.Errorf("packet source called %d times, want at least 3", calls.Load())
+	}
+}
+
+func TestBroadcasterMulticastTTL(t *testing.T) {
+	config := &BroadcastConfig{Group: "224.0.1.1:123", TTL: 4}
+	b, err := NewBroadcaster(config, func() []byte { return nil })
+	if err != nil {
+		t.Skipf("multicast not available: %v", err)
+	}
+	defer func() { _ = b.Stop() }()
+	if config.Interval != DefaultBroadcastInterval {
+		t.Errorf("Interval = %v, want the default", config.Interval)
+	}
+}
+
+func TestNewBroadcasterErrors(t *testing.T) {
+	for _, config := range []*BroadcastConfig{
+		{Group: "224.0.1.1"},
+		{Group: "224.0.1.1:123", Interface: "no-such-interface"},
+	} {
+		if b, err := NewBroadcaster(config, nil); err == nil {
+			_ = b.Stop()
+			t.Errorf("NewBroadcaster(%+v) succeeded", config)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package gtudpd
+
+import "syscall"
+
+// reusePortSupported reports whether several sockets can share a port.
+const reusePortSupported = false
+
+// reusePortControl is a no-op where SO_REUSEPORT is unavailable.
+func reusePortControl(_, _ string, _ syscall.RawConn) error {
+	return nil
+}
@@ -4,6 +4,7 @@ package gtudpd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -12,6 +13,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/net/ipv4"
 )
 
 const (
@@ -26,11 +29,47 @@ const (
 	// DefaultResponseTimeout is the default timeout for response operations
 	DefaultResponseTimeout = 1 * time.Second
 	// DefaultReadTimeout is the default UDP read timeout to prevent blocking
+	//
+	// Deprecated: reads block until Stop closes the sockets.
 	DefaultReadTimeout = 10 * time.Millisecond
+	// DefaultSockets is the default number of SO_REUSEPORT sockets
+	DefaultSockets = 1
+	// DefaultBatchSize is the default number of datagrams read or written per system call
+	DefaultBatchSize = 32
 )
 
-// RequestHandler defines the interface for handling UDP requests
-type RequestHandler func(conn *net.UDPConn, n int, remoteaddr *net.UDPAddr, buf []byte)
+// RequestHandler defines the interface for handling UDP requests.
+// received is the time the datagram arrived, taken by the kernel when
+// receive timestamps are available, so queueing delay is not hidden from it.
+type RequestHandler func(conn ResponseWriter, n int, remoteaddr *net.UDPAddr, buf []byte, received time.Time)
+
+// TimestampMode selects how the receive time of a request is obtained
+type TimestampMode int
+
+const (
+	// TimestampSoftware uses kernel software receive stamps (SO_TIMESTAMPNS)
+	TimestampSoftware TimestampMode = iota
+	// TimestampHardware uses NIC receive stamps (SO_TIMESTAMPING) when the
+	// NIC clock agrees with the system clock, falling back to kernel
+	// software stamps
+	TimestampHardware
+	// TimestampUserspace reads the clock when the reader sees the datagram
+	TimestampUserspace
+)
+
+// String returns the name of the timestamp mode
+func (m TimestampMode) String() string {
+	switch m {
+	case TimestampSoftware:
+		return "software"
+	case TimestampHardware:
+		return "hardware"
+	case TimestampUserspace:
+		return "userspace"
+	default:
+		return "unknown"
+	}
+}
 
 // RequestValidator defines the interface for validating requests
 type RequestValidator func(n int, buf []byte, remoteIP net.IP) bool
@@ -44,12 +83,21 @@ type Config struct {
 	MaxRequestsPerIP       int
 	RateLimitWindow        time.Duration
 	ResponseTimeout        time.Duration
-	ReadTimeout            time.Duration
+	// Deprecated: reads block until Stop closes the sockets.
+	ReadTimeout time.Duration
+	// Sockets is the number of SO_REUSEPORT sockets bound to the port,
+	// each served by its own reader goroutine.
+	Sockets int
+	// BatchSize is the number of datagrams handled per recvmmsg/sendmmsg call.
+	BatchSize int
+	// Timestamping selects the source of request receive times. Kernel
+	// stamps degrade to user space ones where unsupported.
+	Timestamping TimestampMode
 }
 
 // Server represents a UDP server with rate limiting and security features
 type Server struct {
-	conn              *net.UDPConn
+	listeners         []*listener
 	handler           RequestHandler
 	validator         RequestValidator
 	responseSemaphore chan struct{}
@@ -69,9 +117,11 @@ type ipRateLimit struct {
 
 // workItem represents a request to be processed by worker pool
 type workItem struct {
+	writer     ResponseWriter
 	n          int
 	remoteAddr *net.UDPAddr
 	buf        []byte
+	received   time.Time
 }
 
 // validatePortFilePath checks if the port file path is safe to read
@@ -254,37 +304,89 @@ func setConfigDefaults(config *Config) {
 	if config.ReadTimeout <= 0 {
 		config.ReadTimeout = DefaultReadTimeout
 	}
+	if config.Sockets <= 0 || !reusePortSupported {
+		config.Sockets = DefaultSockets
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultBatchSize
+	}
 }
 
-// NewServer creates a new UDP server with configuration
-func NewServer(config *Config, handler RequestHandler, validator RequestValidator) (*Server, error) {
-	setConfigDefaults(config)
+// listenUDP binds a UDP socket, with SO_REUSEPORT when several are requested.
+func listenUDP(address string, reuse bool) (*net.UDPConn, error) {
+	lc := net.ListenConfig{}
+	if reuse {
+		lc.Control = reusePortControl
+	}
+	pc, err := lc.ListenPacket(context.Background(), "udp", address)
+	if err != nil {
+		if strings.Contains(err.Error(), "permission denied") {
+			return nil, fmt.Errorf(
+				"permission denied binding to port %s - try running as root or use a port >= 1024", address)
+		}
+		return nil, err
+	}
+	conn, ok := pc.(*net.UDPConn)
+	if !ok {
+		_ = pc.Close()
+		return nil, fmt.Errorf("unexpected connection type %T", pc)
+	}
+	return conn, nil
+}
+
+// closeConns closes every socket in conns.
+func closeConns(conns []*net.UDPConn) {
+	for _, c := range conns {
+		_ = c.Close()
+	}
+}
+
+// listenSockets binds config.Sockets sockets to the same address. The first
+// bind resolves an ephemeral port so the others can join it.
+func listenSockets(config *Config) ([]*net.UDPConn, error) {
 	port := config.GetPort()
 	servAddr, err := net.ResolveUDPAddr("udp", port)
 	if err != nil {
 		return nil, err
 	}
 
-	servConn, err := net.ListenUDP("udp", servAddr)
+	reuse := config.Sockets > 1
+	first, err := listenUDP(port, reuse)
 	if err != nil {
-		if strings.Contains(err.Error(), "permission denied") {
-			return nil, fmt.Errorf(
-				"permission denied binding to port %s - try running as root or use a port >= 1024", port)
-		}
 		return nil, err
 	}
+	conns := []*net.UDPConn{first}
 
-	// Set read timeout to prevent blocking on slow connections
-	err = servConn.SetReadDeadline(time.Now().Add(config.ReadTimeout))
+	bound, _ := first.LocalAddr().(*net.UDPAddr)
+	servAddr.Port = bound.Port
+	for len(conns) < config.Sockets {
+		conn, err := listenUDP(servAddr.String(), reuse)
+		if err != nil {
+			closeConns(conns)
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// NewServer creates a new UDP server with configuration
+func NewServer(config *Config, handler RequestHandler, validator RequestValidator) (*Server, error) {
+	setConfigDefaults(config)
+	conns, err := listenSockets(config)
 	if err != nil {
-		_ = servConn.Close()
-		return nil, fmt.Errorf("failed to set read deadline: %v", err)
+		return nil, err
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
+	listeners := make([]*listener, len(conns))
+	for i, conn := range conns {
+		listeners[i] = newListener(conn, config)
+	}
+
 	server := &Server{
-		conn:              servConn,
+		listeners:         listeners,
 		handler:           handler,
 		validator:         validator,
 		responseSemaphore: make(chan struct{}, config.MaxConcurrentResponses),
@@ -298,6 +400,11 @@ func NewServer(config *Config, handler RequestHandler, validator RequestValidato
 	// Start cleanup routine
 	go server.cleanupRateLimit()
 
+	// Start one response writer per socket
+	for _, l := range listeners {
+		go l.writer.run(ctx)
+	}
+
 	// Start worker pool
 	for i := 0; i < config.MaxConcurrentResponses; i++ {
 		go server.worker()
@@ -305,21 +412,40 @@ func NewServer(config *Config, handler RequestHandler, validator RequestValidato
 	return server, nil
 }
 
-// Start begins processing UDP requests
+// Start begins processing UDP requests, with one reader per socket.
+// It blocks until Stop is called.
 func (s *Server) Start() {
-	buf := make([]byte, s.config.MaxRequestSize)
-	s.handleClientRequests(buf)
+	var wg sync.WaitGroup
+	for _, l := range s.listeners {
+		wg.Add(1)
+		go func(l *listener) {
+			defer wg.Done()
+			s.handleClientRequests(l)
+		}(l)
+	}
+	wg.Wait()
 }
 
 // Stop gracefully shuts down the server
 func (s *Server) Stop() error {
 	s.cancel()
-	return s.conn.Close()
+	var err error
+	for _, l := range s.listeners {
+		if cerr := l.conn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
 }
 
 // Addr returns the server's listening address
 func (s *Server) Addr() net.Addr {
-	return s.conn.LocalAddr()
+	return s.listeners[0].conn.LocalAddr()
+}
+
+// Timestamping returns the receive timestamp mode actually in effect
+func (s *Server) Timestamping() TimestampMode {
+	return s.listeners[0].tsMode
 }
 
 // checkRateLimit checks if an IP is within rate limits
@@ -392,7 +518,8 @@ func (s *Server) cleanupRateLimit() {
 }
 
 // processRequest handles validation and response for a single request
-func (s *Server) processRequest(n int, remoteaddr *net.UDPAddr, buf []byte) {
+func (s *Server) processRequest(l *listener, m *ipv4.Message, remoteaddr *net.UDPAddr, received time.Time) {
+	n, buf := m.N, m.Buffers[0]
 	// Check rate limit first (cheapest check)
 	ipStr := s.getIPString(remoteaddr.IP)
 	if !s.checkRateLimit(ipStr) {
@@ -408,7 +535,7 @@ func (s *Server) processRequest(n int, remoteaddr *net.UDPAddr, buf []byte) {
 	copy(bufCopy, buf[:n])
 	// Use worker pool instead of spawning goroutine per request
 	select {
-	case s.workerPool <- workItem{n: n, remoteAddr: remoteaddr, buf: bufCopy}:
+	case s.workerPool <- workItem{writer: l.writer, n: n, remoteAddr: remoteaddr, buf: bufCopy, received: received}:
 		// Successfully queued work item
 	default:
 		// Worker pool full, drop request to prevent resource exhaustion
@@ -416,10 +543,10 @@ func (s *Server) processRequest(n int, remoteaddr *net.UDPAddr, buf []byte) {
 }
 
 // handleResponse processes the response with timeout protection
-func (s *Server) handleResponse(n int, remoteaddr *net.UDPAddr, buf []byte) {
+func (s *Server) handleResponse(item workItem) {
 	// Direct call without complex timeout handling for better performance
-	// The UDP write operation is typically fast and non-blocking
-	s.handler(s.conn, n, remoteaddr, buf)
+	// The write only queues the datagram for the batched writer
+	s.handler(item.writer, item.n, item.remoteAddr, item.buf, item.received)
 }
 
 // worker processes work items from the worker pool
@@ -427,48 +554,51 @@ func (s *Server) worker() {
 	for {
 		select {
 		case item := <-s.workerPool:
-			s.handleResponse(item.n, item.remoteAddr, item.buf)
+			s.handleResponse(item)
 		case <-s.ctx.Done():
 			return
 		}
 	}
 }
 
-// handleReadError processes UDP read errors
-func (*Server) handleReadError(err error) bool {
-	// Handle timeout errors silently to avoid log spam
-	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-		return false // Continue processing
-	}
-	return false // Continue processing
+// readStopped reports whether a read error means the socket is gone
+func (s *Server) readStopped(err error) bool {
+	return errors.Is(err, net.ErrClosed) || s.ctx.Err() != nil
 }
 
-// refreshDeadline updates the connection deadline
-func (s *Server) refreshDeadline() {
-	_ = s.conn.SetReadDeadline(time.Now().Add(s.config.ReadTimeout))
+// receiveTime returns the kernel receive time of m, or now without one
+func receiveTime(m *ipv4.Message, now time.Time) time.Time {
+	if m.NN > 0 {
+		if t, ok := parseReceiveTime(m.OOB[:m.NN]); ok {
+			return t
+		}
+	}
+	return now
 }
 
-// handleClientRequests processes incoming client requests
-func (s *Server) handleClientRequests(buf []byte) {
-	s.refreshDeadline()
-	deadlineRefresh := time.NewTicker(s.config.ReadTimeout / 2)
-	defer deadlineRefresh.Stop()
-	for {
-		select {
-		case <-s.ctx.Done():
-			return
-		case <-deadlineRefresh.C:
-			s.refreshDeadline()
-		default:
+// dispatchBatch hands every datagram of a received batch to processRequest
+func (s *Server) dispatchBatch(l *listener, count int) {
+	now := time.Now()
+	for i := 0; i < count; i++ {
+		m := &l.msgs[i]
+		remoteaddr, ok := m.Addr.(*net.UDPAddr)
+		if !ok {
+			continue
 		}
+		s.processRequest(l, m, remoteaddr, receiveTime(m, now))
+	}
+}
 
-		n, remoteaddr, err := s.conn.ReadFromUDP(buf)
+// handleClientRequests reads batches of requests from one socket until it is closed
+func (s *Server) handleClientRequests(l *listener) {
+	for {
+		count, err := l.pc.ReadBatch(l.msgs, 0)
 		if err != nil {
-			s.handleReadError(err)
-			s.refreshDeadline()
+			if s.readStopped(err) {
+				return
+			}
 			continue
 		}
-
-		s.processRequest(n, remoteaddr, buf)
+		s.dispatchBatch(l, count)
 	}
 }
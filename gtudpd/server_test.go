@@ -488,7 +488,7 @@ func TestIsValidNetworkName(t *testing.T) {
 }
 
 // Test helper functions
-func testHandler(conn *net.UDPConn, n int, remoteaddr *net.UDPAddr, buf []byte) {
+func testHandler(conn ResponseWriter, n int, remoteaddr *net.UDPAddr, buf []byte, _ time.Time) {
 	// Simple test handler that echoes back the request
 	_, _ = conn.WriteToUDP(buf[:n], remoteaddr)
 }
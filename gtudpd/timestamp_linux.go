@@ -0,0 +1,112 @@
+//go:build linux
+
+package gtudpd
+
+import (
+	"net"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// sizeofTimespec is the size of a kernel timespec in control messages.
+const sizeofTimespec = int(unsafe.Sizeof(unix.Timespec{}))
+
+// timestampOOBSize is the control buffer needed per datagram. SO_TIMESTAMPING
+// delivers three timespecs: software, legacy and raw hardware.
+var timestampOOBSize = unix.CmsgSpace(3 * sizeofTimespec)
+
+// hardwareTimestampFlags requests hardware receive stamps with a software
+// fallback. The NIC itself must also be configured (SIOCSHWTSTAMP) for
+// hardware stamps to appear.
+const hardwareTimestampFlags = unix.SOF_TIMESTAMPING_RX_HARDWARE |
+	unix.SOF_TIMESTAMPING_RAW_HARDWARE |
+	unix.SOF_TIMESTAMPING_RX_SOFTWARE |
+	unix.SOF_TIMESTAMPING_SOFTWARE
+
+// setTimestampOption applies a single socket option to conn.
+func setTimestampOption(conn *net.UDPConn, opt, value int) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, opt, value)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// enableTimestamps asks the kernel to attach receive times to datagrams,
+// degrading from hardware to software to user space stamps. It returns the
+// mode actually in effect.
+func enableTimestamps(conn *net.UDPConn, mode TimestampMode) TimestampMode {
+	if mode == TimestampHardware {
+		if setTimestampOption(conn, unix.SO_TIMESTAMPING, hardwareTimestampFlags) == nil {
+			return TimestampHardware
+		}
+		mode = TimestampSoftware
+	}
+	if mode == TimestampSoftware {
+		if setTimestampOption(conn, unix.SO_TIMESTAMPNS, 1) == nil {
+			return TimestampSoftware
+		}
+	}
+	return TimestampUserspace
+}
+
+// timespecAt decodes the i-th timespec of a control message payload.
+func timespecAt(data []byte, i int) (time.Time, bool) {
+	if len(data) < (i+1)*sizeofTimespec {
+		return time.Time{}, false
+	}
+	var ts unix.Timespec
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(&ts)), sizeofTimespec), data[i*sizeofTimespec:])
+	if ts.Sec == 0 && ts.Nsec == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(ts.Unix()), true
+}
+
+// hardwareStampWindow is how close to the software stamp a hardware stamp
+// must be to be used. The raw hardware stamp is read from the clock of the
+// NIC, which is often TAI or free running: it only stands for the system
+// time when something like phc2sys keeps the two together, and then it
+// precedes the software stamp by microseconds.
+const hardwareStampWindow = 10 * time.Millisecond
+
+// timestampingTime prefers the raw hardware stamp over the software one,
+// when the clock of the NIC agrees with the system clock.
+func timestampingTime(data []byte) (time.Time, bool) {
+	sw, swOK := timespecAt(data, 0)
+	hw, hwOK := timespecAt(data, 2)
+	if hwOK && (!swOK || sw.Sub(hw).Abs() < hardwareStampWindow) {
+		return hw, true
+	}
+	return sw, swOK
+}
+
+// parseReceiveTime extracts the kernel receive time from a datagram's
+// control messages.
+func parseReceiveTime(oob []byte) (time.Time, bool) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, m := range msgs {
+		if m.Header.Level != unix.SOL_SOCKET {
+			continue
+		}
+		switch m.Header.Type {
+		case unix.SCM_TIMESTAMPNS:
+			return timespecAt(m.Data, 0)
+		case unix.SCM_TIMESTAMPING:
+			return timestampingTime(m.Data)
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,71 @@
+//go:build linux
+
+package gtudpd
+
+import (
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// buildTimestampCmsg builds a SOL_SOCKET control message carrying timespecs
+func buildTimestampCmsg(typ int32, stamps ...time.Time) []byte {
+	data := make([]byte, len(stamps)*sizeofTimespec)
+	for i, st := range stamps {
+		ts := unix.NsecToTimespec(st.UnixNano())
+		if st.IsZero() {
+			ts = unix.Timespec{}
+		}
+		copy(data[i*sizeofTimespec:], unsafe.Slice((*byte)(unsafe.Pointer(&ts)), sizeofTimespec))
+	}
+
+	b := make([]byte, unix.CmsgSpace(len(data)))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = unix.SOL_SOCKET
+	h.Type = typ
+	h.SetLen(unix.CmsgLen(len(data)))
+	copy(b[unix.CmsgLen(0):], data)
+	return b
+}
+
+func TestParseReceiveTime(t *testing.T) {
+	sw := time.Unix(1700000000, 123456789)
+	hw := time.Unix(1700000000, 123000000)
+
+	tests := []struct {
+		name   string
+		oob    []byte
+		want   time.Time
+		wantOK bool
+	}{
+		{"SO_TIMESTAMPNS", buildTimestampCmsg(unix.SCM_TIMESTAMPNS, sw), sw, true},
+		{"SO_TIMESTAMPING hardware", buildTimestampCmsg(unix.SCM_TIMESTAMPING, sw, time.Time{}, hw), hw, true},
+		{"SO_TIMESTAMPING TAI hardware clock", buildTimestampCmsg(unix.SCM_TIMESTAMPING, sw, time.Time{}, hw.Add(37*time.Second)), sw, true},
+		{"SO_TIMESTAMPING software only", buildTimestampCmsg(unix.SCM_TIMESTAMPING, sw, time.Time{}, time.Time{}), sw, true},
+		{"unrelated message", buildTimestampCmsg(unix.SCM_RIGHTS, sw), time.Time{}, false},
+		{"garbage", []byte{1, 2, 3}, time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseReceiveTime(tt.oob)
+			if ok != tt.wantOK || !got.Equal(tt.want) {
+				t.Errorf("parseReceiveTime() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestServerKernelTimestamps(t *testing.T) {
+	server, err := NewServer(&Config{DefaultPort: ":0"}, testHandler, testValidator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = server.Stop() }()
+
+	if got := server.Timestamping(); got != TimestampSoftware {
+		t.Errorf("Timestamping() = %v, want %v", got, TimestampSoftware)
+	}
+}
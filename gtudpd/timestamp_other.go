@@ -0,0 +1,22 @@
+//go:build !linux
+
+package gtudpd
+
+import (
+	"net"
+	"time"
+)
+
+// timestampOOBSize is zero as no control messages are requested.
+var timestampOOBSize = 0
+
+// enableTimestamps falls back to user space stamps where kernel receive
+// timestamps are not supported.
+func enableTimestamps(_ *net.UDPConn, _ TimestampMode) TimestampMode {
+	return TimestampUserspace
+}
+
+// parseReceiveTime never finds a kernel receive time.
+func parseReceiveTime(_ []byte) (time.Time, bool) {
+	return time.Time{}, false
+}
@@ -1,6 +1,6 @@
 // Package main provides the gtclock multi-binary implementation.
 // gtclock can run as different programs based on the name it's called with:
-// gtclock, gtclockd, gntpclock, or gtailocal.
+// gtclock or any of the applets "gtclock help" lists.
 package main
 
 import (
@@ -14,20 +14,11 @@ import (
 func main() {
 	app := filepath.Base(os.Args[0])
 
-	commands := map[string]func(args []string) int{
-		"gtclock":     cmd.MainDispatcher, // fallback dispatcher (like busybox)
-		"gtailocal":   cmd.GTAILocalRun,
-		"gtclockd":    cmd.GTClockDRun,
-		"gtclockc":    cmd.GTClockCRun,
-		"gsntpclockc": cmd.GSNTPClockCRun,
-	}
-
-	// Check if command exists
-	fn, ok := commands[app]
-
+	// gtclock itself is the fallback dispatcher (like busybox)
+	fn, ok := cmd.Lookup(app)
 	if !ok {
 		_, _ = fmt.Fprintf(os.Stderr, "Unknown command: %s\n", app)
 		os.Exit(1)
 	}
-	fn(os.Args[1:])
+	os.Exit(fn(os.Args[1:]))
 }
@@ -0,0 +1,126 @@
+package refclock
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrChecksum is returned for a sentence whose checksum does not match
+	ErrChecksum = errors.New("NMEA checksum mismatch")
+	// ErrNoFix is returned for an RMC sentence without a valid fix
+	ErrNoFix = errors.New("GPS receiver has no fix")
+)
+
+// NMEA is a driver for GPS receivers sending NMEA 0183 sentences, over a
+// serial line or anything else that can be read. The time of the RMC
+// sentences is compared to the local time the sentence was read at, so the
+// samples carry the latency of the receiver and of the line: use the trim
+// option to make up for it.
+type NMEA struct {
+	r         io.ReadCloser
+	lines     *bufio.Reader
+	trim      time.Duration
+	precision int8
+	now       func() time.Time
+}
+
+// OpenNMEA opens the device at path, setting its speed when it is a serial
+// line and o.Baud is not 0.
+func OpenNMEA(path string, o Options) (Driver, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|noCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := configureSerial(f, o.Baud); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return NewNMEA(f, o), nil
+}
+
+// NewNMEA creates a driver reading sentences from r.
+func NewNMEA(r io.ReadCloser, o Options) *NMEA {
+	return &NMEA{r: r, lines: bufio.NewReader(r), trim: o.Trim, precision: o.Precision, now: time.Now}
+}
+
+// Sample reads sentences until an RMC one with a fix.
+func (n *NMEA) Sample() (Sample, error) {
+	for {
+		line, err := n.lines.ReadString('\n')
+		if err != nil {
+			return Sample{}, err
+		}
+		received := n.now()
+		t, err := ParseRMC(strings.TrimSpace(line))
+		if errors.Is(err, errNotRMC) {
+			continue
+		}
+		if err != nil {
+			return Sample{}, err
+		}
+		return Sample{Offset: t.Add(n.trim).Sub(received), Time: received, Leap: LeapNone}, nil
+	}
+}
+
+// RefID is GPS.
+func (*NMEA) RefID() string { return "GPS" }
+
+// Precision returns the precision of the driver.
+func (n *NMEA) Precision() int8 { return n.precision }
+
+// Close closes the device.
+func (n *NMEA) Close() error { return n.r.Close() }
+
+// errNotRMC is returned for a valid sentence of another type.
+var errNotRMC = errors.New("not an RMC sentence")
+
+// nmeaFields checks the checksum of a sentence and splits it into fields.
+func nmeaFields(sentence string) ([]string, error) {
+	body, sum, ok := strings.Cut(strings.TrimPrefix(sentence, "$"), "*")
+	if !ok || !strings.HasPrefix(sentence, "$") {
+		return nil, fmt.Errorf("invalid NMEA sentence %q", sentence)
+	}
+	want, err := strconv.ParseUint(sum, 16, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NMEA checksum %q", sum)
+	}
+	var got byte
+	for i := 0; i < len(body); i++ {
+		got ^= body[i]
+	}
+	if got != byte(want) {
+		return nil, ErrChecksum
+	}
+	return strings.Split(body, ","), nil
+}
+
+// ParseRMC returns the UTC time of an RMC sentence, from any talker, like
+//
+//	$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A
+func ParseRMC(sentence string) (time.Time, error) {
+	fields, err := nmeaFields(sentence)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(fields[0]) != 5 || fields[0][2:] != "RMC" {
+		return time.Time{}, errNotRMC
+	}
+	if len(fields) < 10 {
+		return time.Time{}, fmt.Errorf("short RMC sentence %q", sentence)
+	}
+	if fields[2] != "A" {
+		return time.Time{}, ErrNoFix
+	}
+	t, err := time.Parse("020106150405.999999999", fields[9]+fields[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid RMC time: %w", err)
+	}
+	return t, nil
+}
@@ -0,0 +1,83 @@
+//go:build linux
+
+package refclock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY returns the master side of a new pseudo-terminal and the path of
+// its slave side.
+func openPTY(t *testing.T) (*os.File, string) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	t.Cleanup(func() { _ = master.Close() })
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatal(err)
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestNMEAOverPTY(t *testing.T) {
+	master, slave := openPTY(t)
+	d, err := OpenNMEA(slave, Options{Baud: 9600, Precision: -9})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A receiver sending the sentences of the current second, as on time
+	now := time.Now().UTC().Truncate(time.Second)
+	body := fmt.Sprintf("GPRMC,%s,A,4807.038,N,01131.000,E,0.0,0.0,%s,,,A", now.Format("150405.00"), now.Format("020106"))
+	var sum byte
+	for i := 0; i < len(body); i++ {
+		sum ^= body[i]
+	}
+	canned := "$GPGSV,1,1,00*79\r\n" + fmt.Sprintf("$%s*%02X\r\n", body, sum)
+	if _, err := master.WriteString(canned); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := d.Sample()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := now.Sub(s.Time); s.Offset != want || s.Offset > 0 || s.Offset < -2*time.Second {
+		t.Errorf("Sample() offset = %v, want %v", s.Offset, want)
+	}
+	if d.RefID() != "GPS" || d.Precision() != -9 {
+		t.Errorf("RefID() = %s, Precision() = %d", d.RefID(), d.Precision())
+	}
+
+	// Closing interrupts a pending read
+	done := make(chan error, 1)
+	go func() {
+		_, err := d.Sample()
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrClosed) {
+			t.Errorf("Sample() after Close = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close did not interrupt Sample")
+	}
+}
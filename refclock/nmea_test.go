@@ -0,0 +1,59 @@
+package refclock
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRMC(t *testing.T) {
+	tests := []struct {
+		sentence string
+		want     time.Time
+		wantErr  error
+	}{
+		{"$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A",
+			time.Date(1994, 3, 23, 12, 35, 19, 0, time.UTC), nil},
+		{"$GNRMC,000001.50,A,4807.038,N,01131.000,E,0.0,0.0,181026,,,A*4A",
+			time.Date(2026, 10, 18, 0, 0, 1, 500*int(time.Millisecond), time.UTC), nil},
+		{"$GPRMC,123520,V,,,,,,,230394,,,N*5B", time.Time{}, ErrNoFix},
+		{"$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6B", time.Time{}, ErrChecksum},
+		{"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47", time.Time{}, errNotRMC},
+	}
+	for _, tt := range tests {
+		got, err := ParseRMC(tt.sentence)
+		if !got.Equal(tt.want) || !errors.Is(err, tt.wantErr) {
+			t.Errorf("ParseRMC(%q) = %v, %v", tt.sentence, got, err)
+		}
+	}
+	if _, err := ParseRMC("GPRMC,123519"); err == nil {
+		t.Error("ParseRMC() accepted a sentence without $ and checksum")
+	}
+}
+
+func TestNMEASample(t *testing.T) {
+	input := strings.Join([]string{
+		"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47",
+		"$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A",
+		"$GPRMC,123520,V,,,,,,,230394,,,N*5B",
+	}, "\r\n") + "\r\n"
+	n := NewNMEA(io.NopCloser(strings.NewReader(input)), Options{Trim: 100 * time.Millisecond, Precision: -9})
+	local := time.Date(1994, 3, 23, 12, 35, 19, 300*int(time.Millisecond), time.UTC)
+	n.now = func() time.Time { return local }
+
+	s, err := n.Sample()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Offset != -200*time.Millisecond || !s.Time.Equal(local) || s.Pulse {
+		t.Errorf("Sample() = %+v", s)
+	}
+	if _, err := n.Sample(); !errors.Is(err, ErrNoFix) {
+		t.Errorf("Sample() without a fix = %v", err)
+	}
+	if _, err := n.Sample(); !errors.Is(err, io.EOF) {
+		t.Errorf("Sample() at the end = %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+//go:build linux
+
+package refclock
+
+import (
+	"errors"
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrNoPulse is returned when no pulse arrived within ppsTimeout.
+var ErrNoPulse = errors.New("no PPS pulse")
+
+// ppsTimeout is how long Sample waits for a pulse.
+const ppsTimeout = 2 * time.Second
+
+// ppsKTime is struct pps_ktime of linux/pps.h.
+type ppsKTime struct {
+	Sec   int64
+	Nsec  int32
+	Flags uint32
+}
+
+// ppsFData is struct pps_fdata of linux/pps.h: the struct pps_kinfo the
+// kernel fills in, followed by the timeout.
+type ppsFData struct {
+	AssertSequence uint32
+	ClearSequence  uint32
+	AssertTu       ppsKTime
+	ClearTu        ppsKTime
+	CurrentMode    int32
+	Timeout        ppsKTime
+}
+
+// ppsFetch is PPS_FETCH, _IOWR('p', 0xa4, struct pps_fdata *): the kernel
+// header encodes the size of a pointer, not of the struct.
+const ppsFetch = 3<<30 | uintptr(unsafe.Sizeof(uintptr(0)))<<16 | 'p'<<8 | 0xa4
+
+// PPS is a driver for the pulse per second signal of a GPS receiver, read
+// from a Linux /dev/ppsN device through the PPS API. A pulse only tells where
+// a second starts, so its samples are pulses: they refine the time another
+// source, like an NMEA or SHM one, gets right to the second.
+type PPS struct {
+	f         *os.File
+	trim      time.Duration
+	precision int8
+	last      uint32
+}
+
+// OpenPPS opens the PPS device at path, which must capture assert events,
+// the default of most devices.
+func OpenPPS(path string, o Options) (Driver, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &PPS{f: f, trim: o.Trim, precision: o.Precision}
+	if _, err := p.fetch(0); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// fetch returns the last assert event, waiting up to timeout for a new one.
+func (p *PPS) fetch(timeout time.Duration) (ppsFData, error) {
+	data := ppsFData{Timeout: ppsKTime{Sec: int64(timeout / time.Second), Nsec: int32(timeout % time.Second)}}
+	rc, err := p.f.SyscallConn()
+	if err != nil {
+		return data, err
+	}
+	var errno unix.Errno
+	err = rc.Control(func(fd uintptr) {
+		_, _, errno = unix.Syscall(unix.SYS_IOCTL, fd, ppsFetch, uintptr(unsafe.Pointer(&data)))
+	})
+	if err != nil {
+		// Control only fails once the file is closed
+		return data, &os.PathError{Op: "ioctl", Path: p.f.Name(), Err: os.ErrClosed}
+	}
+	if errno != 0 && errno != unix.ETIMEDOUT {
+		return data, errno
+	}
+	return data, nil
+}
+
+// Sample waits for the next pulse.
+func (p *PPS) Sample() (Sample, error) {
+	data, err := p.fetch(ppsTimeout)
+	if err != nil {
+		return Sample{}, err
+	}
+	if data.AssertSequence == p.last {
+		return Sample{}, ErrNoPulse
+	}
+	p.last = data.AssertSequence
+	t := time.Unix(data.AssertTu.Sec, int64(data.AssertTu.Nsec))
+	return Sample{Offset: pulseOffset(t) + p.trim, Time: t, Leap: LeapNone, Pulse: true}, nil
+}
+
+// RefID is PPS.
+func (*PPS) RefID() string { return "PPS" }
+
+// Precision returns the precision of the driver.
+func (p *PPS) Precision() int8 { return p.precision }
+
+// Close closes the device.
+func (p *PPS) Close() error { return p.f.Close() }
@@ -0,0 +1,21 @@
+//go:build linux
+
+package refclock
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestOpenPPSMissing(t *testing.T) {
+	if _, err := OpenPPS("/nonexistent/pps0", Options{}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("OpenPPS() = %v", err)
+	}
+}
+
+func TestPPSFetchRequest(t *testing.T) {
+	if ppsFetch != 0xc00870a4 && ppsFetch != 0xc00470a4 {
+		t.Errorf("PPS_FETCH = %#x", ppsFetch)
+	}
+}
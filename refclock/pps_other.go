@@ -0,0 +1,8 @@
+//go:build !linux
+
+package refclock
+
+// OpenPPS fails: the PPS API is only read on Linux.
+func OpenPPS(string, Options) (Driver, error) {
+	return nil, ErrUnsupported
+}
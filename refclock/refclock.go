@@ -0,0 +1,153 @@
+// Package refclock reads reference clocks, like GPS receivers, attached to
+// the host.
+//
+// A Driver yields samples of the offset of a reference clock from the local
+// clock. Drivers are opened from a specification of the form
+//
+//	driver:path[,option=value...]
+//
+// where driver is nmea, pps or shm, path is the device to read, or the unit
+// for shm, and the options are baud (nmea only), trim, a duration added to
+// every offset, and precision, the log2 of the precision of the clock in
+// seconds.
+package refclock
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUnknownDriver is returned for a specification naming no driver
+	ErrUnknownDriver = errors.New("unknown reference clock driver")
+	// ErrUnsupported is returned for a driver the platform lacks
+	ErrUnsupported = errors.New("reference clock driver not supported on this platform")
+	// ErrBadOption is returned for an invalid option in a specification
+	ErrBadOption = errors.New("invalid reference clock option")
+)
+
+// Leap indicator values, as in NTP.
+const (
+	LeapNone byte = iota
+	LeapInsert
+	LeapDelete
+	LeapUnknown
+)
+
+// Sample is a reading of a reference clock.
+type Sample struct {
+	// Offset is the reference time minus the local time
+	Offset time.Duration
+	// Time is when the sample was taken, by the local clock
+	Time time.Time
+	// Leap is the leap indicator of the clock
+	Leap byte
+	// Pulse marks offsets only known modulo a second, as those of a PPS
+	// signal are: the clock must be within half a second for them to apply
+	Pulse bool
+}
+
+// Driver reads a reference clock.
+type Driver interface {
+	// Sample blocks until the next reading of the clock
+	Sample() (Sample, error)
+	// RefID is the NTP reference ID of the clock, like GPS or PPS
+	RefID() string
+	// Precision is the log2 of the precision of the readings in seconds
+	Precision() int8
+	// Close releases the device, making a pending Sample return
+	Close() error
+}
+
+// Options tune a driver.
+type Options struct {
+	// Baud is the speed of a serial device, 0 to leave it as it is
+	Baud int
+	// Trim is added to every offset, to make up for the latency of the
+	// device
+	Trim time.Duration
+	// Precision is the log2 of the precision of the clock in seconds, 0
+	// for shm to take the one of the segment
+	Precision int8
+}
+
+// driverInfo describes a driver.
+type driverInfo struct {
+	open      func(path string, o Options) (Driver, error)
+	precision int8
+}
+
+// drivers are the known drivers, by name.
+var drivers = map[string]driverInfo{
+	"nmea": {open: OpenNMEA, precision: -9},
+	"pps":  {open: OpenPPS, precision: -20},
+	// The precision of shm comes from the segment
+	"shm": {open: OpenSHM},
+}
+
+// Drivers returns the names of the drivers, sorted.
+func Drivers() []string {
+	return slices.Sorted(maps.Keys(drivers))
+}
+
+// Open opens the reference clock described by spec.
+func Open(spec string) (Driver, error) {
+	name, path, opts, err := ParseSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	return drivers[name].open(path, opts)
+}
+
+// ParseSpec splits a specification into its driver, path and options.
+func ParseSpec(spec string) (string, string, Options, error) {
+	name, rest, _ := strings.Cut(spec, ":")
+	info, ok := drivers[name]
+	if !ok {
+		return "", "", Options{}, fmt.Errorf("%w: %q", ErrUnknownDriver, name)
+	}
+	fields := strings.Split(rest, ",")
+	opts := Options{Precision: info.precision}
+	for _, field := range fields[1:] {
+		if err := opts.set(field); err != nil {
+			return "", "", Options{}, err
+		}
+	}
+	if fields[0] == "" {
+		return "", "", Options{}, fmt.Errorf("%w: no device in %q", ErrBadOption, spec)
+	}
+	return name, fields[0], opts, nil
+}
+
+// set applies a name=value option.
+func (o *Options) set(field string) error {
+	name, value, _ := strings.Cut(field, "=")
+	var err error
+	switch name {
+	case "baud":
+		o.Baud, err = strconv.Atoi(value)
+	case "trim":
+		o.Trim, err = time.ParseDuration(value)
+	case "precision":
+		var p int64
+		p, err = strconv.ParseInt(value, 10, 8)
+		o.Precision = int8(p)
+	default:
+		return fmt.Errorf("%w: %q", ErrBadOption, name)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrBadOption, name, err)
+	}
+	return nil
+}
+
+// pulseOffset returns the offset of a pulse marking the start of a second
+// the local clock read at t: the distance to the nearest whole second.
+func pulseOffset(t time.Time) time.Duration {
+	return t.Round(time.Second).Sub(t)
+}
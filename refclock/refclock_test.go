@@ -0,0 +1,56 @@
+package refclock
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseSpec(t *testing.T) {
+	tests := []struct {
+		spec     string
+		wantName string
+		wantPath string
+		wantOpts Options
+		wantErr  error
+	}{
+		{"nmea:/dev/ttyS0", "nmea", "/dev/ttyS0", Options{Precision: -9}, nil},
+		{"nmea:/dev/ttyUSB0,baud=4800,trim=120ms", "nmea", "/dev/ttyUSB0", Options{Baud: 4800, Trim: 120 * time.Millisecond, Precision: -9}, nil},
+		{"pps:/dev/pps0,trim=-2us,precision=-18", "pps", "/dev/pps0", Options{Trim: -2 * time.Microsecond, Precision: -18}, nil},
+		{"shm:2,trim=-1ms", "shm", "2", Options{Trim: -time.Millisecond}, nil},
+		{"gps:0", "", "", Options{}, ErrUnknownDriver},
+		{"nmea:", "", "", Options{}, ErrBadOption},
+		{"nmea:/dev/ttyS0,parity=even", "", "", Options{}, ErrBadOption},
+		{"pps:/dev/pps0,precision=-200", "", "", Options{}, ErrBadOption},
+	}
+	for _, tt := range tests {
+		name, path, opts, err := ParseSpec(tt.spec)
+		if name != tt.wantName || path != tt.wantPath || opts != tt.wantOpts || !errors.Is(err, tt.wantErr) {
+			t.Errorf("ParseSpec(%q) = %q, %q, %+v, %v", tt.spec, name, path, opts, err)
+		}
+	}
+}
+
+func TestDrivers(t *testing.T) {
+	if got := Drivers(); !slices.Equal(got, []string{"nmea", "pps", "shm"}) {
+		t.Errorf("Drivers() = %q", got)
+	}
+}
+
+func TestPulseOffset(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	tests := []struct {
+		local time.Duration
+		want  time.Duration
+	}{
+		{0, 0},
+		{3 * time.Millisecond, -3 * time.Millisecond},
+		{999 * time.Millisecond, time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := pulseOffset(base.Add(tt.local)); got != tt.want {
+			t.Errorf("pulseOffset(+%v) = %v, want %v", tt.local, got, tt.want)
+		}
+	}
+}
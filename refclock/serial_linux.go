@@ -0,0 +1,69 @@
+//go:build linux
+
+package refclock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// noCTTY keeps a serial line from becoming the controlling terminal.
+const noCTTY = unix.O_NOCTTY
+
+// baudRates are the termios speeds of the supported baud rates.
+var baudRates = map[int]uint32{
+	4800:   unix.B4800,
+	9600:   unix.B9600,
+	19200:  unix.B19200,
+	38400:  unix.B38400,
+	57600:  unix.B57600,
+	115200: unix.B115200,
+}
+
+// configureSerial puts a serial line in raw mode, at speed baud unless it is
+// 0. Files that are not terminals are left alone.
+func configureSerial(f *os.File, baud int) error {
+	// Fd would put the file in blocking mode, keeping Close from
+	// interrupting a read
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = setRaw(int(fd), baud)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// setRaw configures the terminal fd.
+func setRaw(fd int, baud int) error {
+	tio, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.EINVAL) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	tio.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	tio.Oflag &^= unix.OPOST
+	tio.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	tio.Cflag &^= unix.CSIZE | unix.PARENB
+	tio.Cflag |= unix.CS8 | unix.CREAD | unix.CLOCAL
+	tio.Cc[unix.VMIN], tio.Cc[unix.VTIME] = 1, 0
+	if baud != 0 {
+		speed, ok := baudRates[baud]
+		if !ok {
+			return fmt.Errorf("%w: unsupported baud rate %d", ErrBadOption, baud)
+		}
+		tio.Cflag = tio.Cflag&^unix.CBAUD | speed
+		tio.Ispeed, tio.Ospeed = speed, speed
+	}
+	return unix.IoctlSetTermios(fd, unix.TCSETS, tio)
+}
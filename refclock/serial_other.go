@@ -0,0 +1,20 @@
+//go:build !linux
+
+package refclock
+
+import (
+	"fmt"
+	"os"
+)
+
+// noCTTY is not needed where serial lines are not configured.
+const noCTTY = 0
+
+// configureSerial only accepts leaving the speed as it is: serial lines are
+// only configured on Linux, elsewhere use stty(1).
+func configureSerial(_ *os.File, baud int) error {
+	if baud != 0 {
+		return fmt.Errorf("%w: baud", ErrUnsupported)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+//go:build linux
+
+package refclock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrSHMMode is returned for a segment in a mode other than 0 or 1.
+var ErrSHMMode = errors.New("unknown SHM segment mode")
+
+const (
+	// shmKeyBase is the System V IPC key of unit 0, "NTP0"
+	shmKeyBase = 0x4e545030
+	// shmUnits is the number of units read, 0 to 3
+	shmUnits = 4
+	// shmPoll is how often a segment is checked for a new sample
+	shmPoll = 100 * time.Millisecond
+)
+
+// shmTime is struct shmTime of ntpd's refclock_shm.c, its time_t being a C
+// long as Go's int is on Linux.
+type shmTime struct {
+	Mode                 int32
+	Count                int32
+	ClockTimeStampSec    int
+	ClockTimeStampUSec   int32
+	ReceiveTimeStampSec  int
+	ReceiveTimeStampUSec int32
+	Leap                 int32
+	Precision            int32
+	Nsamples             int32
+	Valid                int32
+	ClockTimeStampNSec   uint32
+	ReceiveTimeStampNSec uint32
+	Dummy                [8]int32
+}
+
+// SHM is a driver for the shared memory segments ntpd reads reference
+// clocks from, and gpsd writes its time to. The writer fills in the time of
+// the clock and the local time it was received at, then sets valid; in mode
+// 1 it also increments count before and after, so a sample that changed
+// while it was read is skipped. The segment is created when missing, units 0
+// and 1 only for root, so the writer may start later.
+type SHM struct {
+	mu        sync.Mutex
+	data      []byte
+	seg       *shmTime
+	trim      time.Duration
+	precision int8
+	// segPrecision is the precision last written to the segment
+	segPrecision atomic.Int32
+}
+
+// OpenSHM attaches to the segment of unit path, 0 to 3. Without a
+// precision option that of the segment is used.
+func OpenSHM(path string, o Options) (Driver, error) {
+	unit, err := strconv.Atoi(path)
+	if err != nil || unit < 0 || unit >= shmUnits {
+		return nil, fmt.Errorf("%w: SHM unit %q, want 0 to %d", ErrBadOption, path, shmUnits-1)
+	}
+	perm := 0o666
+	if unit < 2 {
+		perm = 0o600
+	}
+	id, err := unix.SysvShmGet(shmKeyBase+unit, int(unsafe.Sizeof(shmTime{})), unix.IPC_CREAT|perm)
+	if err != nil {
+		return nil, fmt.Errorf("SHM unit %d: %w", unit, err)
+	}
+	return attachSHM(id, o)
+}
+
+// attachSHM attaches to the segment id.
+func attachSHM(id int, o Options) (*SHM, error) {
+	data, err := unix.SysvShmAttach(id, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < int(unsafe.Sizeof(shmTime{})) {
+		_ = unix.SysvShmDetach(data)
+		return nil, fmt.Errorf("SHM segment of %d bytes is too small", len(data))
+	}
+	s := &SHM{data: data, seg: (*shmTime)(unsafe.Pointer(&data[0])), trim: o.Trim, precision: o.Precision}
+	s.segPrecision.Store(-1)
+	return s, nil
+}
+
+// stamp returns the time of a timestamp, with the nanoseconds when the
+// writer filled them in.
+func stamp(sec int, usec int32, nsec uint32) time.Time {
+	if nsec/1000 == uint32(usec) {
+		return time.Unix(int64(sec), int64(nsec))
+	}
+	return time.Unix(int64(sec), int64(usec)*1000)
+}
+
+// read takes the sample in the segment, if there is a valid one.
+func (s *SHM) read() (Sample, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seg == nil {
+		return Sample{}, false, os.ErrClosed
+	}
+	count := atomic.LoadInt32(&s.seg.Count)
+	if atomic.LoadInt32(&s.seg.Valid) == 0 {
+		return Sample{}, false, nil
+	}
+	t := *s.seg
+	switch {
+	case t.Mode != 0 && t.Mode != 1:
+		return Sample{}, false, fmt.Errorf("%w %d", ErrSHMMode, t.Mode)
+	case t.Mode == 1 && atomic.LoadInt32(&s.seg.Count) != count:
+		// The writer was at work, the next poll gets its sample
+		return Sample{}, false, nil
+	}
+	atomic.StoreInt32(&s.seg.Valid, 0)
+	s.segPrecision.Store(t.Precision)
+
+	clock := stamp(t.ClockTimeStampSec, t.ClockTimeStampUSec, t.ClockTimeStampNSec)
+	received := stamp(t.ReceiveTimeStampSec, t.ReceiveTimeStampUSec, t.ReceiveTimeStampNSec)
+	return Sample{Offset: clock.Add(s.trim).Sub(received), Time: received, Leap: byte(t.Leap) & 3}, true, nil
+}
+
+// Sample waits for the writer to put a sample in the segment.
+func (s *SHM) Sample() (Sample, error) {
+	for {
+		sample, ok, err := s.read()
+		if ok || err != nil {
+			return sample, err
+		}
+		time.Sleep(shmPoll)
+	}
+}
+
+// RefID is SHM.
+func (*SHM) RefID() string { return "SHM" }
+
+// Precision returns the precision given as option or else the one of the
+// segment.
+func (s *SHM) Precision() int8 {
+	if s.precision != 0 {
+		return s.precision
+	}
+	return int8(s.segPrecision.Load())
+}
+
+// Close detaches from the segment.
+func (s *SHM) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seg == nil {
+		return nil
+	}
+	s.seg = nil
+	return unix.SysvShmDetach(s.data)
+}
@@ -0,0 +1,109 @@
+//go:build linux
+
+package refclock
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// testSegment creates a private segment, returning the driver reading it
+// and the writer's view of it.
+func testSegment(t *testing.T, o Options) (*SHM, *shmTime) {
+	t.Helper()
+	id, err := unix.SysvShmGet(unix.IPC_PRIVATE, int(unsafe.Sizeof(shmTime{})), unix.IPC_CREAT|0o600)
+	if err != nil {
+		t.Skipf("no System V shared memory: %v", err)
+	}
+	t.Cleanup(func() { _, _ = unix.SysvShmCtl(id, unix.IPC_RMID, nil) })
+	data, err := unix.SysvShmAttach(id, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = unix.SysvShmDetach(data) })
+	s, err := attachSHM(id, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s, (*shmTime)(unsafe.Pointer(&data[0]))
+}
+
+// write puts a sample in the segment as gpsd does in mode 1.
+func write(seg *shmTime, clock, received time.Time, precision int32) {
+	atomic.AddInt32(&seg.Count, 1)
+	seg.Mode = 1
+	seg.ClockTimeStampSec, seg.ClockTimeStampNSec = int(clock.Unix()), uint32(clock.Nanosecond())
+	seg.ClockTimeStampUSec = int32(clock.Nanosecond() / 1000)
+	seg.ReceiveTimeStampSec, seg.ReceiveTimeStampNSec = int(received.Unix()), uint32(received.Nanosecond())
+	seg.ReceiveTimeStampUSec = int32(received.Nanosecond() / 1000)
+	seg.Precision = precision
+	atomic.AddInt32(&seg.Count, 1)
+	atomic.StoreInt32(&seg.Valid, 1)
+}
+
+func TestSHMSample(t *testing.T) {
+	s, seg := testSegment(t, Options{Trim: time.Millisecond})
+	received := time.Unix(1700000000, 250_000_000)
+	write(seg, received.Add(1500*time.Microsecond+7), received, -20)
+
+	sample, err := s.Sample()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sample.Offset != 2500*time.Microsecond+7 || !sample.Time.Equal(received) || sample.Leap != LeapNone {
+		t.Errorf("Sample() = %+v", sample)
+	}
+	if seg.Valid != 0 {
+		t.Error("valid not cleared")
+	}
+	if s.Precision() != -20 || s.RefID() != "SHM" {
+		t.Errorf("Precision() = %d, RefID() = %s", s.Precision(), s.RefID())
+	}
+	if _, ok, err := s.read(); ok || err != nil {
+		t.Errorf("read() of a taken sample = %v, %v", ok, err)
+	}
+}
+
+func TestSHMProtocol(t *testing.T) {
+	s, seg := testSegment(t, Options{Precision: -10})
+	now := time.Now()
+
+	// Microseconds only, as older writers leave the nanoseconds alone
+	write(seg, now, now, -1)
+	seg.ClockTimeStampNSec, seg.ReceiveTimeStampNSec = 0, 0
+	seg.ClockTimeStampUSec += 3
+	if sample, ok, err := s.read(); !ok || err != nil || sample.Offset != 3*time.Microsecond {
+		t.Errorf("read() = %+v, %v, %v", sample, ok, err)
+	}
+	if s.Precision() != -10 {
+		t.Errorf("Precision() = %d, want the option", s.Precision())
+	}
+
+	write(seg, now, now, -1)
+	seg.Mode = 2
+	if _, _, err := s.read(); !errors.Is(err, ErrSHMMode) {
+		t.Errorf("read() in mode 2 = %v", err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Sample(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Sample() after Close = %v", err)
+	}
+}
+
+func TestOpenSHMUnit(t *testing.T) {
+	for _, unit := range []string{"4", "-1", "x"} {
+		if _, err := OpenSHM(unit, Options{}); !errors.Is(err, ErrBadOption) {
+			t.Errorf("OpenSHM(%q) = %v", unit, err)
+		}
+	}
+}
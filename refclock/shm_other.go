@@ -0,0 +1,8 @@
+//go:build !linux
+
+package refclock
+
+// OpenSHM fails: SHM segments are only read on Linux.
+func OpenSHM(string, Options) (Driver, error) {
+	return nil, ErrUnsupported
+}